@@ -0,0 +1,114 @@
+package anonymize
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+
+	"github.com/m-lab/go/flagx"
+)
+
+// CryptoPAnKeySize is the size, in bytes, of a Crypto-PAn key. The first half
+// of the key is used as the AES-128 key and the second half is encrypted to
+// produce the secret pad.
+const CryptoPAnKeySize = 32
+
+var (
+	// CryptoPAnKey holds the secret key used by the "cryptopan" anonymization
+	// method. It is filled from the file named by the `--anonymize.cryptopan-key`
+	// command-line flag. The file should contain either exactly 32 raw bytes
+	// or those 32 bytes hex-encoded.
+	CryptoPAnKey flagx.FileBytes
+
+	// ErrBadCryptoPAnKey is returned when a Crypto-PAn key has the wrong size.
+	ErrBadCryptoPAnKey = errors.New("crypto-pan keys must be 32 bytes (or 64 hex characters)")
+)
+
+// ParseCryptoPAnKey converts the contents of a key file into a 32-byte key.
+// Raw 32 byte keys are returned unchanged. Anything else is interpreted as
+// hex, with surrounding whitespace removed.
+func ParseCryptoPAnKey(b []byte) ([]byte, error) {
+	if len(b) == CryptoPAnKeySize {
+		return b, nil
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil || len(key) != CryptoPAnKeySize {
+		return nil, ErrBadCryptoPAnKey
+	}
+	return key, nil
+}
+
+// cryptopanAnonymizer implements the prefix-preserving anonymization scheme
+// described in "Prefix-Preserving IP Address Anonymization:
+// Measurement-based Security Evaluation and a New Cryptography-based Scheme"
+// by Fan, Xu, Ammar, and Moon (2002). The mapping is one-to-one, and two
+// addresses sharing a k-bit prefix will have anonymized forms that share a
+// k-bit prefix. IPv6 addresses are handled by extending the scheme to all 128
+// bits of the address.
+type cryptopanAnonymizer struct {
+	block cipher.Block
+	pad   [aes.BlockSize]byte
+}
+
+// NewCryptoPAn creates an IPAnonymizer using the Crypto-PAn scheme with the
+// given 32-byte key. Most programs should call New(CryptoPAn) and set the key
+// with the `--anonymize.cryptopan-key` flag instead.
+func NewCryptoPAn(key []byte) (IPAnonymizer, error) {
+	if len(key) != CryptoPAnKeySize {
+		return nil, ErrBadCryptoPAnKey
+	}
+	block, err := aes.NewCipher(key[:aes.BlockSize])
+	if err != nil {
+		return nil, err
+	}
+	c := &cryptopanAnonymizer{block: block}
+	block.Encrypt(c.pad[:], key[aes.BlockSize:])
+	return c, nil
+}
+
+func (c *cryptopanAnonymizer) IP(ip net.IP) {
+	if ip == nil {
+		return
+	}
+	if isIgnored(ip) {
+		return
+	}
+	if ip.To4() != nil {
+		// Only the last four bytes hold the address in both the 4-byte v4
+		// representation and the v4-in-v6 representation.
+		c.anonymize(ip[len(ip)-net.IPv4len:])
+		return
+	}
+	if ip.To16() != nil {
+		c.anonymize(ip)
+		return
+	}
+	log.Println("The passed in IP address was neither a v4 nor a v6 address:", ip)
+}
+
+// anonymize rewrites addr in place. For every bit position i, the first i bits
+// of the original address are combined with the remaining bits of the pad and
+// encrypted. The most significant bit of the result is the one-time-pad bit
+// for position i.
+func (c *cryptopanAnonymizer) anonymize(addr []byte) {
+	var orig [net.IPv6len]byte
+	copy(orig[:], addr)
+	var in, out [aes.BlockSize]byte
+	for pos := 0; pos < 8*len(addr); pos++ {
+		in = c.pad
+		full, rem := pos/8, pos%8
+		copy(in[:full], orig[:full])
+		if rem != 0 {
+			mask := byte(0xff) << (8 - rem)
+			in[full] = orig[full]&mask | c.pad[full]&^mask
+		}
+		c.block.Encrypt(out[:], in[:])
+		if out[0]&0x80 != 0 {
+			addr[full] ^= 0x80 >> rem
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package anonymize_test
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/m-lab/go/anonymize"
+	"github.com/m-lab/go/rtx"
+)
+
+// The key and the test vectors below are the ones published with the
+// reference implementation of Crypto-PAn.
+var cryptopanTestKey = []byte{
+	21, 34, 23, 141, 51, 164, 207, 128, 19, 10, 91, 22, 73, 144, 125, 16,
+	216, 152, 143, 131, 121, 121, 101, 39, 98, 87, 76, 45, 42, 132, 34, 2,
+}
+
+var cryptopanTestVectors = []struct {
+	ip   string
+	want string
+}{
+	{"128.11.68.132", "135.242.180.132"},
+	{"129.118.74.4", "134.136.186.123"},
+	{"130.132.252.244", "133.68.164.234"},
+	{"141.223.7.43", "141.167.8.160"},
+	{"141.233.145.108", "141.129.237.235"},
+	{"152.163.225.39", "151.140.114.167"},
+	{"156.29.3.236", "147.225.12.42"},
+	{"165.247.96.84", "162.9.99.234"},
+	{"166.107.77.190", "160.132.178.185"},
+	{"192.102.249.13", "252.138.62.131"},
+	{"192.215.32.125", "252.43.47.189"},
+	{"192.233.80.103", "252.25.108.8"},
+	{"192.41.57.43", "252.222.221.184"},
+	{"193.150.244.223", "253.169.52.216"},
+	{"195.205.63.100", "255.186.223.5"},
+	{"198.200.171.101", "249.199.68.213"},
+	{"198.26.132.101", "249.36.123.202"},
+	{"198.36.213.5", "249.7.21.132"},
+	{"198.51.77.238", "249.18.186.254"},
+	{"199.217.79.101", "248.38.184.213"},
+	{"202.49.198.20", "245.206.7.234"},
+	{"203.12.160.252", "244.248.163.4"},
+	{"204.184.162.189", "243.192.77.90"},
+	{"204.202.136.230", "243.178.4.198"},
+	{"204.29.20.4", "243.33.20.123"},
+	{"205.178.38.67", "242.108.198.51"},
+	{"205.188.147.153", "242.96.16.101"},
+	{"205.188.248.25", "242.96.88.27"},
+	{"205.245.121.43", "242.21.121.163"},
+	{"207.105.49.5", "241.118.205.138"},
+	{"207.135.65.238", "241.202.129.222"},
+	{"207.155.9.214", "241.220.250.22"},
+	{"207.188.7.45", "241.255.249.220"},
+	{"207.25.71.27", "241.33.119.156"},
+	{"207.33.151.131", "241.1.233.131"},
+	{"208.147.89.59", "227.237.98.191"},
+	{"208.234.120.210", "227.154.67.17"},
+	{"208.28.185.184", "227.39.94.90"},
+	{"208.52.56.122", "227.8.63.165"},
+	{"209.12.231.7", "226.243.167.8"},
+	{"209.238.72.3", "226.6.119.243"},
+	{"209.246.74.109", "226.22.124.76"},
+	{"209.68.60.238", "226.184.220.233"},
+	{"209.85.249.6", "226.170.70.6"},
+	{"212.120.124.31", "228.135.163.231"},
+	{"212.146.8.236", "228.19.4.234"},
+	{"212.186.227.154", "228.59.98.98"},
+	{"212.204.172.118", "228.71.195.169"},
+	{"212.206.130.201", "228.69.242.193"},
+	{"216.148.237.145", "235.84.194.111"},
+	{"216.157.30.252", "235.89.31.26"},
+	{"216.184.159.48", "235.96.225.78"},
+	{"216.227.10.221", "235.28.253.36"},
+	{"216.254.18.172", "235.7.16.162"},
+	{"216.32.132.250", "235.192.139.38"},
+	{"216.35.217.178", "235.195.157.81"},
+	{"24.0.250.221", "100.15.198.226"},
+	{"24.13.62.231", "100.2.192.247"},
+	{"24.14.213.138", "100.1.42.141"},
+	{"24.5.0.80", "100.9.15.210"},
+	{"24.7.198.88", "100.10.6.25"},
+	{"24.94.26.44", "100.88.228.35"},
+	{"38.15.67.68", "64.3.66.187"},
+	{"4.3.88.225", "124.60.155.63"},
+	{"63.14.55.111", "95.9.215.7"},
+	{"63.195.241.44", "95.179.238.44"},
+	{"63.97.7.140", "95.97.9.123"},
+	{"64.14.118.196", "0.255.183.58"},
+	{"64.34.154.117", "0.221.154.117"},
+	{"64.39.15.238", "0.219.7.41"},
+}
+
+func TestCryptoPAnTestVectors(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	anon, err := anonymize.NewCryptoPAn(cryptopanTestKey)
+	rtx.Must(err, "Could not create anonymizer")
+	for _, tt := range cryptopanTestVectors {
+		t.Run(tt.ip, func(t *testing.T) {
+			// Check both the 16-byte and the 4-byte representations.
+			ip := net.ParseIP(tt.ip)
+			ip4 := net.ParseIP(tt.ip).To4()
+			anon.IP(ip)
+			if ip.String() != tt.want {
+				t.Errorf("cryptopanAnonymizer.IP() = %q, want %q", ip.String(), tt.want)
+			}
+			anon.IP(ip4)
+			if ip4.String() != tt.want {
+				t.Errorf("cryptopanAnonymizer.IP() = %q, want %q", ip4.String(), tt.want)
+			}
+		})
+	}
+}
+
+// commonPrefixLen returns the number of leading bits that a and b share.
+func commonPrefixLen(a, b net.IP) int {
+	n := 0
+	for i := range a {
+		x := a[i] ^ b[i]
+		for bit := byte(0x80); bit != 0; bit >>= 1 {
+			if x&bit != 0 {
+				return n
+			}
+			n++
+		}
+	}
+	return n
+}
+
+func TestCryptoPAnIPv6(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet(net.ParseIP("1::2"))
+	anon, err := anonymize.NewCryptoPAn(cryptopanTestKey)
+	rtx.Must(err, "Could not create anonymizer")
+
+	anon.IP(nil)                  // No crash = success
+	anon.IP(net.IP([]byte{1, 2})) // No crash = success
+
+	ignored := net.ParseIP("1::2")
+	anon.IP(ignored)
+	if ignored.String() != "1::2" {
+		t.Errorf("IgnoredNets should be ignored, but %q was rewritten", ignored)
+	}
+
+	addrs := []string{
+		"2001:db8::1",
+		"2001:db8::2",
+		"2001:db8:0:1::1",
+		"2001:db8:ffff::1",
+		"2001:db9::1",
+		"fe80::1",
+		"::1",
+	}
+	seen := map[string]string{}
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		anon.IP(ip)
+		if prev, ok := seen[ip.String()]; ok {
+			t.Errorf("%q and %q both anonymize to %q", prev, a, ip)
+		}
+		seen[ip.String()] = a
+		// The mapping must be deterministic.
+		again := net.ParseIP(a)
+		anon.IP(again)
+		if !again.Equal(ip) {
+			t.Errorf("%q anonymized to both %q and %q", a, ip, again)
+		}
+	}
+	// The mapping must preserve prefixes exactly.
+	for i := range addrs {
+		for j := range addrs {
+			a, b := net.ParseIP(addrs[i]), net.ParseIP(addrs[j])
+			want := commonPrefixLen(a, b)
+			anon.IP(a)
+			anon.IP(b)
+			if got := commonPrefixLen(a, b); got != want {
+				t.Errorf("%q and %q share %d bits, but their anonymized forms share %d", addrs[i], addrs[j], want, got)
+			}
+		}
+	}
+}
+
+func TestParseCryptoPAnKey(t *testing.T) {
+	hexKey := hex.EncodeToString(cryptopanTestKey)
+	tests := []struct {
+		name    string
+		in      []byte
+		wantErr bool
+	}{
+		{"raw", cryptopanTestKey, false},
+		{"hex", []byte(hexKey), false},
+		{"hex-with-newline", []byte(hexKey + "\n"), false},
+		{"too-short", []byte("abc"), true},
+		{"bad-hex", []byte(hexKey[:62] + "zz"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := anonymize.ParseCryptoPAnKey(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCryptoPAnKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(key) != string(cryptopanTestKey) {
+				t.Errorf("ParseCryptoPAnKey() = %v, want %v", key, cryptopanTestKey)
+			}
+		})
+	}
+	if _, err := anonymize.NewCryptoPAn([]byte("short")); err == nil {
+		t.Error("NewCryptoPAn should fail with a short key")
+	}
+}
+
+func TestNewCryptoPAnFromFlag(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	dir, err := ioutil.TempDir("", "TestNewCryptoPAnFromFlag")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+	keyfile := dir + "/key"
+	rtx.Must(ioutil.WriteFile(keyfile, []byte(hex.EncodeToString(cryptopanTestKey)), 0600), "Could not write key")
+
+	var m anonymize.Method
+	rtx.Must(m.Set("cryptopan"), "Could not set to cryptopan")
+	rtx.Must(anonymize.CryptoPAnKey.Set(keyfile), "Could not set key")
+	defer func() { anonymize.CryptoPAnKey = nil }()
+
+	anon := anonymize.New(m)
+	ip := net.ParseIP("128.11.68.132")
+	anon.IP(ip)
+	if ip.String() != "135.242.180.132" {
+		t.Errorf("cryptopanAnonymizer.IP() = %q, want %q", ip, "135.242.180.132")
+	}
+}
+
+func TestNewCryptoPAnBadKey(t *testing.T) {
+	calls := 0
+	revert := anonymize.SetLogFatalf(func(string, ...interface{}) {
+		calls++
+	})
+	defer revert()
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Error("A bad key should cause a panic, but it did not.")
+		}
+		if calls == 0 {
+			t.Error("calls should not be zero")
+		}
+	}()
+	anonymize.CryptoPAnKey = []byte("bad key")
+	defer func() { anonymize.CryptoPAnKey = nil }()
+	anonymize.New(anonymize.CryptoPAn)
+}
+
+func BenchmarkCryptoPAnIPv4(b *testing.B) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	anon, err := anonymize.NewCryptoPAn(cryptopanTestKey)
+	rtx.Must(err, "Could not create anonymizer")
+	ip := net.ParseIP("128.11.68.132").To4()
+	for i := 0; i < b.N; i++ {
+		anon.IP(ip)
+	}
+}
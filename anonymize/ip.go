@@ -20,6 +20,20 @@ var (
 	// blocks.
 	None = Method("none")
 
+	// CryptoPAn causes addresses to be anonymized with the keyed,
+	// prefix-preserving Crypto-PAn scheme. The mapping is one-to-one, so
+	// anonymized addresses may still be grouped by subnet or joined across
+	// datasets that were anonymized with the same key. The key is read from
+	// the file named by the `--anonymize.cryptopan-key` flag.
+	CryptoPAn = Method("cryptopan")
+
+	// KAnonymity causes addresses to be anonymized to the most specific prefix
+	// that was shared by at least `--anonymize.k` distinct addresses within the
+	// last `--anonymize.k-window`. IPv4 addresses are anonymized to between
+	// the /24 and /16 level, and IPv6 addresses to between the /64 and /48
+	// level.
+	KAnonymity = Method("kanonymity")
+
 	// IPAnonymizationFlag is a flag that determines whether IP anonymization is
 	// on or off. Its value should be fixed for the duration of a program. This
 	// library is not guaranteeed to work properly if you keep switching back
@@ -27,32 +41,21 @@ var (
 	// anonymization.
 	IPAnonymizationFlag = None
 
-	// IgnoredIPs is a set of IPs that should be ignored and not anonymized. By
-	// default it is the set of local IP addresses. This set should be small, so
-	// it is represented as an array because net.IP objects can't be used as map
-	// keys.
-	//
-	// If runtime profiling indicates this causes too much of a slowdown in
-	// practice, then the other design that is "near at hand" with Go primitives
-	// is to use map[string]struct{} where the string is the IP converted to a
-	// string. If that design also causes too much of a slowdown in practice,
-	// then we will have to fall back on our algorithms knowledge and build a
-	// clever data structure. The 3 main options are:
-	//  1. a bloom filter in front of a set object,
-	//  2. a 256-ary tree using each successive byte of the IP for each
-	//     successive level, or
-	//  3. a map[int32]struct{} for v4 addresses and a
-	//     map[int64](map[int64]struct{}) for v6 addresses.
-	// Likely the last option will be the fastest; ceteris paribus, native ints
-	// and language builtins tend to have the best performance.
+	// IgnoredNets is a set of IPs and CIDR ranges that should be ignored and
+	// not anonymized. By default it is the set of local IP addresses, and more
+	// addresses or ranges (e.g. monitoring hosts or peer sites) may be added
+	// with the repeatable `--anonymize.ignored-ips` flag. It is stored in a
+	// radix tree so that lookups take time proportional to the length of the
+	// address rather than the number of entries. The benchmarks in
+	// ipset_test.go compare it to the linear scan of a []net.IP that preceded
+	// it.
+	IgnoredNets = &IPSet{}
+
+	// IgnoredIPs is a list of additional IPs that should be ignored and not
+	// anonymized. It is empty by default.
 	//
-	// Big-O analysis doesn't buy us much here. There are a small number of
-	// local IPs and each one is of length 4 or 16. This means that taking the
-	// limit as N goes to infinity doesn't tell us much, because all of the
-	// quantities we might call "N" are 16 or less in all realistic scenarios,
-	// and 16 is a poor approximation of infinity. Therefore, any claims about
-	// relative speed of implementation need to be backed up by experimental
-	// evidence.
+	// Deprecated: Add addresses to IgnoredNets instead. Addresses in
+	// IgnoredIPs are still ignored, but are found with a linear scan.
 	IgnoredIPs = []net.IP{}
 
 	// An injected log.Fatal to aid in testing.
@@ -60,9 +63,7 @@ var (
 )
 
 // Method is an enum suitable for using as a command-line flag. It
-// allows only a finite set of values. We can imagine future anonymization
-// techniques based on k-anonymity or that completely blot out the IP. We leave
-// room for those implementations here, but do not (yet) implement them.
+// allows only a finite set of values.
 type Method string
 
 // Get is required for all flag.Flag values.
@@ -77,6 +78,10 @@ func (m *Method) Set(s string) error {
 		*m = Netblock
 	case None:
 		*m = None
+	case CryptoPAn:
+		*m = CryptoPAn
+	case KAnonymity:
+		*m = KAnonymity
 	default:
 		return fmt.Errorf("Uknown anonymization method: %q", s)
 	}
@@ -89,7 +94,11 @@ func (m Method) String() string {
 }
 
 func init() {
-	flag.Var(&IPAnonymizationFlag, "anonymize.ip", "Valid values are \"none\" and \"netblock\".")
+	flag.Var(&IPAnonymizationFlag, "anonymize.ip", "Valid values are \"none\", \"netblock\", \"cryptopan\", and \"kanonymity\".")
+	flag.Var(&CryptoPAnKey, "anonymize.cryptopan-key", "File containing the 32-byte secret key (raw or hex-encoded) used by --anonymize.ip=cryptopan.")
+	flag.Var(IgnoredNets, "anonymize.ignored-ips", "An IP address or CIDR range that should never be anonymized. May be repeated or \",\" separated. Local addresses are always ignored.")
+	flag.IntVar(&KAnonymityK, "anonymize.k", KAnonymityK, "The minimum number of distinct addresses that must share a prefix when using --anonymize.ip=kanonymity.")
+	flag.DurationVar(&KAnonymityWindow, "anonymize.k-window", KAnonymityWindow, "How long an address counts towards its prefixes after it was last seen when using --anonymize.ip=kanonymity.")
 
 	// Set up the local IP addresses to be ignored by the anonymization system.
 	// We want to anonymize our users but not ourselves.
@@ -97,7 +106,7 @@ func init() {
 	if err == nil {
 		for _, addr := range localAddrs {
 			if ipnet, ok := addr.(*net.IPNet); ok {
-				IgnoredIPs = append(IgnoredIPs, ipnet.IP)
+				IgnoredNets.Add(ipnet.IP)
 			}
 		}
 	}
@@ -118,10 +127,13 @@ type IPAnonymizer interface {
 //
 // If the anonymization method is set to "netblock", then IPv4 addresses will be
 // anonymized up to the /24 level and IPv6 addresses to the /64 level. If it is
-// set to "none" then no anonymization will be performed. We can imagine future
-// anonymization techniques based on k-anonymity or that completely blot out the
-// IP. We leave room for those implementations here, but do not (yet) implement
-// them.
+// set to "cryptopan" then addresses will be anonymized with the prefix-preserving
+// Crypto-PAn scheme, keyed by the contents of CryptoPAnKey. If it is set to
+// "kanonymity" then addresses will be anonymized to the most specific prefix
+// (between /24 and /16 for IPv4, and /64 and /48 for IPv6) that was recently
+// shared by at least KAnonymityK addresses, or blotted out completely if there
+// is no such prefix. If it is set to "none" then no anonymization will be
+// performed.
 //
 // A program attempting to perform IP anonymization should only ever create one
 // IPAnonymizer and use that one anonymizer for all connections. Otherwise, the
@@ -133,6 +145,24 @@ func New(method Method) IPAnonymizer {
 		return nullIPAnonymizer{}
 	case Netblock:
 		return netblockAnonymizer{}
+	case CryptoPAn:
+		key, err := ParseCryptoPAnKey(CryptoPAnKey)
+		if err != nil {
+			logFatalf("Bad --anonymize.cryptopan-key: %v, exiting to avoid accidentally leaking private data", err)
+			panic("This line should only be reached during testing.")
+		}
+		anon, err := NewCryptoPAn(key)
+		if err != nil {
+			logFatalf("Could not create Crypto-PAn anonymizer: %v, exiting to avoid accidentally leaking private data", err)
+			panic("This line should only be reached during testing.")
+		}
+		return anon
+	case KAnonymity:
+		if KAnonymityK < 1 || KAnonymityWindow <= 0 {
+			logFatalf("Bad k-anonymity parameters k=%d window=%v, exiting to avoid accidentally leaking private data", KAnonymityK, KAnonymityWindow)
+			panic("This line should only be reached during testing.")
+		}
+		return NewKAnonymizer(KAnonymityK, KAnonymityWindow)
 	default:
 		logFatalf("Unknown anonymization method: %q, exiting to avoid accidentally leaking private data", method)
 		panic("This line should only be reached during testing.")
@@ -151,10 +181,8 @@ func (netblockAnonymizer) IP(ip net.IP) {
 	if ip == nil {
 		return
 	}
-	for i := range IgnoredIPs {
-		if IgnoredIPs[i].Equal(ip) {
-			return
-		}
+	if isIgnored(ip) {
+		return
 	}
 	if ip.To4() != nil {
 		// Zero out the last byte.  That's ip[3] in the 4-byte v4 representation and ip[15] in the v4-in-v6 representation.
@@ -171,3 +199,16 @@ func (netblockAnonymizer) IP(ip net.IP) {
 	log.Println("The passed in IP address was neither a v4 nor a v6 address:", ip)
 	return
 }
+
+// isIgnored returns true if the ip is in IgnoredNets or IgnoredIPs.
+func isIgnored(ip net.IP) bool {
+	if IgnoredNets.Contains(ip) {
+		return true
+	}
+	for i := range IgnoredIPs {
+		if IgnoredIPs[i].Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
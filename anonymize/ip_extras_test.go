@@ -1,5 +1,7 @@
 package anonymize
 
+import "time"
+
 // SetLogFatalf allows us to inject a new log.Fatal to test error cases. It
 // returns a function which, when executed, reverts the injection process.
 func SetLogFatalf(f func(string, ...interface{})) func() {
@@ -9,3 +11,8 @@ func SetLogFatalf(f func(string, ...interface{})) func() {
 		logFatalf = old
 	}
 }
+
+// SetKAnonymizerClock allows us to inject a fake clock into a KAnonymizer.
+func SetKAnonymizerClock(k *KAnonymizer, now func() time.Time) {
+	k.now = now
+}
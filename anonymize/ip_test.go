@@ -49,13 +49,18 @@ func TestNetblockAnon(t *testing.T) {
 	anon.IP(nil)                  // No crash = success
 	anon.IP(net.IP([]byte{1, 2})) // No crash = success
 
-	anonymize.IgnoredIPs = []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("1::2")}
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet(net.ParseIP("127.0.0.1"))
+	// The deprecated IgnoredIPs are still honored.
+	anonymize.IgnoredIPs = []net.IP{net.ParseIP("1::2")}
+	defer func() { anonymize.IgnoredIPs = []net.IP{} }()
 
 	tests := []struct {
 		ip   string
 		want string
 	}{
-		{"127.0.0.1", "127.0.0.1"}, // IgnoredIPs should be ignored.
+		{"127.0.0.1", "127.0.0.1"}, // IgnoredNets should be ignored.
 		{"1:0::2", "1::2"},         // IgnoredIPs should be ignored.
 		{"10.1.2.3", "10.1.2.0"},
 		{"255.255.255.255", "255.255.255.0"},
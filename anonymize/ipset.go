@@ -0,0 +1,209 @@
+package anonymize
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// trieNode is a node in a path-compressed binary radix tree of address
+// prefixes. A node stands for the first `bits` bits of prefix, and its
+// children are chosen by the bit that follows them.
+type trieNode struct {
+	prefix [net.IPv6len]byte
+	bits   int
+	child  [2]*trieNode
+	// terminal is true if the node's prefix is in the set. Every address
+	// beginning with that prefix is in the set, so terminal nodes never have
+	// children.
+	terminal bool
+}
+
+func newLeaf(addr []byte, bits int) *trieNode {
+	n := &trieNode{bits: bits, terminal: true}
+	copy(n.prefix[:], addr)
+	maskBits(n.prefix[:], bits)
+	return n
+}
+
+// IPSet is a set of IP addresses and CIDR ranges. Lookups take time
+// proportional to the length of the address (32 bits for v4, 128 bits for
+// v6), no matter how many entries are in the set. The zero value is an empty
+// set, ready to use.
+//
+// IPSet is also a flag.Value, so that it can be filled from a command-line
+// flag that may be specified multiple times or with "," separated items.
+//
+// An IPSet is safe for concurrent lookups, but not for lookups that are
+// concurrent with modifications.
+type IPSet struct {
+	v4, v6  *trieNode
+	entries []string
+}
+
+// NewIPSet creates an IPSet containing all of the passed-in addresses.
+func NewIPSet(ips ...net.IP) *IPSet {
+	s := &IPSet{}
+	for _, ip := range ips {
+		s.Add(ip)
+	}
+	return s
+}
+
+// Add adds a single IP address to the set. Invalid addresses are ignored.
+func (s *IPSet) Add(ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		s.insert(&s.v4, ip4, 8*net.IPv4len)
+	} else if ip16 := ip.To16(); ip16 != nil {
+		s.insert(&s.v6, ip16, 8*net.IPv6len)
+	} else {
+		return
+	}
+	s.entries = append(s.entries, ip.String())
+}
+
+// AddNet adds every address in the passed-in network to the set.
+func (s *IPSet) AddNet(n *net.IPNet) {
+	ones, bits := n.Mask.Size()
+	if ip4 := n.IP.To4(); ip4 != nil && bits == 8*net.IPv4len {
+		s.insert(&s.v4, ip4, ones)
+	} else if ip16 := n.IP.To16(); ip16 != nil && bits == 8*net.IPv6len {
+		if ip4 := n.IP.To4(); ip4 != nil && ones >= 8*(net.IPv6len-net.IPv4len) {
+			// A v4 network written in its 16 byte form.
+			s.insert(&s.v4, ip4, ones-8*(net.IPv6len-net.IPv4len))
+		} else {
+			s.insert(&s.v6, ip16, ones)
+		}
+	} else {
+		return
+	}
+	s.entries = append(s.entries, n.String())
+}
+
+// insert adds the first `ones` bits of addr to the tree rooted at *root.
+func (s *IPSet) insert(root **trieNode, addr []byte, ones int) {
+	p := root
+	for {
+		n := *p
+		if n == nil {
+			*p = newLeaf(addr, ones)
+			return
+		}
+		c := commonBits(addr, n.prefix[:], min(ones, n.bits))
+		if c == n.bits {
+			if n.terminal {
+				// The node already covers the new prefix.
+				return
+			}
+			if ones == n.bits {
+				n.terminal = true
+				n.child[0], n.child[1] = nil, nil
+				return
+			}
+			p = &n.child[bit(addr, n.bits)]
+			continue
+		}
+		if c == ones {
+			// The new prefix covers the node and everything beneath it.
+			*p = newLeaf(addr, ones)
+			return
+		}
+		// The new prefix and the node diverge after c bits, so they become
+		// the two children of a new interior node.
+		split := &trieNode{bits: c}
+		copy(split.prefix[:], addr)
+		maskBits(split.prefix[:], c)
+		split.child[bit(n.prefix[:], c)] = n
+		split.child[bit(addr, c)] = newLeaf(addr, ones)
+		*p = split
+		return
+	}
+}
+
+// Contains returns true if the ip is in the set.
+func (s *IPSet) Contains(ip net.IP) bool {
+	if s == nil {
+		return false
+	}
+	var n *trieNode
+	if ip4 := ip.To4(); ip4 != nil {
+		n, ip = s.v4, ip4
+	} else if ip16 := ip.To16(); ip16 != nil {
+		n, ip = s.v6, ip16
+	}
+	for n != nil {
+		if commonBits(ip, n.prefix[:], n.bits) != n.bits {
+			return false
+		}
+		if n.terminal {
+			return true
+		}
+		n = n.child[bit(ip, n.bits)]
+	}
+	return false
+}
+
+// commonBits returns how many of the first `max` bits a and b share.
+func commonBits(a, b []byte, max int) int {
+	i := 0
+	for ; i+8 <= max && a[i/8] == b[i/8]; i += 8 {
+	}
+	for ; i < max && bit(a, i) == bit(b, i); i++ {
+	}
+	return i
+}
+
+// maskBits zeroes every bit of addr after the first `bits`.
+func maskBits(addr []byte, bits int) {
+	mask := net.CIDRMask(bits, 8*len(addr))
+	for i := range addr {
+		addr[i] &= mask[i]
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// bit returns the i'th most significant bit of addr.
+func bit(addr []byte, i int) int {
+	return int(addr[i/8]>>(7-uint(i%8))) & 1
+}
+
+// Get is required for all flag.Getter values.
+func (s *IPSet) Get() interface{} {
+	return s
+}
+
+// Set accepts a single address, a CIDR range, or a "," separated list of them,
+// and adds them all to the set.
+func (s *IPSet) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if strings.Contains(v, "/") {
+			_, n, err := net.ParseCIDR(v)
+			if err != nil {
+				return err
+			}
+			s.AddNet(n)
+			continue
+		}
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return fmt.Errorf("invalid IP address: %q", v)
+		}
+		s.Add(ip)
+	}
+	return nil
+}
+
+// String reports the entries that were added to the set.
+func (s *IPSet) String() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(s.entries, ",")
+}
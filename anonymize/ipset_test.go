@@ -0,0 +1,183 @@
+package anonymize_test
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/m-lab/go/anonymize"
+	"github.com/m-lab/go/rtx"
+)
+
+func TestIPSet(t *testing.T) {
+	s := &anonymize.IPSet{}
+	if s.Contains(net.ParseIP("1.2.3.4")) {
+		t.Error("The empty set should contain nothing")
+	}
+	rtx.Must(s.Set("10.0.0.0/8,192.168.1.1"), "Could not set")
+	rtx.Must(s.Set("2001:db8::/32"), "Could not set")
+	rtx.Must(s.Set("fe80::1"), "Could not set")
+	rtx.Must(s.Set("::ffff:172.16.0.0/108"), "Could not set")
+	// A range that is covered by an existing one, and one that covers an
+	// existing one.
+	rtx.Must(s.Set("10.1.0.0/16"), "Could not set")
+	rtx.Must(s.Set("192.168.0.0/16"), "Could not set")
+	s.Add(net.IP([]byte{1, 2})) // No crash = success
+	s.AddNet(&net.IPNet{IP: net.IP([]byte{1, 2}), Mask: net.CIDRMask(8, 32)})
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.0.0.0", true},
+		{"10.255.255.255", true},
+		{"10.1.2.3", true},
+		{"11.0.0.0", false},
+		{"9.255.255.255", false},
+		{"192.168.1.1", true},
+		{"192.168.200.3", true},
+		{"192.169.0.1", false},
+		{"172.16.5.4", true},
+		{"172.32.0.1", false},
+		{"2001:db8::1", true},
+		{"2001:db8:ffff:ffff::1", true},
+		{"2001:db9::1", false},
+		{"fe80::1", true},
+		{"fe80::2", false},
+		{"::1", false},
+		// v4-mapped v6 addresses are v4 addresses.
+		{"::ffff:10.1.2.3", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := s.Contains(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("IPSet.Contains(%q) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+	if s.Contains(net.IP([]byte{1, 2})) {
+		t.Error("An invalid IP should not be in the set")
+	}
+	if s.Contains(nil) {
+		t.Error("A nil IP should not be in the set")
+	}
+	want := "10.0.0.0/8,192.168.1.1,2001:db8::/32,fe80::1,172.16.0.0/12,10.1.0.0/16,192.168.0.0/16"
+	if s.String() != want {
+		t.Errorf("IPSet.String() = %q, want %q", s.String(), want)
+	}
+	if s.Get() != s {
+		t.Error("IPSet.Get() should return the set")
+	}
+}
+
+func TestIPSetBadValues(t *testing.T) {
+	s := &anonymize.IPSet{}
+	for _, v := range []string{"10.0.0.0/33", "not an ip", "1.2.3.4,bad"} {
+		if err := s.Set(v); err == nil {
+			t.Errorf("IPSet.Set(%q) should have failed", v)
+		}
+	}
+	var nilSet *anonymize.IPSet
+	if nilSet.Contains(net.ParseIP("1.2.3.4")) || nilSet.String() != "" {
+		t.Error("A nil set should be empty")
+	}
+}
+
+func TestNetblockAnonIgnoresRanges(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	rtx.Must(anonymize.IgnoredNets.Set("10.0.0.0/8,2001:db8::/32"), "Could not set")
+	anon := anonymize.New(anonymize.Netblock)
+	for ip, want := range map[string]string{
+		"10.1.2.3":        "10.1.2.3",
+		"11.1.2.3":        "11.1.2.0",
+		"2001:db8::1:2:3": "2001:db8::1:2:3",
+		"2001:db9::1:2:3": "2001:db9::",
+	} {
+		if got := anonymizeString(anon, ip); got != want {
+			t.Errorf("netblockAnonymizer.IP(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+// benchmarkAddrs returns n distinct local-looking addresses, half v4 and half
+// v6, which is the shape of the default IgnoredNets.
+func benchmarkAddrs(n int) []net.IP {
+	ips := []net.IP{}
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			ips = append(ips, net.ParseIP(fmt.Sprintf("10.%d.%d.1", i/256, i%256)))
+		} else {
+			ips = append(ips, net.ParseIP(fmt.Sprintf("2001:db8:%x::1", i)))
+		}
+	}
+	return ips
+}
+
+// sliceContains is the linear scan that is still used for the deprecated
+// IgnoredIPs.
+func sliceContains(ips []net.IP, ip net.IP) bool {
+	for i := range ips {
+		if ips[i].Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var benchmarkSizes = []int{4, 16, 256}
+
+// The miss case is the common one: almost every anonymized address belongs to
+// somebody else.
+var benchmarkMiss = net.ParseIP("2001:db8:ffff::2")
+
+func BenchmarkIgnoredSlice(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		ips := benchmarkAddrs(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				sliceContains(ips, benchmarkMiss)
+			}
+		})
+	}
+}
+
+func BenchmarkIgnoredIPSet(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		s := anonymize.NewIPSet(benchmarkAddrs(n)...)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s.Contains(benchmarkMiss)
+			}
+		})
+	}
+}
+
+func TestIPSetMatchesLinearScan(t *testing.T) {
+	// Build sets from many overlapping ranges in a small part of the address
+	// space, and check every address in that space against a linear scan.
+	r := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 20; trial++ {
+		s := &anonymize.IPSet{}
+		nets := []*net.IPNet{}
+		for i := 0; i < 30; i++ {
+			mask := net.CIDRMask(22+r.Intn(11), 32)
+			ip := net.IPv4(10, 0, byte(r.Intn(4)), byte(r.Intn(256))).To4()
+			n := &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+			nets = append(nets, n)
+			s.AddNet(n)
+		}
+		for i := 0; i < 1024; i++ {
+			ip := net.IPv4(10, 0, byte(i/256), byte(i%256))
+			want := false
+			for _, n := range nets {
+				want = want || n.Contains(ip)
+			}
+			if got := s.Contains(ip); got != want {
+				t.Fatalf("trial %d: IPSet.Contains(%s) = %v, want %v (set is %s)", trial, ip, got, want, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package anonymize
+
+import (
+	"container/list"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// KAnonymityK is the minimum number of distinct addresses that must share
+	// a bucket before the "kanonymity" method will use that bucket. It is set
+	// by the `--anonymize.k` command-line flag.
+	KAnonymityK = 10
+
+	// KAnonymityWindow is the length of time that an address counts towards
+	// the size of its buckets after it was last seen. It is set by the
+	// `--anonymize.k-window` command-line flag.
+	KAnonymityWindow = time.Hour
+
+	// The prefix lengths, from most to least specific, that the k-anonymizer
+	// may choose between for each address family.
+	kAnonV4Prefixes = prefixRange(24, 16)
+	kAnonV6Prefixes = prefixRange(64, 48)
+)
+
+// prefixRange returns every prefix length from most down to least, inclusive.
+func prefixRange(most, least int) []int {
+	p := []int{}
+	for i := most; i >= least; i-- {
+		p = append(p, i)
+	}
+	return p
+}
+
+// bucket identifies the set of addresses that share a prefix. The prefix is
+// always stored in its 16 byte form and bits counts from the start of those 16
+// bytes, so v4 and v6 buckets can never collide.
+type bucket struct {
+	prefix [net.IPv6len]byte
+	bits   int
+}
+
+// sighting records the last time a single address was seen.
+type sighting struct {
+	addr [net.IPv6len]byte
+	v4   bool
+	last time.Time
+}
+
+// KAnonymizer is a stateful IPAnonymizer that watches the addresses it is
+// asked to anonymize and zeroes the host part of each one, choosing the most
+// specific prefix whose bucket contains at least K distinct addresses seen
+// within the window. Prefixes from /24 to /16 are considered for IPv4 and from
+// /64 to /48 for IPv6. If no candidate bucket is big enough, the address
+// cannot be made k-anonymous and is blotted out completely, becoming 0.0.0.0
+// or ::.
+//
+// Because the set of buckets grows as more addresses are seen, the same
+// address may be anonymized to a more specific prefix later in the life of a
+// program than it was at the beginning.
+//
+// KAnonymizer is safe for concurrent use.
+type KAnonymizer struct {
+	k      int
+	window time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	counts map[bucket]int
+	seen   map[[net.IPv6len]byte]*list.Element
+	// lru holds *sighting values ordered from least to most recently seen.
+	lru *list.List
+}
+
+// NewKAnonymizer creates a KAnonymizer that requires k distinct addresses in
+// a bucket within the given window. Most programs should call New(KAnonymity)
+// and configure it with the `--anonymize.k` and `--anonymize.k-window` flags
+// instead.
+func NewKAnonymizer(k int, window time.Duration) *KAnonymizer {
+	return &KAnonymizer{
+		k:      k,
+		window: window,
+		now:    time.Now,
+		counts: map[bucket]int{},
+		seen:   map[[net.IPv6len]byte]*list.Element{},
+		lru:    list.New(),
+	}
+}
+
+// IP anonymizes the passed-in IP in place.
+func (k *KAnonymizer) IP(ip net.IP) {
+	if ip == nil {
+		return
+	}
+	if isIgnored(ip) {
+		return
+	}
+	var addr [net.IPv6len]byte
+	var prefixes []int
+	offset := 0
+	if ip4 := ip.To4(); ip4 != nil {
+		copy(addr[:], ip4.To16())
+		prefixes = kAnonV4Prefixes
+		offset = 8 * (net.IPv6len - net.IPv4len)
+	} else if ip16 := ip.To16(); ip16 != nil {
+		copy(addr[:], ip16)
+		prefixes = kAnonV6Prefixes
+	} else {
+		log.Println("The passed in IP address was neither a v4 nor a v6 address:", ip)
+		return
+	}
+
+	bits := k.observe(addr, prefixes, offset)
+	// Zero out everything after the chosen prefix. The host part of the
+	// address is always at the end, so the tail of the 16 byte mask works for
+	// v4 addresses in both their 4 byte and 16 byte forms.
+	mask := net.CIDRMask(bits, 8*net.IPv6len)
+	mask = mask[len(mask)-len(ip):]
+	for i := range ip {
+		ip[i] &= mask[i]
+	}
+}
+
+// observe records that addr was seen, and returns the most specific prefix
+// length (in bits of the 16 byte form) whose bucket holds at least k
+// addresses. If there is no such bucket, it returns a prefix that keeps none
+// of the address.
+func (k *KAnonymizer) observe(addr [net.IPv6len]byte, prefixes []int, offset int) int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := k.now()
+	k.expire(now)
+	if e, ok := k.seen[addr]; ok {
+		e.Value.(*sighting).last = now
+		k.lru.MoveToBack(e)
+	} else {
+		s := &sighting{addr: addr, v4: offset != 0, last: now}
+		k.seen[addr] = k.lru.PushBack(s)
+		k.update(s, 1)
+	}
+
+	for _, p := range prefixes {
+		if k.counts[makeBucket(addr, offset+p)] >= k.k {
+			return offset + p
+		}
+	}
+	return offset
+}
+
+// expire forgets every address that has not been seen within the window.
+func (k *KAnonymizer) expire(now time.Time) {
+	for e := k.lru.Front(); e != nil; e = k.lru.Front() {
+		s := e.Value.(*sighting)
+		if now.Sub(s.last) < k.window {
+			return
+		}
+		k.lru.Remove(e)
+		delete(k.seen, s.addr)
+		k.update(s, -1)
+	}
+}
+
+// update adds delta to the size of every candidate bucket containing s.
+func (k *KAnonymizer) update(s *sighting, delta int) {
+	prefixes, offset := kAnonV6Prefixes, 0
+	if s.v4 {
+		prefixes, offset = kAnonV4Prefixes, 8*(net.IPv6len-net.IPv4len)
+	}
+	for _, p := range prefixes {
+		b := makeBucket(s.addr, offset+p)
+		k.counts[b] += delta
+		if k.counts[b] <= 0 {
+			delete(k.counts, b)
+		}
+	}
+}
+
+// Buckets returns the current size of every non-empty candidate bucket, keyed
+// by the bucket's CIDR notation (e.g. "10.1.2.0/24"). It is intended for
+// debugging.
+func (k *KAnonymizer) Buckets() map[string]int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.expire(k.now())
+
+	sizes := make(map[string]int, len(k.counts))
+	for b, n := range k.counts {
+		ipnet := net.IPNet{
+			IP:   net.IP(append([]byte(nil), b.prefix[:]...)),
+			Mask: net.CIDRMask(b.bits, 8*net.IPv6len),
+		}
+		if ip4 := ipnet.IP.To4(); ip4 != nil {
+			ipnet.IP = ip4
+			ipnet.Mask = ipnet.Mask[net.IPv6len-net.IPv4len:]
+		}
+		sizes[ipnet.String()] = n
+	}
+	return sizes
+}
+
+func makeBucket(addr [net.IPv6len]byte, bits int) bucket {
+	b := bucket{bits: bits}
+	mask := net.CIDRMask(bits, 8*net.IPv6len)
+	for i := range addr {
+		b.prefix[i] = addr[i] & mask[i]
+	}
+	return b
+}
@@ -0,0 +1,220 @@
+package anonymize_test
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/go/anonymize"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (f *fakeClock) now() time.Time {
+	return f.t
+}
+
+func anonymizeString(anon anonymize.IPAnonymizer, s string) string {
+	ip := net.ParseIP(s)
+	anon.IP(ip)
+	return ip.String()
+}
+
+func TestKAnonymizerWidensUntilK(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet(net.ParseIP("127.0.0.1"))
+	k := anonymize.NewKAnonymizer(3, time.Hour)
+	clock := &fakeClock{t: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	anonymize.SetKAnonymizerClock(k, clock.now)
+
+	k.IP(nil)                  // No crash = success
+	k.IP(net.IP([]byte{1, 2})) // No crash = success
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"127.0.0.1", "127.0.0.1"}, // IgnoredNets should be ignored.
+		// Alone in every bucket, so the address is blotted out.
+		{"10.1.2.3", "0.0.0.0"},
+		// Two in the /16, still not enough.
+		{"10.1.2.4", "0.0.0.0"},
+		// The same address again does not increase the count.
+		{"10.1.2.4", "0.0.0.0"},
+		// Three in the /24.
+		{"10.1.2.5", "10.1.2.0"},
+		{"10.1.2.3", "10.1.2.0"},
+		// Alone in its /24, but shares a /23 with the other three.
+		{"10.1.3.1", "10.1.2.0"},
+		// Alone in its /23, but shares a /22 with the other four.
+		{"10.1.1.1", "10.1.0.0"},
+		{"10.1.1.2", "10.1.0.0"},
+		// 10.1.0.0/23 now holds 10.1.1.1, 10.1.1.2, and 10.1.0.1.
+		{"10.1.0.1", "10.1.0.0"},
+		{"10.1.1.3", "10.1.1.0"},
+		{"10.1.3.2", "10.1.2.0"},
+		// IPv6 works the same way, from /64 to /48.
+		{"2001:db8:1:2::1", "::"},
+		{"2001:db8:1:2::2", "::"},
+		{"2001:db8:1:2::3", "2001:db8:1:2::"},
+		{"2001:db8:1:3::1", "2001:db8:1:2::"},
+	}
+	for _, tt := range tests {
+		if got := anonymizeString(k, tt.ip); got != tt.want {
+			t.Errorf("KAnonymizer.IP(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+
+	// Four byte v4 addresses should work too.
+	ip := net.ParseIP("10.1.2.3").To4()
+	k.IP(ip)
+	if ip.String() != "10.1.2.0" {
+		t.Errorf("KAnonymizer.IP(4 byte 10.1.2.3) = %q, want 10.1.2.0", ip)
+	}
+}
+
+func TestKAnonymizerSingleObservation(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	tests := []struct {
+		ip   net.IP
+		want string
+	}{
+		{net.ParseIP("10.1.2.3"), "0.0.0.0"},
+		{net.ParseIP("10.1.2.3").To4(), "0.0.0.0"},
+		{net.ParseIP("2001:db8:1:2::1"), "::"},
+	}
+	for _, tt := range tests {
+		// A fresh anonymizer has only ever seen this one address, so no
+		// bucket can hold k of them.
+		k := anonymize.NewKAnonymizer(2, time.Hour)
+		k.IP(tt.ip)
+		if tt.ip.String() != tt.want {
+			t.Errorf("KAnonymizer.IP() = %q, want %q", tt.ip, tt.want)
+		}
+	}
+}
+
+func TestKAnonymizerWindow(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	k := anonymize.NewKAnonymizer(2, time.Minute)
+	clock := &fakeClock{t: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	anonymize.SetKAnonymizerClock(k, clock.now)
+
+	if got := anonymizeString(k, "192.168.0.1"); got != "0.0.0.0" {
+		t.Errorf("A single observation should be blotted out, got %q", got)
+	}
+	clock.t = clock.t.Add(30 * time.Second)
+	if got := anonymizeString(k, "192.168.0.2"); got != "192.168.0.0" {
+		t.Errorf("Two addresses within the window should share a /24, got %q", got)
+	}
+	// Seeing .2 again refreshes it, but .1 will expire.
+	clock.t = clock.t.Add(45 * time.Second)
+	if got := anonymizeString(k, "192.168.0.2"); got != "0.0.0.0" {
+		t.Errorf("Expired addresses should not count, got %q", got)
+	}
+	if diff := deep.Equal(k.Buckets()["192.168.0.0/24"], 1); diff != nil {
+		t.Error(diff)
+	}
+	// Once everything has expired, there are no buckets.
+	clock.t = clock.t.Add(time.Hour)
+	if diff := deep.Equal(k.Buckets(), map[string]int{}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestKAnonymizerBuckets(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	k := anonymize.NewKAnonymizer(2, time.Hour)
+	anonymizeString(k, "10.0.0.1")
+	anonymizeString(k, "10.0.1.1")
+	anonymizeString(k, "2001:db8::1")
+
+	b := k.Buckets()
+	want := map[string]int{
+		"10.0.0.0/24":   1,
+		"10.0.1.0/24":   1,
+		"10.0.0.0/23":   2,
+		"10.0.0.0/16":   2,
+		"2001:db8::/64": 1,
+		"2001:db8::/48": 1,
+	}
+	for cidr, n := range want {
+		if b[cidr] != n {
+			t.Errorf("Buckets()[%q] = %d, want %d", cidr, b[cidr], n)
+		}
+	}
+	// 9 v4 prefixes for each of the two /24s (sharing 8 of them) plus 17 v6.
+	if len(b) != 10+17 {
+		t.Errorf("len(Buckets()) = %d, want %d", len(b), 10+17)
+	}
+}
+
+func TestKAnonymizerConcurrent(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	k := anonymize.NewKAnonymizer(10, time.Hour)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				anonymizeString(k, fmt.Sprintf("10.0.%d.%d", i, j))
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := k.Buckets()["10.0.0.0/16"]; got != 2000 {
+		t.Errorf("Buckets()[10.0.0.0/16] = %d, want 2000", got)
+	}
+}
+
+func TestNewKAnonymity(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	var m anonymize.Method
+	if err := m.Set("kanonymity"); err != nil {
+		t.Fatal(err)
+	}
+	anon := anonymize.New(m)
+	if _, ok := anon.(*anonymize.KAnonymizer); !ok {
+		t.Errorf("New(%q) returned %T, want *KAnonymizer", m, anon)
+	}
+	if got := anonymizeString(anon, "10.1.2.3"); got != "0.0.0.0" {
+		t.Errorf("A lone address should be blotted out, got %q", got)
+	}
+
+	calls := 0
+	revert := anonymize.SetLogFatalf(func(string, ...interface{}) {
+		calls++
+	})
+	defer revert()
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Error("A bad k should cause a panic, but it did not.")
+		}
+		if calls == 0 {
+			t.Error("calls should not be zero")
+		}
+	}()
+	old := anonymize.KAnonymityK
+	defer func() { anonymize.KAnonymityK = old }()
+	anonymize.KAnonymityK = 0
+	anonymize.New(anonymize.KAnonymity)
+}
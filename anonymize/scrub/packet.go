@@ -0,0 +1,335 @@
+// Package scrub anonymizes the IP addresses in existing artifacts, like packet
+// captures and text logs, so that they may be shared. It uses an
+// anonymize.IPAnonymizer to rewrite each address, so the results match what
+// live services using the same anonymize.Method and anonymize.IgnoredNets would
+// produce.
+package scrub
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/m-lab/go/anonymize"
+)
+
+// Link types, as listed at https://www.tcpdump.org/linktypes.html, that are
+// understood by Packet.
+const (
+	LinkTypeNull     = 0
+	LinkTypeEthernet = 1
+	LinkTypeRaw      = 101
+	LinkTypeLinuxSLL = 113
+	LinkTypeIPv4     = 228
+	LinkTypeIPv6     = 229
+)
+
+// ErrUnsupportedLinkType is returned for packets whose link layer can not be
+// parsed. Rather than pass through packets that may contain addresses, the
+// whole capture is rejected.
+var ErrUnsupportedLinkType = errors.New("unsupported link type")
+
+// EtherTypes and IP protocol numbers used while parsing packets.
+const (
+	etherTypeIPv4  = 0x0800
+	etherTypeARP   = 0x0806
+	etherTypeVLAN  = 0x8100
+	etherTypeQinQ  = 0x88a8
+	etherTypeQinQ2 = 0x9100
+	etherTypeIPv6  = 0x86dd
+	etherTypeTEB   = 0x6558
+
+	protoHopByHop = 0
+	protoICMP     = 1
+	protoIPIP     = 4
+	protoTCP      = 6
+	protoUDP      = 17
+	protoRouting  = 43
+	protoIPv6     = 41
+	protoFragment = 44
+	protoGRE      = 47
+	protoAH       = 51
+	protoICMPv6   = 58
+	protoDestOpts = 60
+)
+
+// Packet anonymizes, in place, the addresses in a single captured packet with
+// the given link type. The IPv4 header checksum and the TCP, UDP, ICMP, and
+// ICMPv6 checksums are fixed up incrementally, so that they remain correct
+// even when the packet was truncated by the capture's snaplen. The headers
+// quoted inside ICMP error messages, and the inner headers of IP-in-IP and
+// GRE tunnels, are anonymized too. Addresses that were only partially
+// captured are zeroed.
+func Packet(anon anonymize.IPAnonymizer, linkType int, data []byte) error {
+	switch linkType {
+	case LinkTypeNull:
+		// A 4 byte address family in the capturing host's byte order.
+		// Rather than guess the byte order, dispatch on the IP version.
+		if len(data) > 4 {
+			ipPacket(anon, data[4:], false)
+		}
+	case LinkTypeEthernet:
+		ethernet(anon, data)
+	case LinkTypeLinuxSLL:
+		if len(data) >= 16 {
+			etherPayload(anon, binary.BigEndian.Uint16(data[14:16]), data[16:])
+		}
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+		ipPacket(anon, data, false)
+	default:
+		return fmt.Errorf("%w: %d", ErrUnsupportedLinkType, linkType)
+	}
+	return nil
+}
+
+func ethernet(anon anonymize.IPAnonymizer, data []byte) {
+	off := 12
+	for len(data) >= off+2 {
+		etherType := binary.BigEndian.Uint16(data[off : off+2])
+		switch etherType {
+		case etherTypeVLAN, etherTypeQinQ, etherTypeQinQ2:
+			off += 4
+			continue
+		}
+		etherPayload(anon, etherType, data[off+2:])
+		return
+	}
+}
+
+func etherPayload(anon anonymize.IPAnonymizer, etherType uint16, data []byte) {
+	switch etherType {
+	case etherTypeIPv4, etherTypeIPv6:
+		ipPacket(anon, data, false)
+	case etherTypeARP:
+		arp(anon, data)
+	}
+}
+
+// arp anonymizes the sender and target protocol addresses of an ARP packet
+// carrying IPv4 addresses.
+func arp(anon anonymize.IPAnonymizer, data []byte) {
+	if len(data) < 6 || binary.BigEndian.Uint16(data[2:4]) != etherTypeIPv4 || data[5] != net.IPv4len {
+		return
+	}
+	hlen := int(data[4])
+	spa := 8 + hlen
+	tpa := spa + net.IPv4len + hlen
+	anonymizeAddr(anon, data, spa, net.IPv4len)
+	anonymizeAddr(anon, data, tpa, net.IPv4len)
+}
+
+// anonymizeAddr anonymizes the size byte address at data[off:]. If the
+// address was only partially captured, the captured part is zeroed.
+func anonymizeAddr(anon anonymize.IPAnonymizer, data []byte, off, size int) {
+	if off >= len(data) {
+		return
+	}
+	if off+size > len(data) {
+		zero(data[off:])
+		return
+	}
+	anon.IP(net.IP(data[off : off+size]))
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// ipPacket anonymizes an IPv4 or IPv6 packet. Quoted packets inside ICMP
+// errors are handled by a recursive call with quoted set to true.
+func ipPacket(anon anonymize.IPAnonymizer, pkt []byte, quoted bool) {
+	if len(pkt) == 0 {
+		return
+	}
+	switch pkt[0] >> 4 {
+	case 4:
+		ipv4(anon, pkt, quoted)
+	case 6:
+		ipv6(anon, pkt, quoted)
+	}
+}
+
+func ipv4(anon anonymize.IPAnonymizer, pkt []byte, quoted bool) {
+	const addrs, addrsEnd = 12, 20
+	if len(pkt) < addrsEnd {
+		if len(pkt) > addrs {
+			zero(pkt[addrs:])
+		}
+		return
+	}
+	old := append([]byte(nil), pkt[addrs:addrsEnd]...)
+	anon.IP(net.IP(pkt[addrs : addrs+net.IPv4len]))
+	anon.IP(net.IP(pkt[addrs+net.IPv4len : addrsEnd]))
+	updateChecksum(pkt, 10, old, pkt[addrs:addrsEnd])
+
+	ihl := int(pkt[0]&0x0f) * 4
+	fragOffset := binary.BigEndian.Uint16(pkt[6:8]) & 0x1fff
+	if ihl < 20 || ihl > len(pkt) || fragOffset != 0 {
+		return
+	}
+	transport(anon, pkt[9], pkt[ihl:], old, pkt[addrs:addrsEnd], quoted)
+}
+
+func ipv6(anon anonymize.IPAnonymizer, pkt []byte, quoted bool) {
+	const addrs, addrsEnd = 8, 40
+	if len(pkt) < addrsEnd {
+		if len(pkt) > addrs {
+			zero(pkt[addrs:])
+		}
+		return
+	}
+	old := append([]byte(nil), pkt[addrs:addrsEnd]...)
+	anon.IP(net.IP(pkt[addrs : addrs+net.IPv6len]))
+	anon.IP(net.IP(pkt[addrs+net.IPv6len : addrsEnd]))
+
+	// Skip over any extension headers to find the transport header.
+	next, off := pkt[6], addrsEnd
+	for {
+		switch next {
+		case protoHopByHop, protoRouting, protoDestOpts:
+			if len(pkt) < off+2 {
+				return
+			}
+			next, off = pkt[off], off+8+8*int(pkt[off+1])
+		case protoAH:
+			if len(pkt) < off+2 {
+				return
+			}
+			next, off = pkt[off], off+4*(int(pkt[off+1])+2)
+		case protoFragment:
+			if len(pkt) < off+8 {
+				return
+			}
+			if binary.BigEndian.Uint16(pkt[off+2:off+4])&0xfff8 != 0 {
+				// Only the first fragment holds the transport header.
+				return
+			}
+			next, off = pkt[off], off+8
+		default:
+			if off > len(pkt) {
+				return
+			}
+			transport(anon, next, pkt[off:], old, pkt[addrs:addrsEnd], quoted)
+			return
+		}
+	}
+}
+
+// transport fixes the checksums of a transport header after the addresses in
+// the pseudo-header changed from oldAddrs to newAddrs.
+func transport(anon anonymize.IPAnonymizer, proto byte, seg, oldAddrs, newAddrs []byte, quoted bool) {
+	switch proto {
+	case protoTCP:
+		updateChecksum(seg, 16, oldAddrs, newAddrs)
+	case protoUDP:
+		if len(seg) >= 8 && binary.BigEndian.Uint16(seg[6:8]) == 0 {
+			// UDP over IPv4 may omit the checksum.
+			return
+		}
+		updateChecksum(seg, 6, oldAddrs, newAddrs)
+		if len(seg) >= 8 && binary.BigEndian.Uint16(seg[6:8]) == 0 {
+			// A computed UDP checksum of zero is transmitted as all ones.
+			binary.BigEndian.PutUint16(seg[6:8], 0xffff)
+		}
+	case protoICMP:
+		// ICMP checksums do not cover a pseudo-header.
+		if !quoted && len(seg) > 8 && isICMPError(seg[0]) {
+			quote(anon, seg, 2)
+		}
+	case protoICMPv6:
+		updateChecksum(seg, 2, oldAddrs, newAddrs)
+		if !quoted && len(seg) > 8 && isICMPv6Error(seg[0]) {
+			quote(anon, seg, 2)
+		}
+	case protoIPIP, protoIPv6:
+		ipPacket(anon, seg, quoted)
+	case protoGRE:
+		gre(anon, seg, quoted)
+	}
+}
+
+// gre anonymizes the packet carried in a GRE packet, and fixes the optional
+// GRE checksum.
+func gre(anon anonymize.IPAnonymizer, seg []byte, quoted bool) {
+	if len(seg) < 4 || seg[1]&0x07 != 0 {
+		// Only version 0 carries IP or Ethernet payloads.
+		return
+	}
+	flags := seg[0]
+	off := 4
+	for _, bit := range []byte{0x80, 0x20, 0x10} {
+		// The checksum, key and sequence number fields are each 4 bytes.
+		if flags&bit != 0 {
+			off += 4
+		}
+	}
+	if off > len(seg) {
+		return
+	}
+	payload := seg[off:]
+	old := append([]byte(nil), payload...)
+	switch binary.BigEndian.Uint16(seg[2:4]) {
+	case etherTypeIPv4, etherTypeIPv6:
+		ipPacket(anon, payload, quoted)
+	case etherTypeTEB:
+		ethernet(anon, payload)
+	}
+	if flags&0x80 != 0 {
+		updateChecksum(seg, 4, old, payload)
+	}
+}
+
+// quote anonymizes the packet quoted in the body of an ICMP error message and
+// fixes the ICMP checksum at seg[sum:].
+func quote(anon anonymize.IPAnonymizer, seg []byte, sum int) {
+	body := seg[8:]
+	old := append([]byte(nil), body...)
+	ipPacket(anon, body, true)
+	updateChecksum(seg, sum, old, body)
+}
+
+func isICMPError(t byte) bool {
+	switch t {
+	case 3, 4, 5, 11, 12:
+		// Destination unreachable, source quench, redirect, time exceeded,
+		// and parameter problem.
+		return true
+	}
+	return false
+}
+
+func isICMPv6Error(t byte) bool {
+	// Destination unreachable, packet too big, time exceeded, and parameter
+	// problem.
+	return t >= 1 && t <= 4
+}
+
+// updateChecksum incrementally updates the 16 bit one's complement checksum
+// stored at data[off:off+2] after the bytes in old were replaced by the bytes
+// in new, as described in RFC 1624. The replaced bytes must have started at an
+// even offset within the checksummed data. Checksums that were not captured
+// are left alone.
+func updateChecksum(data []byte, off int, old, new []byte) {
+	if len(data) < off+2 {
+		return
+	}
+	sum := uint32(^binary.BigEndian.Uint16(data[off : off+2]))
+	for i := 0; i < len(old); i += 2 {
+		sum += uint32(^word(old, i)) + uint32(word(new, i))
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	binary.BigEndian.PutUint16(data[off:off+2], ^uint16(sum))
+}
+
+// word returns the 16 bit word at b[i:], padding an odd trailing byte with 0.
+func word(b []byte, i int) uint16 {
+	if i+1 < len(b) {
+		return binary.BigEndian.Uint16(b[i : i+2])
+	}
+	return uint16(b[i]) << 8
+}
@@ -0,0 +1,310 @@
+package scrub_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/m-lab/go/anonymize"
+	"github.com/m-lab/go/anonymize/scrub"
+)
+
+// checksum computes the 16 bit one's complement sum of all the passed-in
+// buffers, as if they had been concatenated.
+func checksum(bufs ...[]byte) uint16 {
+	var sum uint32
+	for _, b := range bufs {
+		for i := 0; i < len(b); i += 2 {
+			if i+1 < len(b) {
+				sum += uint32(binary.BigEndian.Uint16(b[i:]))
+			} else {
+				sum += uint32(b[i]) << 8
+			}
+		}
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+func pseudoV4(src, dst net.IP, proto byte, n int) []byte {
+	p := append(append([]byte{}, src.To4()...), dst.To4()...)
+	return append(p, 0, proto, byte(n>>8), byte(n))
+}
+
+func pseudoV6(src, dst net.IP, proto byte, n int) []byte {
+	p := append(append([]byte{}, src.To16()...), dst.To16()...)
+	return append(p, 0, 0, byte(n>>8), byte(n), 0, 0, 0, proto)
+}
+
+// ipv4Packet builds an IPv4 packet with correct checksums around the payload,
+// whose checksum is at sumOff (or -1 for no checksum).
+func ipv4Packet(src, dst string, proto byte, payload []byte, sumOff int) []byte {
+	s, d := net.ParseIP(src), net.ParseIP(dst)
+	hdr := []byte{0x45, 0, 0, 0, 0, 0, 0, 0, 64, proto, 0, 0}
+	binary.BigEndian.PutUint16(hdr[2:], uint16(20+len(payload)))
+	hdr = append(append(hdr, s.To4()...), d.To4()...)
+	binary.BigEndian.PutUint16(hdr[10:], checksum(hdr))
+	payload = append([]byte{}, payload...)
+	if sumOff >= 0 {
+		binary.BigEndian.PutUint16(payload[sumOff:], 0)
+		var sum uint16
+		if proto == 1 {
+			sum = checksum(payload)
+		} else {
+			sum = checksum(pseudoV4(s, d, proto, len(payload)), payload)
+		}
+		binary.BigEndian.PutUint16(payload[sumOff:], sum)
+	}
+	return append(hdr, payload...)
+}
+
+func ipv6Packet(src, dst string, proto byte, payload []byte, sumOff int) []byte {
+	s, d := net.ParseIP(src), net.ParseIP(dst)
+	hdr := []byte{0x60, 0, 0, 0, 0, 0, proto, 64}
+	binary.BigEndian.PutUint16(hdr[4:], uint16(len(payload)))
+	hdr = append(append(hdr, s...), d...)
+	payload = append([]byte{}, payload...)
+	binary.BigEndian.PutUint16(payload[sumOff:], 0)
+	binary.BigEndian.PutUint16(payload[sumOff:], checksum(pseudoV6(s, d, proto, len(payload)), payload))
+	return append(hdr, payload...)
+}
+
+func tcpSegment() []byte {
+	seg := make([]byte, 20)
+	binary.BigEndian.PutUint16(seg[0:], 12345)
+	binary.BigEndian.PutUint16(seg[2:], 443)
+	seg[12] = 5 << 4
+	return append(seg, []byte("hello, world")...)
+}
+
+func udpDatagram() []byte {
+	d := make([]byte, 8)
+	binary.BigEndian.PutUint16(d[0:], 5353)
+	binary.BigEndian.PutUint16(d[2:], 53)
+	binary.BigEndian.PutUint16(d[4:], 13)
+	return append(d, 'x', 'y', 'z', '!', '?')
+}
+
+func verifyV4(t *testing.T, pkt []byte, wantSrc, wantDst string) {
+	t.Helper()
+	if src := net.IP(pkt[12:16]).String(); src != wantSrc {
+		t.Errorf("src = %s, want %s", src, wantSrc)
+	}
+	if dst := net.IP(pkt[16:20]).String(); dst != wantDst {
+		t.Errorf("dst = %s, want %s", dst, wantDst)
+	}
+	if checksum(pkt[:20]) != 0 {
+		t.Error("Bad IPv4 header checksum")
+	}
+	payload := pkt[20:]
+	switch pkt[9] {
+	case 1:
+		if checksum(payload) != 0 {
+			t.Error("Bad ICMP checksum")
+		}
+	default:
+		if checksum(pseudoV4(net.IP(pkt[12:16]), net.IP(pkt[16:20]), pkt[9], len(payload)), payload) != 0 {
+			t.Errorf("Bad transport checksum for protocol %d", pkt[9])
+		}
+	}
+}
+
+func verifyV6(t *testing.T, pkt []byte, wantSrc, wantDst string) {
+	t.Helper()
+	if src := net.IP(pkt[8:24]).String(); src != wantSrc {
+		t.Errorf("src = %s, want %s", src, wantSrc)
+	}
+	if dst := net.IP(pkt[24:40]).String(); dst != wantDst {
+		t.Errorf("dst = %s, want %s", dst, wantDst)
+	}
+	payload := pkt[40:]
+	if checksum(pseudoV6(net.IP(pkt[8:24]), net.IP(pkt[24:40]), pkt[6], len(payload)), payload) != 0 {
+		t.Errorf("Bad transport checksum for protocol %d", pkt[6])
+	}
+}
+
+func ethernetFrame(etherType uint16, payload []byte) []byte {
+	f := make([]byte, 14)
+	binary.BigEndian.PutUint16(f[12:], etherType)
+	return append(f, payload...)
+}
+
+func TestPacketIPv4(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet(net.ParseIP("192.168.0.1"))
+	anon := anonymize.New(anonymize.Netblock)
+
+	tcp := ipv4Packet("10.1.2.3", "192.168.0.1", 6, tcpSegment(), 16)
+	if err := scrub.Packet(anon, scrub.LinkTypeRaw, tcp); err != nil {
+		t.Fatal(err)
+	}
+	verifyV4(t, tcp, "10.1.2.0", "192.168.0.1")
+
+	udp := ipv4Packet("10.1.2.3", "10.4.5.6", 17, udpDatagram(), 6)
+	frame := ethernetFrame(0x0800, udp)
+	if err := scrub.Packet(anon, scrub.LinkTypeEthernet, frame); err != nil {
+		t.Fatal(err)
+	}
+	verifyV4(t, frame[14:], "10.1.2.0", "10.4.5.0")
+
+	// UDP without a checksum should stay that way.
+	noSum := ipv4Packet("10.1.2.3", "10.4.5.6", 17, udpDatagram(), -1)
+	scrub.Packet(anon, scrub.LinkTypeIPv4, noSum)
+	if binary.BigEndian.Uint16(noSum[26:28]) != 0 {
+		t.Error("A UDP packet without a checksum gained one")
+	}
+
+	// VLAN tagged frames.
+	tagged := make([]byte, 12)
+	tagged = append(tagged, 0x81, 0x00, 0, 7)
+	tagged = append(tagged, ethernetFrame(0x0800, ipv4Packet("10.1.2.3", "10.4.5.6", 6, tcpSegment(), 16))[12:]...)
+	scrub.Packet(anon, scrub.LinkTypeEthernet, tagged)
+	verifyV4(t, tagged[18:], "10.1.2.0", "10.4.5.0")
+}
+
+func TestPacketICMPError(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	anon := anonymize.New(anonymize.Netblock)
+
+	quoted := ipv4Packet("10.4.5.6", "10.7.8.9", 17, udpDatagram(), 6)
+	icmp := append([]byte{3, 3, 0, 0, 0, 0, 0, 0}, quoted...)
+	pkt := ipv4Packet("10.1.2.3", "10.4.5.6", 1, icmp, 2)
+	// The null link type has a 4 byte header.
+	pkt = append([]byte{2, 0, 0, 0}, pkt...)
+	scrub.Packet(anon, scrub.LinkTypeNull, pkt)
+	verifyV4(t, pkt[4:], "10.1.2.0", "10.4.5.0")
+	verifyV4(t, pkt[4+28:], "10.4.5.0", "10.7.8.0")
+
+	quoted6 := ipv6Packet("2001:db8:1:2::3", "2001:db8:4:5::6", 6, tcpSegment(), 16)
+	icmp6 := append([]byte{1, 0, 0, 0, 0, 0, 0, 0}, quoted6...)
+	pkt6 := ipv6Packet("2001:db8:9:9::9", "2001:db8:1:2::3", 58, icmp6, 2)
+	scrub.Packet(anon, scrub.LinkTypeIPv6, pkt6)
+	verifyV6(t, pkt6, "2001:db8:9:9::", "2001:db8:1:2::")
+	verifyV6(t, pkt6[48:], "2001:db8:1:2::", "2001:db8:4:5::")
+}
+
+func TestPacketTunnels(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	anon := anonymize.New(anonymize.Netblock)
+
+	// IPv6 in IPv4.
+	inner6 := ipv6Packet("2001:db8:1:2::3", "2001:db8:4:5::6", 6, tcpSegment(), 16)
+	sit := ipv4Packet("10.1.2.3", "10.4.5.6", 41, inner6, -1)
+	scrub.Packet(anon, scrub.LinkTypeRaw, sit)
+	if src := net.IP(sit[12:16]).String(); src != "10.1.2.0" || checksum(sit[:20]) != 0 {
+		t.Errorf("Outer src = %s, or bad header checksum", src)
+	}
+	verifyV6(t, sit[20:], "2001:db8:1:2::", "2001:db8:4:5::")
+
+	// IPv4 in IPv4.
+	ipip := ipv4Packet("10.1.2.3", "10.4.5.6", 4, ipv4Packet("10.7.8.9", "10.10.11.12", 17, udpDatagram(), 6), -1)
+	scrub.Packet(anon, scrub.LinkTypeRaw, ipip)
+	verifyV4(t, ipip[20:], "10.7.8.0", "10.10.11.0")
+
+	// GRE with a checksum and a key, carrying IPv4.
+	inner := ipv4Packet("10.7.8.9", "10.10.11.12", 6, tcpSegment(), 16)
+	greHdr := []byte{0xa0, 0, 0x08, 0x00, 0, 0, 0, 0, 0, 0, 0, 42}
+	grePkt := append(greHdr, inner...)
+	binary.BigEndian.PutUint16(grePkt[4:], checksum(grePkt))
+	pkt := ipv4Packet("10.1.2.3", "10.4.5.6", 47, grePkt, -1)
+	scrub.Packet(anon, scrub.LinkTypeRaw, pkt)
+	verifyV4(t, pkt[20+12:], "10.7.8.0", "10.10.11.0")
+	if checksum(pkt[20:]) != 0 {
+		t.Error("Bad GRE checksum")
+	}
+
+	// GRE carrying Ethernet, without a checksum.
+	frame := ethernetFrame(0x86dd, ipv6Packet("2001:db8:1:2::3", "2001:db8:4:5::6", 17, udpDatagram(), 6))
+	teb := ipv4Packet("10.1.2.3", "10.4.5.6", 47, append([]byte{0, 0, 0x65, 0x58}, frame...), -1)
+	scrub.Packet(anon, scrub.LinkTypeRaw, teb)
+	verifyV6(t, teb[20+4+14:], "2001:db8:1:2::", "2001:db8:4:5::")
+}
+
+func TestPacketIPv6(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	anon := anonymize.New(anonymize.Netblock)
+
+	tcp := ipv6Packet("2001:db8:1:2:3:4:5:6", "2001:db8:a:b:c:d:e:f", 6, tcpSegment(), 16)
+	frame := ethernetFrame(0x86dd, tcp)
+	scrub.Packet(anon, scrub.LinkTypeEthernet, frame)
+	verifyV6(t, frame[14:], "2001:db8:1:2::", "2001:db8:a:b::")
+
+	// A UDP packet behind hop-by-hop and destination options headers, in a
+	// Linux cooked capture.
+	udp := ipv6Packet("2001:db8:1:2:3:4:5:6", "2001:db8:a:b:c:d:e:f", 17, udpDatagram(), 6)
+	withExt := append([]byte{}, udp[:40]...)
+	withExt[6] = 0
+	withExt = append(withExt, 60, 0, 0, 0, 0, 0, 0, 0)
+	withExt = append(withExt, 17, 0, 0, 0, 0, 0, 0, 0)
+	withExt = append(withExt, udp[40:]...)
+	sll := make([]byte, 16)
+	binary.BigEndian.PutUint16(sll[14:], 0x86dd)
+	sll = append(sll, withExt...)
+	scrub.Packet(anon, scrub.LinkTypeLinuxSLL, sll)
+	got := sll[16:]
+	if src := net.IP(got[8:24]).String(); src != "2001:db8:1:2::" {
+		t.Errorf("src = %s", src)
+	}
+	payload := got[56:]
+	if checksum(pseudoV6(net.IP(got[8:24]), net.IP(got[24:40]), 17, len(payload)), payload) != 0 {
+		t.Error("Bad UDP checksum after extension headers")
+	}
+}
+
+func TestPacketARPAndTruncation(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	anon := anonymize.New(anonymize.Netblock)
+
+	arp := []byte{0, 1, 8, 0, 6, 4, 0, 1}
+	arp = append(arp, 1, 2, 3, 4, 5, 6, 10, 1, 2, 3)
+	arp = append(arp, 0, 0, 0, 0, 0, 0, 10, 4, 5, 6)
+	frame := ethernetFrame(0x0806, arp)
+	scrub.Packet(anon, scrub.LinkTypeEthernet, frame)
+	if spa, tpa := net.IP(frame[28:32]).String(), net.IP(frame[38:42]).String(); spa != "10.1.2.0" || tpa != "10.4.5.0" {
+		t.Errorf("ARP addresses = %s, %s", spa, tpa)
+	}
+
+	// A packet cut off in the middle of the source address must not leak
+	// the captured part of it.
+	pkt := ipv4Packet("10.1.2.3", "10.4.5.6", 6, tcpSegment(), 16)[:14]
+	scrub.Packet(anon, scrub.LinkTypeRaw, pkt)
+	if pkt[12] != 0 || pkt[13] != 0 {
+		t.Errorf("Truncated address was not zeroed: %v", pkt[12:])
+	}
+	pkt6 := ipv6Packet("2001:db8:1:2:3:4:5:6", "2001:db8:a:b:c:d:e:f", 6, tcpSegment(), 16)[:30]
+	scrub.Packet(anon, scrub.LinkTypeRaw, pkt6)
+	for _, b := range pkt6[8:] {
+		if b != 0 {
+			t.Fatalf("Truncated address was not zeroed: %v", pkt6[8:])
+		}
+	}
+	// A packet cut off in the transport header still gets its addresses
+	// anonymized.
+	short := ipv4Packet("10.1.2.3", "10.4.5.6", 6, tcpSegment(), 16)[:24]
+	scrub.Packet(anon, scrub.LinkTypeRaw, short)
+	if src := net.IP(short[12:16]).String(); src != "10.1.2.0" {
+		t.Errorf("src = %s", src)
+	}
+
+	// Nothing to do, but no crash.
+	for _, lt := range []int{scrub.LinkTypeNull, scrub.LinkTypeEthernet, scrub.LinkTypeLinuxSLL, scrub.LinkTypeRaw} {
+		if err := scrub.Packet(anon, lt, []byte{}); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := scrub.Packet(anon, 147, []byte{}); !errors.Is(err, scrub.ErrUnsupportedLinkType) {
+		t.Errorf("Packet() = %v, want %v", err, scrub.ErrUnsupportedLinkType)
+	}
+}
@@ -0,0 +1,240 @@
+package scrub
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/m-lab/go/anonymize"
+)
+
+// File format constants for pcap and pcapng files. See
+// https://www.tcpdump.org/manpages/pcap-savefile.5.html and
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-03.html
+const (
+	pcapMagicMicros = 0xa1b2c3d4
+	pcapMagicNanos  = 0xa1b23c4d
+	pcapHeaderLen   = 24
+	pcapRecordLen   = 16
+
+	pcapngSectionHeader     = 0x0a0d0d0a
+	pcapngByteOrderMagic    = 0x1a2b3c4d
+	pcapngInterfaceDesc     = 0x00000001
+	pcapngObsoletePacket    = 0x00000002
+	pcapngSimplePacket      = 0x00000003
+	pcapngNameResolution    = 0x00000004
+	pcapngEnhancedPacket    = 0x00000006
+	pcapngMinBlockLen       = 12
+	pcapngPacketDataOffset  = 28
+	pcapngSimpleDataOffset  = 12
+	pcapngBlockTrailerLen   = 4
+	pcapngBlockHeaderLen    = 8
+	pcapngInterfaceBodySize = 8
+
+	pcapngOptionHeaderLen = 4
+	pcapngOptEndOfOpt     = 0
+	pcapngOptIfIPv4Addr   = 4
+	pcapngOptIfIPv6Addr   = 5
+
+	// maxRecordLen protects against allocating huge buffers for corrupt
+	// files.
+	maxRecordLen = 1 << 28
+)
+
+// ErrBadCapture is returned when the input is not a valid pcap or pcapng file.
+var ErrBadCapture = errors.New("malformed packet capture")
+
+// Pcap copies the pcap or pcapng capture in r to w, anonymizing the addresses
+// in every packet with Packet. The format is detected automatically and
+// preserved. Name resolution blocks in pcapng files, which map addresses to
+// host names, are dropped, and the addresses in the if_IPv4addr and
+// if_IPv6addr options of interface description blocks are anonymized.
+func Pcap(anon anonymize.IPAnonymizer, r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadCapture, err)
+	}
+	if binary.LittleEndian.Uint32(magic) == pcapngSectionHeader {
+		return pcapng(anon, br, w)
+	}
+	return pcap(anon, br, w)
+}
+
+func pcap(anon anonymize.IPAnonymizer, r io.Reader, w io.Writer) error {
+	hdr := make([]byte, pcapHeaderLen)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadCapture, err)
+	}
+	var order binary.ByteOrder
+	switch {
+	case isPcapMagic(binary.LittleEndian.Uint32(hdr)):
+		order = binary.LittleEndian
+	case isPcapMagic(binary.BigEndian.Uint32(hdr)):
+		order = binary.BigEndian
+	default:
+		return fmt.Errorf("%w: unknown magic number %x", ErrBadCapture, hdr[:4])
+	}
+	// The upper bits of the link type field hold FCS information.
+	linkType := int(order.Uint32(hdr[20:24]) & 0xffff)
+	if err := Packet(anon, linkType, nil); err != nil {
+		return err
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+
+	rec := make([]byte, pcapRecordLen)
+	for {
+		if _, err := io.ReadFull(r, rec); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("%w: %v", ErrBadCapture, err)
+		}
+		n := order.Uint32(rec[8:12])
+		if n > maxRecordLen {
+			return fmt.Errorf("%w: record length %d is too long", ErrBadCapture, n)
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadCapture, err)
+		}
+		// The link type was checked above, so this can't fail.
+		Packet(anon, linkType, data)
+		if _, err := w.Write(rec); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+}
+
+func isPcapMagic(m uint32) bool {
+	return m == pcapMagicMicros || m == pcapMagicNanos
+}
+
+func pcapng(anon anonymize.IPAnonymizer, r io.Reader, w io.Writer) error {
+	var order binary.ByteOrder = binary.LittleEndian
+	var linkTypes []int
+	hdr := make([]byte, pcapngBlockHeaderLen)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("%w: %v", ErrBadCapture, err)
+		}
+		blockType := order.Uint32(hdr[0:4])
+		var block []byte
+		if binary.LittleEndian.Uint32(hdr[0:4]) == pcapngSectionHeader {
+			// Every section may have a different byte order, recorded just
+			// after the block header.
+			bom := make([]byte, 4)
+			if _, err := io.ReadFull(r, bom); err != nil {
+				return fmt.Errorf("%w: %v", ErrBadCapture, err)
+			}
+			switch {
+			case binary.LittleEndian.Uint32(bom) == pcapngByteOrderMagic:
+				order = binary.LittleEndian
+			case binary.BigEndian.Uint32(bom) == pcapngByteOrderMagic:
+				order = binary.BigEndian
+			default:
+				return fmt.Errorf("%w: unknown byte order magic %x", ErrBadCapture, bom)
+			}
+			blockType = pcapngSectionHeader
+			linkTypes = nil
+			block = append(append([]byte(nil), hdr...), bom...)
+		} else {
+			block = append([]byte(nil), hdr...)
+		}
+
+		n := order.Uint32(hdr[4:8])
+		if n < pcapngMinBlockLen || n%4 != 0 || n > maxRecordLen || int(n) < len(block)+pcapngBlockTrailerLen {
+			return fmt.Errorf("%w: bad block length %d", ErrBadCapture, n)
+		}
+		rest := make([]byte, int(n)-len(block))
+		if _, err := io.ReadFull(r, rest); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadCapture, err)
+		}
+		block = append(block, rest...)
+		body := block[pcapngBlockHeaderLen : len(block)-pcapngBlockTrailerLen]
+
+		switch blockType {
+		case pcapngInterfaceDesc:
+			if len(body) < pcapngInterfaceBodySize {
+				return fmt.Errorf("%w: short interface description block", ErrBadCapture)
+			}
+			linkType := int(order.Uint16(body[0:2]))
+			if err := Packet(anon, linkType, nil); err != nil {
+				return err
+			}
+			if err := interfaceOptions(anon, order, body[pcapngInterfaceBodySize:]); err != nil {
+				return err
+			}
+			linkTypes = append(linkTypes, linkType)
+		case pcapngEnhancedPacket, pcapngObsoletePacket:
+			if len(body) < pcapngPacketDataOffset-pcapngBlockHeaderLen {
+				return fmt.Errorf("%w: short packet block", ErrBadCapture)
+			}
+			var iface int
+			if blockType == pcapngEnhancedPacket {
+				iface = int(order.Uint32(body[0:4]))
+			} else {
+				iface = int(order.Uint16(body[0:2]))
+			}
+			capLen := int(order.Uint32(body[12:16]))
+			data := body[pcapngPacketDataOffset-pcapngBlockHeaderLen:]
+			if iface >= len(linkTypes) || capLen > len(data) {
+				return fmt.Errorf("%w: bad packet block", ErrBadCapture)
+			}
+			Packet(anon, linkTypes[iface], data[:capLen])
+		case pcapngSimplePacket:
+			if len(body) < pcapngSimpleDataOffset-pcapngBlockHeaderLen || len(linkTypes) == 0 {
+				return fmt.Errorf("%w: bad simple packet block", ErrBadCapture)
+			}
+			data := body[pcapngSimpleDataOffset-pcapngBlockHeaderLen:]
+			if origLen := int(order.Uint32(body[0:4])); origLen < len(data) {
+				data = data[:origLen]
+			}
+			Packet(anon, linkTypes[0], data)
+		case pcapngNameResolution:
+			continue
+		}
+		if _, err := w.Write(block); err != nil {
+			return err
+		}
+	}
+}
+
+// interfaceOptions anonymizes the interface addresses in the options of an
+// interface description block. Each if_IPv4addr option holds an address and a
+// netmask, and each if_IPv6addr option an address and a prefix length.
+func interfaceOptions(anon anonymize.IPAnonymizer, order binary.ByteOrder, opts []byte) error {
+	for len(opts) >= pcapngOptionHeaderLen {
+		code := order.Uint16(opts[0:2])
+		n := int(order.Uint16(opts[2:4]))
+		if code == pcapngOptEndOfOpt {
+			return nil
+		}
+		padded := pcapngOptionHeaderLen + (n+3)/4*4
+		if padded > len(opts) {
+			return fmt.Errorf("%w: bad interface option length %d", ErrBadCapture, n)
+		}
+		value := opts[pcapngOptionHeaderLen : pcapngOptionHeaderLen+n]
+		switch {
+		case code == pcapngOptIfIPv4Addr && n == 2*net.IPv4len:
+			anon.IP(net.IP(value[:net.IPv4len]))
+		case code == pcapngOptIfIPv6Addr && n == net.IPv6len+1:
+			anon.IP(net.IP(value[:net.IPv6len]))
+		case code == pcapngOptIfIPv4Addr || code == pcapngOptIfIPv6Addr:
+			return fmt.Errorf("%w: bad interface address option length %d", ErrBadCapture, n)
+		}
+		opts = opts[padded:]
+	}
+	return nil
+}
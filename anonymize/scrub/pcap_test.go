@@ -0,0 +1,209 @@
+package scrub_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/m-lab/go/anonymize"
+	"github.com/m-lab/go/anonymize/scrub"
+)
+
+func pcapFile(order binary.ByteOrder, linkType uint32, packets ...[]byte) []byte {
+	hdr := make([]byte, 24)
+	order.PutUint32(hdr[0:], 0xa1b2c3d4)
+	order.PutUint16(hdr[4:], 2)
+	order.PutUint16(hdr[6:], 4)
+	order.PutUint32(hdr[16:], 65535)
+	order.PutUint32(hdr[20:], linkType)
+	for i, p := range packets {
+		rec := make([]byte, 16)
+		order.PutUint32(rec[0:], uint32(1000+i))
+		order.PutUint32(rec[8:], uint32(len(p)))
+		order.PutUint32(rec[12:], uint32(len(p)))
+		hdr = append(append(hdr, rec...), p...)
+	}
+	return hdr
+}
+
+func pcapngBlock(order binary.ByteOrder, blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	b := make([]byte, 8)
+	order.PutUint32(b[0:], blockType)
+	order.PutUint32(b[4:], uint32(12+len(body)))
+	b = append(b, body...)
+	return append(b, b[4:8]...)
+}
+
+func pcapngFile(order binary.ByteOrder, packet []byte) []byte {
+	shb := make([]byte, 16)
+	order.PutUint32(shb[0:], 0x1a2b3c4d)
+	order.PutUint16(shb[4:], 1)
+	binary.BigEndian.PutUint64(shb[8:], 0xffffffffffffffff)
+	idb := make([]byte, 8)
+	order.PutUint16(idb[0:], scrub.LinkTypeEthernet)
+	epb := make([]byte, 20)
+	order.PutUint32(epb[12:], uint32(len(packet)))
+	order.PutUint32(epb[16:], uint32(len(packet)))
+	epb = append(epb, packet...)
+	spb := make([]byte, 4)
+	order.PutUint32(spb[0:], uint32(len(packet)))
+	spb = append(spb, packet...)
+	// A name resolution record for 10.1.2.3.
+	nrb := []byte{0, 0, 0, 0, 10, 1, 2, 3, 'h', 'o', 's', 't', 0, 0, 0, 0, 0, 0, 0, 0}
+	order.PutUint16(nrb[0:], 1)
+	order.PutUint16(nrb[2:], 9)
+
+	f := pcapngBlock(order, 0x0a0d0d0a, shb)
+	f = append(f, pcapngBlock(order, 1, idb)...)
+	f = append(f, pcapngBlock(order, 4, nrb)...)
+	f = append(f, pcapngBlock(order, 6, epb)...)
+	f = append(f, pcapngBlock(order, 3, spb)...)
+	return f
+}
+
+func TestPcap(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	anon := anonymize.New(anonymize.Netblock)
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			p1 := ethernetFrame(0x0800, ipv4Packet("10.1.2.3", "10.4.5.6", 6, tcpSegment(), 16))
+			p2 := ethernetFrame(0x86dd, ipv6Packet("2001:db8:1:2:3:4:5:6", "2001:db8:a:b:c:d:e:f", 17, udpDatagram(), 6))
+			in := pcapFile(order, scrub.LinkTypeEthernet, p1, p2)
+			out := &bytes.Buffer{}
+			if err := scrub.Pcap(anon, bytes.NewReader(in), out); err != nil {
+				t.Fatal(err)
+			}
+			if out.Len() != len(in) {
+				t.Fatalf("Output length %d != input length %d", out.Len(), len(in))
+			}
+			b := out.Bytes()
+			if !bytes.Equal(b[:24], in[:24]) {
+				t.Error("The file header changed")
+			}
+			start1 := 24 + 16
+			verifyV4(t, b[start1+14:start1+len(p1)], "10.1.2.0", "10.4.5.0")
+			start2 := start1 + len(p1) + 16
+			verifyV6(t, b[start2+14:start2+len(p2)], "2001:db8:1:2::", "2001:db8:a:b::")
+		})
+	}
+}
+
+func TestPcapng(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	anon := anonymize.New(anonymize.Netblock)
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			pkt := ethernetFrame(0x0800, ipv4Packet("10.1.2.3", "10.4.5.6", 6, tcpSegment(), 16))
+			in := pcapngFile(order, pkt)
+			out := &bytes.Buffer{}
+			if err := scrub.Pcap(anon, bytes.NewReader(in), out); err != nil {
+				t.Fatal(err)
+			}
+			b := out.Bytes()
+			if bytes.Contains(b, []byte("host")) {
+				t.Error("The name resolution block was not removed")
+			}
+			if bytes.Contains(b, []byte{10, 1, 2, 3}) {
+				t.Error("The original address is still in the output")
+			}
+			// SHB (28 bytes) and IDB (20 bytes), then the EPB and SPB.
+			epb := b[28+20:]
+			verifyV4(t, epb[28+14:28+len(pkt)], "10.1.2.0", "10.4.5.0")
+			spb := epb[order.Uint32(epb[4:8]):]
+			verifyV4(t, spb[12+14:12+len(pkt)], "10.1.2.0", "10.4.5.0")
+		})
+	}
+}
+
+func TestPcapngInterfaceAddresses(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	anon := anonymize.New(anonymize.Netblock)
+	order := binary.LittleEndian
+	shb := make([]byte, 16)
+	order.PutUint32(shb[0:], 0x1a2b3c4d)
+	order.PutUint16(shb[4:], 1)
+	idb := make([]byte, 8)
+	order.PutUint16(idb[0:], scrub.LinkTypeEthernet)
+	// if_name, if_IPv4addr, if_IPv6addr and opt_endofopt.
+	idb = append(idb, 2, 0, 4, 0, 'e', 't', 'h', '0')
+	idb = append(idb, 4, 0, 8, 0, 10, 1, 2, 3, 255, 255, 255, 0)
+	idb = append(idb, 5, 0, 17, 0, 0x20, 0x01, 0x0d, 0xb8, 0, 1, 0, 2, 0, 3, 0, 4, 0, 5, 0, 6, 64, 0, 0, 0)
+	idb = append(idb, 0, 0, 0, 0)
+	in := append(pcapngBlock(order, 0x0a0d0d0a, shb), pcapngBlock(order, 1, idb)...)
+
+	out := &bytes.Buffer{}
+	if err := scrub.Pcap(anon, bytes.NewReader(in), out); err != nil {
+		t.Fatal(err)
+	}
+	opts := out.Bytes()[28+16:]
+	if !bytes.Equal(opts[:8], []byte{2, 0, 4, 0, 'e', 't', 'h', '0'}) {
+		t.Errorf("if_name changed: %q", opts[:8])
+	}
+	if got := opts[12:20]; !bytes.Equal(got, []byte{10, 1, 2, 0, 255, 255, 255, 0}) {
+		t.Errorf("if_IPv4addr = %v, want 10.1.2.0/255.255.255.0", got)
+	}
+	want6 := []byte{0x20, 0x01, 0x0d, 0xb8, 0, 1, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 64}
+	if got := opts[24:41]; !bytes.Equal(got, want6) {
+		t.Errorf("if_IPv6addr = %v, want %v", got, want6)
+	}
+
+	// A malformed address option is rejected.
+	bad := append([]byte{}, in...)
+	bad[28+16+8+2] = 6
+	if err := scrub.Pcap(anon, bytes.NewReader(bad), &bytes.Buffer{}); !errors.Is(err, scrub.ErrBadCapture) {
+		t.Errorf("Pcap() = %v, want %v", err, scrub.ErrBadCapture)
+	}
+}
+
+func TestPcapErrors(t *testing.T) {
+	anon := anonymize.New(anonymize.None)
+	good := pcapFile(binary.LittleEndian, scrub.LinkTypeRaw, ipv4Packet("10.1.2.3", "10.4.5.6", 6, tcpSegment(), 16))
+	badLink := pcapFile(binary.LittleEndian, 147)
+	ng := pcapngFile(binary.LittleEndian, ethernetFrame(0x0800, ipv4Packet("10.1.2.3", "10.4.5.6", 6, tcpSegment(), 16)))
+	badBOM := append([]byte{}, ng...)
+	badBOM[8] = 0
+	badLen := append([]byte{}, ng...)
+	badLen[4] = 13
+	// The SHB followed directly by the EPB.
+	noIDB := append(append([]byte{}, ng[:28]...), ng[28+20+32:]...)
+	tests := []struct {
+		name string
+		in   []byte
+		want error
+	}{
+		{"empty", []byte{}, scrub.ErrBadCapture},
+		{"short-header", good[:10], scrub.ErrBadCapture},
+		{"bad-magic", []byte("this is not a pcap file at all"), scrub.ErrBadCapture},
+		{"short-record", good[:30], scrub.ErrBadCapture},
+		{"short-data", good[:len(good)-1], scrub.ErrBadCapture},
+		{"bad-link-type", badLink, scrub.ErrUnsupportedLinkType},
+		{"pcapng-short", ng[:10], scrub.ErrBadCapture},
+		{"pcapng-bad-bom", badBOM, scrub.ErrBadCapture},
+		{"pcapng-bad-len", badLen, scrub.ErrBadCapture},
+		{"pcapng-truncated", ng[:len(ng)-2], scrub.ErrBadCapture},
+		{"pcapng-no-interface", noIDB, scrub.ErrBadCapture},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := scrub.Pcap(anon, bytes.NewReader(tt.in), &bytes.Buffer{})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Pcap() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+	// A valid file should round trip unchanged with no anonymization.
+	out := &bytes.Buffer{}
+	if err := scrub.Pcap(anon, bytes.NewReader(good), out); err != nil || !bytes.Equal(out.Bytes(), good) {
+		t.Errorf("Pcap() = %v, and changed the file", err)
+	}
+}
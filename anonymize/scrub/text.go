@@ -0,0 +1,112 @@
+package scrub
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/m-lab/go/anonymize"
+)
+
+// candidate matches runs of characters that might make up an address.
+// Brackets, zones, and anything else around the address is left untouched.
+var candidate = regexp.MustCompile(`[0-9A-Fa-f:.]*[:.][0-9A-Fa-f:.]*`)
+
+// Text copies r to w line by line, anonymizing every IPv4 and IPv6 address
+// found in each line with Line.
+func Text(anon anonymize.IPAnonymizer, r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			if _, werr := io.WriteString(w, Line(anon, line)); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Line returns a copy of the line with every IPv4 and IPv6 address in it
+// anonymized. Addresses followed by a port ("10.1.2.3:80"), at the end of a
+// sentence ("from 10.1.2.3."), in brackets ("[2001:db8::1]:80"), with a zone
+// ("fe80::1%eth0"), after a key and a colon ("src:10.1.2.3"), or followed by
+// hex letters ("10.1.2.3abc") are all recognized. It errs on the side of
+// privacy, so anything that looks like an address, such as a four part version
+// number, is rewritten too.
+func Line(anon anonymize.IPAnonymizer, line string) string {
+	return candidate.ReplaceAllStringFunc(line, func(tok string) string {
+		return token(anon, tok)
+	})
+}
+
+// token anonymizes the first address in tok, if any. Since hex letters and
+// colons are part of candidates, an address may be glued to the text before
+// it, as in "addr:10.1.2.3" or "ip:2001:db8::1". If tok does not start with
+// an address, the address is looked for after each leading colon or hex
+// letter.
+func token(anon anonymize.IPAnonymizer, tok string) string {
+	for i := 0; i < len(tok); i++ {
+		if i > 0 && !isPrefixEnd(tok[i-1]) {
+			continue
+		}
+		if a, ok := address(anon, tok[i:]); ok {
+			return tok[:i] + a
+		}
+	}
+	return tok
+}
+
+// isPrefixEnd returns true if an address may start after the byte c. An
+// address never starts after a digit or a dot, so that e.g. "999.1.2.3" is
+// not read as "99.1.2.3".
+func isPrefixEnd(c byte) bool {
+	return c == ':' || isHexLetter(c)
+}
+
+func isHexLetter(c byte) bool {
+	return (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// address anonymizes the address at the start of tok. It returns false if tok
+// does not start with an address.
+func address(anon anonymize.IPAnonymizer, tok string) (string, bool) {
+	if ip := net.ParseIP(tok); ip != nil {
+		anon.IP(ip)
+		return ip.String(), true
+	}
+	// An IPv4 address followed by a port.
+	if i := strings.LastIndexByte(tok, ':'); i > 0 && !strings.Contains(tok[:i], ":") {
+		if ip := net.ParseIP(tok[:i]); ip != nil {
+			anon.IP(ip)
+			return ip.String() + tok[i:], true
+		}
+	}
+	// Trailing punctuation.
+	if trimmed := strings.TrimRight(tok, ".:"); trimmed != tok && trimmed != "" {
+		if a, ok := address(anon, trimmed); ok {
+			return a + tok[len(trimmed):], true
+		}
+	}
+	// An address glued to the text after it, as in "10.1.2.3abc". The
+	// longest prefix that ends before a hex letter and parses wins. An
+	// address never ends before a digit or a dot, so that e.g. "10.1.2.300"
+	// is not read as "10.1.2.30".
+	for i := len(tok) - 1; i > 0; i-- {
+		if !isHexLetter(tok[i]) {
+			continue
+		}
+		if ip := net.ParseIP(tok[:i]); ip != nil {
+			anon.IP(ip)
+			return ip.String() + tok[i:], true
+		}
+	}
+	return tok, false
+}
@@ -0,0 +1,86 @@
+package scrub_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/go/anonymize"
+	"github.com/m-lab/go/anonymize/scrub"
+)
+
+func TestLine(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet(net.ParseIP("192.168.0.1"))
+	anon := anonymize.New(anonymize.Netblock)
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"no addresses here", "no addresses here"},
+		{"10.1.2.3 - - [01/Jan/2020:12:34:56 +0000] GET /", "10.1.2.0 - - [01/Jan/2020:12:34:56 +0000] GET /"},
+		{"from 10.1.2.3:443 to 192.168.0.1:80", "from 10.1.2.0:443 to 192.168.0.1:80"},
+		{"client=[2001:db8:1:2:3:4:5:6]:443", "client=[2001:db8:1:2::]:443"},
+		{"link-local fe80::1:2:3:4%eth0 up", "link-local fe80::%eth0 up"},
+		{"connection from 10.1.2.3.", "connection from 10.1.2.0."},
+		{"mapped ::ffff:10.1.2.3 address", "mapped 10.1.2.0 address"},
+		{"ip=10.1.2.3,2001:db8::1", "ip=10.1.2.0,2001:db8::"},
+		{"at 12:34:56 the deadbeef added a.b", "at 12:34:56 the deadbeef added a.b"},
+		{"999.1.2.3 is not an address", "999.1.2.3 is not an address"},
+		{"addr:10.1.2.3 src:10.4.5.6:80", "addr:10.1.2.0 src:10.4.5.0:80"},
+		{"key=10.1.2.3 key=2001:db8:1:2::3", "key=10.1.2.0 key=2001:db8:1:2::"},
+		{"ip:2001:db8:1:2:3:4:5:6 up", "ip:2001:db8:1:2:: up"},
+		{"ip:2001:db8:1:2::1:2:3.", "ip:2001:db8:1:2::."},
+		{"deadbeef:10.1.2.3", "deadbeef:10.1.2.0"},
+		{"cafe2001:db8:1:2::3", "cafe2001:db8:1:2::"},
+		{"peer:[2001:db8:1:2::3]:443", "peer:[2001:db8:1:2::]:443"},
+		{"id=10.1.2.3abc", "id=10.1.2.0abc"},
+		{"10.1.2.3DEAD:80 up", "10.1.2.0DEAD:80 up"},
+		{"addr:10.1.2.3beef.", "addr:10.1.2.0beef."},
+		{"10.1.2.300abc", "10.1.2.300abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := scrub.Line(anon, tt.in); got != tt.want {
+				t.Errorf("Line() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+type failReader struct{}
+
+func (failReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestText(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	anon := anonymize.New(anonymize.Netblock)
+	in := "first 10.1.2.3\nsecond 2001:db8:1:2::3\nno newline 10.4.5.6"
+	out := &bytes.Buffer{}
+	if err := scrub.Text(anon, strings.NewReader(in), out); err != nil {
+		t.Fatal(err)
+	}
+	want := "first 10.1.2.0\nsecond 2001:db8:1:2::\nno newline 10.4.5.0"
+	if out.String() != want {
+		t.Errorf("Text() = %q, want %q", out.String(), want)
+	}
+	if err := scrub.Text(anon, strings.NewReader(in), failWriter{}); err == nil {
+		t.Error("Text() should fail when the writer fails")
+	}
+	if err := scrub.Text(anon, failReader{}, out); err == nil {
+		t.Error("Text() should fail when the reader fails")
+	}
+}
@@ -0,0 +1,269 @@
+package anonymize
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+)
+
+// TagName is the struct tag key that marks fields for anonymization. The only
+// supported value is "ip", as in:
+//
+//	type Row struct {
+//	    Client string `anonymize:"ip"`
+//	}
+const TagName = "anonymize"
+
+var (
+	// ErrNotPointer is returned by Struct when it is not passed a non-nil
+	// pointer to a struct.
+	ErrNotPointer = errors.New("anonymize.Struct requires a non-nil pointer to a struct")
+
+	// ErrUnsupportedField is returned by Struct when a tagged field has a
+	// type that can not hold an IP address, or can not be modified.
+	ErrUnsupportedField = errors.New("unsupported field type")
+
+	// ErrUnaddressable is returned by Struct when a tagged field is reached
+	// through a non-pointer value stored in an interface. Such values are
+	// copies and can not be modified, so callers should store a pointer in
+	// the interface instead.
+	ErrUnaddressable = errors.New("value stored in an interface can not be modified, store a pointer to it instead")
+
+	// ErrBadAddress is returned by Struct when a tagged string field holds
+	// something that is not an IP address.
+	ErrBadAddress = errors.New("field does not contain an IP address")
+
+	ipType              = reflect.TypeOf(net.IP{})
+	tcpAddrType         = reflect.TypeOf(net.TCPAddr{})
+	udpAddrType         = reflect.TypeOf(net.UDPAddr{})
+	ipAddrType          = reflect.TypeOf(net.IPAddr{})
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Struct walks the struct pointed to by v and anonymizes, in place, every
+// field tagged with `anonymize:"ip"`. Tagged fields may be:
+//   - strings holding an address ("10.1.2.3") or an address and port
+//     ("10.1.2.3:80", "[2001:db8::1]:80"), empty strings are left alone,
+//   - net.IP values,
+//   - net.TCPAddr, net.UDPAddr, or net.IPAddr values,
+//   - any type with MarshalText and UnmarshalText methods whose text form is
+//     one of the strings above (e.g. netip.Addr and netip.AddrPort),
+//   - pointers to, or slices or arrays of, any of the above.
+//
+// Untagged struct fields, and pointers to, slices of, and arrays of structs,
+// are searched recursively for more tagged fields, as are interfaces holding
+// pointers. Values stored directly in an interface are copies that can not be
+// modified, so Struct returns ErrUnaddressable if they hold tagged fields.
+// Maps are not searched. Values reached more than once, e.g. through the
+// back-pointers of a doubly linked list, are only searched and anonymized
+// once.
+//
+// Struct returns an error, and may leave v partially anonymized, if any
+// tagged field can not be anonymized. Callers should not use v after an
+// error, to avoid leaking private data.
+func Struct(anon IPAnonymizer, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrNotPointer
+	}
+	seen := map[visit]bool{}
+	visited(rv, seen)
+	return walkStruct(anon, rv.Elem(), seen)
+}
+
+// visit identifies a value behind a pointer, or the elements of a slice.
+// Pointers to a struct and to its first field have the same address, so the
+// type is part of the key.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// visited returns true if the pointer or slice v was already visited, and
+// records it otherwise.
+func visited(v reflect.Value, seen map[visit]bool) bool {
+	k := visit{ptr: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		k.len = v.Len()
+	}
+	if seen[k] {
+		return true
+	}
+	seen[k] = true
+	return false
+}
+
+// walkStruct searches every field of the struct v.
+func walkStruct(anon IPAnonymizer, v reflect.Value, seen map[visit]bool) error {
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		f := t.Field(i)
+		tag, tagged := f.Tag.Lookup(TagName)
+		if !tagged {
+			if f.PkgPath != "" {
+				// Unexported fields can not be modified, so there is no
+				// point searching them.
+				continue
+			}
+			if err := walk(anon, v.Field(i), seen); err != nil {
+				return fmt.Errorf("%s.%w", f.Name, err)
+			}
+			continue
+		}
+		if tag != "ip" {
+			return fmt.Errorf("%s: unknown %s tag %q: %w", f.Name, TagName, tag, ErrUnsupportedField)
+		}
+		if !v.CanAddr() {
+			return fmt.Errorf("%s: %w", f.Name, ErrUnaddressable)
+		}
+		if !v.Field(i).CanSet() {
+			return fmt.Errorf("%s: unexported: %w", f.Name, ErrUnsupportedField)
+		}
+		if err := anonymizeValue(anon, v.Field(i), seen); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// walk searches untagged values for structs containing tagged fields.
+func walk(anon IPAnonymizer, v reflect.Value, seen map[visit]bool) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		return walkStruct(anon, v, seen)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Ptr && visited(v, seen) {
+			return nil
+		}
+		return walk(anon, v.Elem(), seen)
+	case reflect.Slice, reflect.Array:
+		switch v.Type().Elem().Kind() {
+		case reflect.Struct, reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Array:
+		default:
+			// There can't be any tagged fields in a []byte or similar.
+			return nil
+		}
+		if v.Kind() == reflect.Slice && (v.Len() == 0 || visited(v, seen)) {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := walk(anon, v.Index(i), seen); err != nil {
+				return fmt.Errorf("[%d].%w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// anonymizeValue anonymizes a tagged value.
+func anonymizeValue(anon IPAnonymizer, v reflect.Value, seen map[visit]bool) error {
+	t := v.Type()
+	switch {
+	case t == ipType:
+		if v.Len() == 0 || visited(v, seen) {
+			return nil
+		}
+		anon.IP(v.Interface().(net.IP))
+		return nil
+	case t == tcpAddrType || t == udpAddrType || t == ipAddrType:
+		// The IP may share its array with other tagged values.
+		return anonymizeValue(anon, v.FieldByName("IP"), seen)
+	case t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(textUnmarshalerType) && t.Implements(textMarshalerType):
+		return anonymizeText(anon, v)
+	}
+	switch t.Kind() {
+	case reflect.String:
+		s, err := anonymizeAddrString(anon, v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+		return nil
+	case reflect.Ptr:
+		if v.IsNil() || visited(v, seen) {
+			return nil
+		}
+		return anonymizeValue(anon, v.Elem(), seen)
+	case reflect.Interface:
+		// Only values behind pointers, like a *net.TCPAddr in a net.Addr, can
+		// be modified through an interface.
+		if v.IsNil() {
+			return nil
+		}
+		if v.Elem().Kind() != reflect.Ptr {
+			return fmt.Errorf("%v: %w", v.Elem().Type(), ErrUnaddressable)
+		}
+		return anonymizeValue(anon, v.Elem(), seen)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && (v.Len() == 0 || visited(v, seen)) {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := anonymizeValue(anon, v.Index(i), seen); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%v: %w", t, ErrUnsupportedField)
+}
+
+// anonymizeText anonymizes a value through its text representation.
+func anonymizeText(anon IPAnonymizer, v reflect.Value) error {
+	b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+	if err != nil {
+		return err
+	}
+	if len(b) == 0 {
+		// The zero value of netip.Addr, for example, has no text form.
+		return nil
+	}
+	s, err := anonymizeAddrString(anon, string(b))
+	if err != nil {
+		return err
+	}
+	return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+}
+
+// anonymizeAddrString anonymizes a string containing either an address or an
+// address and a port. IPv6 zones are preserved.
+func anonymizeAddrString(anon IPAnonymizer, s string) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+	if a, ok := anonymizeHost(anon, s); ok {
+		return a, nil
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", fmt.Errorf("%q: %w", s, ErrBadAddress)
+	}
+	a, ok := anonymizeHost(anon, host)
+	if !ok {
+		return "", fmt.Errorf("%q: %w", s, ErrBadAddress)
+	}
+	return net.JoinHostPort(a, port), nil
+}
+
+// anonymizeHost anonymizes an address with an optional zone. It returns false
+// if the string is not an address.
+func anonymizeHost(anon IPAnonymizer, s string) (string, bool) {
+	zone := ""
+	if i := strings.LastIndexByte(s, '%'); i >= 0 {
+		s, zone = s[:i], s[i:]
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", false
+	}
+	anon.IP(ip)
+	return ip.String() + zone, true
+}
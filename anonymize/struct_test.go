@@ -0,0 +1,229 @@
+package anonymize_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"github.com/m-lab/go/anonymize"
+)
+
+// textAddr stands in for types like netip.Addr, which are only accessible
+// through their text representation.
+type textAddr struct {
+	s string
+}
+
+func (t textAddr) MarshalText() ([]byte, error) {
+	return []byte(t.s), nil
+}
+
+func (t *textAddr) UnmarshalText(b []byte) error {
+	t.s = string(b)
+	return nil
+}
+
+type inner struct {
+	Server string `anonymize:"ip"`
+	Note   string
+}
+
+type row struct {
+	Client     string       `anonymize:"ip"`
+	ClientPtr  *string      `anonymize:"ip"`
+	HostPort   string       `anonymize:"ip"`
+	Zoned      string       `anonymize:"ip"`
+	Empty      string       `anonymize:"ip"`
+	IP         net.IP       `anonymize:"ip"`
+	IPs        []net.IP     `anonymize:"ip"`
+	Strings    [2]string    `anonymize:"ip"`
+	TCP        net.TCPAddr  `anonymize:"ip"`
+	UDP        *net.UDPAddr `anonymize:"ip"`
+	Addr       net.Addr     `anonymize:"ip"`
+	NilAddr    net.Addr     `anonymize:"ip"`
+	Text       textAddr     `anonymize:"ip"`
+	Untouched  string
+	Raw        []byte
+	Inner      inner
+	InnerPtr   *inner
+	Inners     []inner
+	NilInner   *inner
+	unexported inner
+}
+
+func TestStruct(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	anon := anonymize.New(anonymize.Netblock)
+	clientPtr := "10.0.0.1"
+	r := row{
+		Client:     "10.1.2.3",
+		ClientPtr:  &clientPtr,
+		HostPort:   "[2001:db8::1:2]:443",
+		Zoned:      "fe80::1:2:3:4%eth0",
+		IP:         net.ParseIP("10.2.3.4"),
+		IPs:        []net.IP{net.ParseIP("10.3.4.5"), net.ParseIP("2001:db8:1:2:3:4:5:6")},
+		Strings:    [2]string{"10.4.5.6:80", ""},
+		TCP:        net.TCPAddr{IP: net.ParseIP("10.5.6.7"), Port: 80},
+		UDP:        &net.UDPAddr{IP: net.ParseIP("10.6.7.8"), Port: 53},
+		Addr:       &net.TCPAddr{IP: net.ParseIP("10.7.8.9"), Port: 22},
+		Text:       textAddr{"10.8.9.10"},
+		Untouched:  "10.9.10.11",
+		Raw:        []byte{10, 10, 11, 12},
+		Inner:      inner{Server: "10.10.11.12", Note: "10.10.11.12"},
+		InnerPtr:   &inner{Server: "10.11.12.13"},
+		Inners:     []inner{{Server: "10.12.13.14"}, {Server: "10.13.14.15"}},
+		unexported: inner{Server: "10.14.15.16"},
+	}
+	if err := anonymize.Struct(anon, &r); err != nil {
+		t.Fatal(err)
+	}
+	clientPtrWant := "10.0.0.0"
+	want := row{
+		Client:     "10.1.2.0",
+		ClientPtr:  &clientPtrWant,
+		HostPort:   "[2001:db8::]:443",
+		Zoned:      "fe80::%eth0",
+		IP:         net.ParseIP("10.2.3.0"),
+		IPs:        []net.IP{net.ParseIP("10.3.4.0"), net.ParseIP("2001:db8:1:2::")},
+		Strings:    [2]string{"10.4.5.0:80", ""},
+		TCP:        net.TCPAddr{IP: net.ParseIP("10.5.6.0"), Port: 80},
+		UDP:        &net.UDPAddr{IP: net.ParseIP("10.6.7.0"), Port: 53},
+		Addr:       &net.TCPAddr{IP: net.ParseIP("10.7.8.0"), Port: 22},
+		Text:       textAddr{"10.8.9.0"},
+		Untouched:  "10.9.10.11",
+		Raw:        []byte{10, 10, 11, 12},
+		Inner:      inner{Server: "10.10.11.0", Note: "10.10.11.12"},
+		InnerPtr:   &inner{Server: "10.11.12.0"},
+		Inners:     []inner{{Server: "10.12.13.0"}, {Server: "10.13.14.0"}},
+		unexported: inner{Server: "10.14.15.16"},
+	}
+	deep.CompareUnexportedFields = true
+	defer func() { deep.CompareUnexportedFields = false }()
+	if diff := deep.Equal(r, want); diff != nil {
+		t.Error(diff)
+	}
+}
+
+type node struct {
+	Addr       string `anonymize:"ip"`
+	Prev, Next *node
+	Shared     *string `anonymize:"ip"`
+	Peers      []node
+}
+
+func TestStructCycles(t *testing.T) {
+	oldNets := anonymize.IgnoredNets
+	defer func() { anonymize.IgnoredNets = oldNets }()
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	anon := anonymize.New(anonymize.Netblock)
+	shared := "10.0.0.1"
+	first := &node{Addr: "10.1.1.1", Shared: &shared}
+	second := &node{Addr: "10.2.2.2", Prev: first, Shared: &shared}
+	first.Next = second
+	second.Next = first
+	// A slice that contains itself.
+	first.Peers = make([]node, 1)
+	first.Peers[0] = node{Addr: "10.3.3.3", Peers: first.Peers}
+
+	if err := anonymize.Struct(anon, first); err != nil {
+		t.Fatalf("Struct() = %v", err)
+	}
+	for _, got := range []string{first.Addr, second.Addr, first.Peers[0].Addr} {
+		if got != "10.1.1.0" && got != "10.2.2.0" && got != "10.3.3.0" {
+			t.Errorf("Struct() did not anonymize %q", got)
+		}
+	}
+	if shared != "10.0.0.0" {
+		t.Errorf("Struct() = %q, want 10.0.0.0", shared)
+	}
+
+	// A value reached twice is only anonymized once.
+	calls := 0
+	counter := anonymizerFunc(func(ip net.IP) { calls++ })
+	if err := anonymize.Struct(counter, first); err != nil {
+		t.Fatalf("Struct() = %v", err)
+	}
+	if calls != 4 {
+		t.Errorf("Struct() anonymized %d addresses, want 4", calls)
+	}
+}
+
+type anonymizerFunc func(ip net.IP)
+
+func (f anonymizerFunc) IP(ip net.IP) { f(ip) }
+
+func TestStructSharedIP(t *testing.T) {
+	ip := net.ParseIP("10.1.2.3")
+	addr := &net.TCPAddr{IP: ip, Port: 80}
+	v := struct {
+		IP    net.IP      `anonymize:"ip"`
+		TCP   net.TCPAddr `anonymize:"ip"`
+		Addrs []net.Addr  `anonymize:"ip"`
+	}{
+		IP:    ip,
+		TCP:   net.TCPAddr{IP: ip, Port: 443},
+		Addrs: []net.Addr{addr, addr},
+	}
+	calls := 0
+	counter := anonymizerFunc(func(ip net.IP) { calls++ })
+	if err := anonymize.Struct(counter, &v); err != nil {
+		t.Fatalf("Struct() = %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Struct() anonymized the shared address %d times, want 1", calls)
+	}
+}
+
+func TestStructErrors(t *testing.T) {
+	anon := anonymize.New(anonymize.Netblock)
+	type badString struct {
+		S string `anonymize:"ip"`
+	}
+	type badNested struct {
+		Rows []badString
+	}
+	type badTag struct {
+		S string `anonymize:"mac"`
+	}
+	type badType struct {
+		I int `anonymize:"ip"`
+	}
+	type badUnexported struct {
+		s string `anonymize:"ip"`
+	}
+	type badInterface struct {
+		A interface{} `anonymize:"ip"`
+	}
+	type badInterfaceStruct struct {
+		V interface{}
+	}
+	tests := []struct {
+		name string
+		v    interface{}
+		want error
+	}{
+		{"not-a-pointer", badString{}, anonymize.ErrNotPointer},
+		{"nil", (*badString)(nil), anonymize.ErrNotPointer},
+		{"not-a-struct", new(string), anonymize.ErrNotPointer},
+		{"bad-string", &badString{S: "example.com"}, anonymize.ErrBadAddress},
+		{"bad-host-port", &badString{S: "example.com:80"}, anonymize.ErrBadAddress},
+		{"bad-nested", &badNested{Rows: []badString{{}, {S: "x"}}}, anonymize.ErrBadAddress},
+		{"bad-tag", &badTag{}, anonymize.ErrUnsupportedField},
+		{"bad-type", &badType{}, anonymize.ErrUnsupportedField},
+		{"bad-unexported", &badUnexported{}, anonymize.ErrUnsupportedField},
+		{"bad-interface", &badInterface{A: "10.1.2.3"}, anonymize.ErrUnaddressable},
+		{"bad-interface-struct", &badInterfaceStruct{V: badString{S: "10.1.2.3"}}, anonymize.ErrUnaddressable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := anonymize.Struct(anon, tt.v)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Struct() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
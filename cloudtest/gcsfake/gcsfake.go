@@ -19,8 +19,11 @@ package gcsfake
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
 	"errors"
+	"hash/crc32"
 	"strings"
+	"sync"
 
 	"cloud.google.com/go/storage"
 	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
@@ -58,6 +61,11 @@ type BucketHandle struct {
 	Objs           map[string]*ObjectHandle
 	WritesMustFail bool
 	ClosesMustFail bool
+	// WritesReplace makes every new Writer of an object replace its Data,
+	// like a real GCS Writer, instead of appending to it.
+	WritesReplace bool
+
+	mu sync.Mutex // Protects Objs, and the Data of objects that are written.
 }
 
 // NewBucketHandle creates a new empty BucketHandle.
@@ -76,6 +84,8 @@ func (bh *BucketHandle) Attrs(ctx context.Context) (*storage.BucketAttrs, error)
 // Object returns an ObjectHandle for the specified object name if it exists
 // in this bucket, or a new ObjectHandle otherwise.
 func (bh *BucketHandle) Object(name string) stiface.ObjectHandle {
+	bh.mu.Lock()
+	defer bh.mu.Unlock()
 	if o, ok := bh.Objs[name]; ok {
 		return o
 	}
@@ -85,6 +95,7 @@ func (bh *BucketHandle) Object(name string) stiface.ObjectHandle {
 		Data:           new(bytes.Buffer),
 		WritesMustFail: bh.WritesMustFail,
 		ClosesMustFail: bh.ClosesMustFail,
+		WritesReplace:  bh.WritesReplace,
 	}
 }
 
@@ -141,6 +152,28 @@ type ObjectHandle struct {
 	Data           *bytes.Buffer
 	WritesMustFail bool
 	ClosesMustFail bool
+	WritesReplace  bool
+
+	generation int64 // Counts the Writers that wrote the Data.
+}
+
+// Attrs implements stiface.ObjectHandle.Attrs. The Size, MD5 and CRC32C
+// describe the unread Data of the object, and the Generation counts the
+// Writers that wrote it. Objects that were not written to their bucket do not
+// exist.
+func (o *ObjectHandle) Attrs(context.Context) (*storage.ObjectAttrs, error) {
+	defer o.lock()()
+	if o.Bucket != nil && o.Bucket.Objs[o.Name] != o {
+		return nil, storage.ErrObjectNotExist
+	}
+	sum := md5.Sum(o.Data.Bytes())
+	return &storage.ObjectAttrs{
+		Name:       o.Name,
+		Size:       int64(o.Data.Len()),
+		MD5:        sum[:],
+		CRC32C:     crc32.Checksum(o.Data.Bytes(), crc32.MakeTable(crc32.Castagnoli)),
+		Generation: o.generation,
+	}, nil
 }
 
 // NewReader returns a fakeReader for this ObjectHandle.
@@ -150,6 +183,34 @@ func (o *ObjectHandle) NewReader(context.Context) (stiface.Reader, error) {
 	}, nil
 }
 
+// NewRangeReader returns a fakeReader of length bytes of this ObjectHandle,
+// starting at offset. A negative length reads to the end. Unlike NewReader,
+// the Data of the object is not consumed.
+func (o *ObjectHandle) NewRangeReader(ctx context.Context, offset, length int64) (stiface.Reader, error) {
+	defer o.lock()()
+	b := o.Data.Bytes()
+	if offset < 0 || offset > int64(len(b)) {
+		return nil, errors.New("invalid range")
+	}
+	b = b[offset:]
+	if length >= 0 && length < int64(len(b)) {
+		b = b[:length]
+	}
+	return &fakeReader{
+		buf: bytes.NewBuffer(append([]byte(nil), b...)),
+	}, nil
+}
+
+// lock locks the bucket of the object, if any, so the Data of the object is
+// not written concurrently. It returns the function that unlocks it.
+func (o *ObjectHandle) lock() func() {
+	if o.Bucket == nil {
+		return func() {}
+	}
+	o.Bucket.mu.Lock()
+	return o.Bucket.mu.Unlock
+}
+
 // NewWriter returns a fakeWrite for this ObjectHandle.
 func (o *ObjectHandle) NewWriter(context.Context) stiface.Writer {
 	return &fakeWriter{
@@ -157,6 +218,7 @@ func (o *ObjectHandle) NewWriter(context.Context) stiface.Writer {
 		buf:           o.Data,
 		mustFail:      o.WritesMustFail,
 		closeMustFail: o.ClosesMustFail,
+		replace:       o.WritesReplace,
 	}
 }
 
@@ -166,15 +228,28 @@ type fakeWriter struct {
 	buf           *bytes.Buffer
 	mustFail      bool
 	closeMustFail bool
+	replace       bool
+	started       bool
 }
 
 // Write writes data to the fake bucket. The object is created if it does not
-// exist already.
+// exist already. The first Write of every Writer starts a new generation of
+// the object. Data is appended to the existing data of the object, unless the
+// Writer was created with WritesReplace, in which case the first Write
+// replaces it.
 func (w *fakeWriter) Write(p []byte) (int, error) {
 	if w.mustFail {
 		return 0, errors.New("write failed")
 	}
-	w.object.Bucket.Objs[w.object.Name] = w.object
+	defer w.object.lock()()
+	if !w.started {
+		w.started = true
+		w.object.Bucket.Objs[w.object.Name] = w.object
+		w.object.generation++
+		if w.replace {
+			w.buf.Reset()
+		}
+	}
 	return w.buf.Write(p)
 }
 func (w *fakeWriter) Close() error {
@@ -192,3 +267,8 @@ type fakeReader struct {
 func (r *fakeReader) Read(p []byte) (int, error) {
 	return r.buf.Read(p)
 }
+
+// Close implements stiface.Reader.Close
+func (r *fakeReader) Close() error {
+	return nil
+}
@@ -3,8 +3,10 @@ package gcsfake
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"log"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -147,6 +149,23 @@ func TestObjectHandle_NewReader(t *testing.T) {
 	}
 }
 
+func TestObjectHandle_Attrs(t *testing.T) {
+	bh := NewBucketHandle()
+	obj := bh.Object("test/obj")
+	if _, err := obj.Attrs(context.Background()); err != storage.ErrObjectNotExist {
+		t.Errorf("Attrs() error = %v, want ErrObjectNotExist", err)
+	}
+	w := obj.NewWriter(context.Background())
+	_, err := w.Write([]byte("test"))
+	testingx.Must(t, err, "Write() failed")
+	attrs, err := obj.Attrs(context.Background())
+	testingx.Must(t, err, "Attrs() failed")
+	// The MD5 of "test".
+	if attrs.Size != 4 || fmt.Sprintf("%x", attrs.MD5) != "098f6bcd4621d373cade4e832627b4f6" {
+		t.Errorf("Attrs() = %+v, want the size and MD5 of test", attrs)
+	}
+}
+
 func TestObjectHandle_NewWriter(t *testing.T) {
 	buf := new(bytes.Buffer)
 	obj := &ObjectHandle{
@@ -190,6 +209,83 @@ func Test_fakeWriter_Write(t *testing.T) {
 	}
 }
 
+func Test_fakeWriter_Generations(t *testing.T) {
+	tests := []struct {
+		name          string
+		writesReplace bool
+		want          string
+	}{
+		{
+			name: "append",
+			want: "first-moresecond",
+		},
+		{
+			name:          "replace",
+			writesReplace: true,
+			want:          "second",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bh := NewBucketHandle()
+			bh.WritesReplace = tt.writesReplace
+			obj := bh.Object("obj")
+			write := func(data ...string) {
+				w := obj.NewWriter(context.Background())
+				for _, d := range data {
+					_, err := w.Write([]byte(d))
+					testingx.Must(t, err, "Write() failed")
+				}
+				testingx.Must(t, w.Close(), "Close() failed")
+			}
+
+			// The Writes of one Writer are appended.
+			write("first", "-more")
+			attrs, err := obj.Attrs(context.Background())
+			testingx.Must(t, err, "Attrs() failed")
+			if bh.Objs["obj"].Data.String() != "first-more" || attrs.Generation != 1 {
+				t.Errorf("Write() = %q in generation %d, want first-more in 1", bh.Objs["obj"].Data.String(), attrs.Generation)
+			}
+
+			// A new Writer starts a new generation.
+			write("second")
+			attrs, err = obj.Attrs(context.Background())
+			testingx.Must(t, err, "Attrs() failed")
+			if bh.Objs["obj"].Data.String() != tt.want || attrs.Generation != 2 {
+				t.Errorf("Write() = %q in generation %d, want %s in 2", bh.Objs["obj"].Data.String(), attrs.Generation, tt.want)
+			}
+		})
+	}
+}
+
+func Test_fakeWriter_Concurrent(t *testing.T) {
+	// Run with -race to check that Writers and readers of attributes share a
+	// lock.
+	bh := NewBucketHandle()
+	bh.WritesReplace = true
+	obj := bh.Object("obj")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				w := obj.NewWriter(context.Background())
+				w.Write([]byte("abc"))
+				w.Write([]byte("def"))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				obj.Attrs(context.Background())
+				obj.NewRangeReader(context.Background(), 0, -1)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func Test_fakeWriter_Close(t *testing.T) {
 	w := &fakeWriter{}
 	if err := w.Close(); err != nil {
@@ -0,0 +1,59 @@
+// scrub anonymizes the IP addresses in packet captures and text logs, using
+// the same --anonymize.* flags as the services that produced them.
+//
+// Example:
+//
+//	scrub --mode=pcap --anonymize.ip=netblock < capture.pcap > scrubbed.pcap
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/m-lab/go/anonymize"
+	"github.com/m-lab/go/anonymize/scrub"
+	"github.com/m-lab/go/flagx"
+	"github.com/m-lab/go/rtx"
+)
+
+var (
+	mode   = flagx.Enum{Options: []string{"pcap", "text"}, Value: "text"}
+	input  = flag.String("input", "-", "The file to read, or - for stdin.")
+	output = flag.String("output", "-", "The file to write, or - for stdout.")
+)
+
+func init() {
+	flag.Var(&mode, "mode", "The kind of file to scrub: \"pcap\" (pcap or pcapng) or \"text\".")
+}
+
+func main() {
+	flag.Parse()
+	rtx.Must(flagx.ArgsFromEnv(flag.CommandLine), "Could not get args from env")
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		rtx.Must(err, "Could not open %q", *input)
+		defer f.Close()
+		r = f
+	}
+	var w io.WriteCloser = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		rtx.Must(err, "Could not create %q", *output)
+		w = f
+	}
+
+	anon := anonymize.New(anonymize.IPAnonymizationFlag)
+	switch mode.Value {
+	case "pcap":
+		rtx.Must(scrub.Pcap(anon, r, w), "Could not scrub pcap")
+	case "text":
+		rtx.Must(scrub.Text(anon, r, w), "Could not scrub text")
+	default:
+		rtx.Must(fmt.Errorf("unknown mode %q", mode.Value), "Bad --mode")
+	}
+	rtx.Must(w.Close(), "Could not close %q", *output)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/m-lab/go/anonymize"
+	"github.com/m-lab/go/rtx"
+)
+
+func TestMainScrubsText(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestMainScrubsText")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+	rtx.Must(ioutil.WriteFile(dir+"/in.log", []byte("connection from 10.1.2.3:443\n"), 0644), "Could not write input")
+
+	anonymize.IgnoredNets = anonymize.NewIPSet()
+	for flagName, value := range map[string]string{
+		"mode":         "text",
+		"input":        dir + "/in.log",
+		"output":       dir + "/out.log",
+		"anonymize.ip": "netblock",
+	} {
+		rtx.Must(flag.Set(flagName, value), "Could not set flag %q", flagName)
+	}
+	main()
+
+	b, err := ioutil.ReadFile(dir + "/out.log")
+	rtx.Must(err, "Could not read output")
+	if string(b) != "connection from 10.1.2.0:443\n" {
+		t.Errorf("main() wrote %q", b)
+	}
+}
@@ -0,0 +1,223 @@
+package content
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CacheConfig describes where Cache keeps its copy of the data.
+type CacheConfig struct {
+	// Dir is the directory of the cache files. It is created if needed.
+	Dir string
+	// Key names the cached copy in Dir, e.g. the URL of the Provider. Every
+	// Provider that shares a Dir needs a different Key.
+	Key string
+	// MaxStaleness is the maximum age of a cached copy that is served when the
+	// Provider fails. If it is zero, any cached copy is served.
+	MaxStaleness time.Duration
+}
+
+// cacheMeta is saved next to the cached copy of the data.
+type cacheMeta struct {
+	Key          string
+	Fetched      time.Time
+	SHA256       string
+	Size         int64
+	MD5          []byte `json:",omitempty"`
+	ETag         string `json:",omitempty"`
+	LastModified time.Time
+}
+
+// cachedProvider keeps the last copy of the data of a Provider on disk.
+type cachedProvider struct {
+	p      Provider
+	config CacheConfig
+	served bool
+}
+
+// Cache returns a Provider that saves every new copy of the data of p in
+// c.Dir, along with its MD5, ETag and the time it was fetched. If p fails
+// before any copy was returned, e.g. because the origin is unreachable when a
+// service starts, the saved copy is returned instead, as long as it is no
+// older than c.MaxStaleness. Later failures are returned as errors, since the
+// caller already has a copy of the data.
+//
+// Files are written to a temporary name and then renamed, and the saved
+// SHA-256 of the data is checked before the copy is returned, so a crash
+// never leads to a partial copy being served.
+func Cache(p Provider, c CacheConfig) Provider {
+	return &cachedProvider{p: p, config: c}
+}
+
+func (c *cachedProvider) Get(ctx context.Context) ([]byte, error) {
+	return readAll(ctx, c)
+}
+
+func (c *cachedProvider) Open(ctx context.Context) (io.ReadCloser, Meta, error) {
+	r, meta, err := Open(ctx, c.p)
+	if err == nil {
+		return c.save(r, meta), meta, nil
+	}
+	if errors.Is(err, ErrNoChange) || c.served {
+		return nil, Meta{}, err
+	}
+	cached, meta, cacheErr := c.load()
+	if cacheErr != nil {
+		return nil, Meta{}, fmt.Errorf("%w (and no cached copy: %v)", err, cacheErr)
+	}
+	log.Printf("Serving the cached copy of %q from %v, because: %v", c.config.Key, meta.LastModified, err)
+	return cached, meta, nil
+}
+
+// path returns the name of a cache file with the given extension.
+func (c *cachedProvider) path(ext string) string {
+	sum := sha256.Sum256([]byte(c.config.Key))
+	return filepath.Join(c.config.Dir, hex.EncodeToString(sum[:16])+ext)
+}
+
+// save returns a reader of r that also writes the data to a temporary file.
+// Once r is read to the end, the file replaces the cached copy.
+func (c *cachedProvider) save(r io.ReadCloser, meta Meta) io.ReadCloser {
+	r, commit := detachCommit(r)
+	cw := &cacheWriter{ReadCloser: r, hash: sha256.New()}
+	cw.err = os.MkdirAll(c.config.Dir, 0755)
+	if cw.err == nil {
+		cw.tmp, cw.err = ioutil.TempFile(c.config.Dir, ".cache.tmp*")
+	}
+	save := func() {
+		if err := cw.commit(c.path(".data")); err != nil {
+			log.Printf("Could not save the data of %q to the cache: %v", c.config.Key, err)
+		} else {
+			m := cacheMeta{
+				Key:          c.config.Key,
+				Fetched:      time.Now(),
+				SHA256:       hex.EncodeToString(cw.hash.Sum(nil)),
+				Size:         cw.size,
+				MD5:          meta.MD5,
+				ETag:         meta.ETag,
+				LastModified: meta.LastModified,
+			}
+			if err := writeCacheMeta(c.path(".json"), m); err != nil {
+				log.Printf("Could not save the metadata of %q to the cache: %v", c.config.Key, err)
+			}
+		}
+		commit()
+		c.served = true
+	}
+	return &commitReader{ReadCloser: cw, commit: save}
+}
+
+// load returns a reader of the cached copy, which fails unless the data has
+// the saved SHA-256 digest. The returned Meta describes the cached copy, but
+// its LastModified is the time it was fetched.
+func (c *cachedProvider) load() (io.ReadCloser, Meta, error) {
+	b, err := ioutil.ReadFile(c.path(".json"))
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	var m cacheMeta
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, Meta{}, err
+	}
+	sum, err := hex.DecodeString(m.SHA256)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	if m.Key != c.config.Key {
+		return nil, Meta{}, fmt.Errorf("Cached copy is of %q", m.Key)
+	}
+	if age := time.Since(m.Fetched); c.config.MaxStaleness > 0 && age > c.config.MaxStaleness {
+		return nil, Meta{}, fmt.Errorf("Cached copy is too old (%v)", age)
+	}
+	f, err := os.Open(c.path(".data"))
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	meta := Meta{
+		Size:         m.Size,
+		MD5:          m.MD5,
+		ETag:         m.ETag,
+		LastModified: m.Fetched,
+	}
+	vr := &verifyReader{ReadCloser: f, hash: sha256.New(), want: sum}
+	return &commitReader{ReadCloser: vr, commit: func() { c.served = true }}, meta, nil
+}
+
+// cacheWriter copies the data it reads to a temporary file. Errors writing the
+// file are kept in err, so that they do not interrupt the reader.
+type cacheWriter struct {
+	io.ReadCloser
+	tmp  *os.File
+	hash hash.Hash
+	size int64
+	err  error
+}
+
+func (w *cacheWriter) Read(p []byte) (int, error) {
+	n, err := w.ReadCloser.Read(p)
+	w.hash.Write(p[:n])
+	w.size += int64(n)
+	if w.err == nil {
+		_, w.err = w.tmp.Write(p[:n])
+	}
+	return n, err
+}
+
+// commit renames the temporary file to path.
+func (w *cacheWriter) commit(path string) error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.tmp.Sync(); err != nil {
+		return err
+	}
+	if err := w.tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(w.tmp.Name(), path)
+}
+
+func (w *cacheWriter) Close() error {
+	if w.tmp != nil {
+		// After a successful commit, these fail harmlessly.
+		w.tmp.Close()
+		os.Remove(w.tmp.Name())
+	}
+	return w.ReadCloser.Close()
+}
+
+// writeCacheMeta writes m to a temporary file, which is then renamed to path.
+func writeCacheMeta(path string, m cacheMeta) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".cache.tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
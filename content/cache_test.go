@@ -0,0 +1,129 @@
+package content
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/cloudtest/gcsfake"
+	"github.com/m-lab/go/rtx"
+)
+
+func TestCacheGCS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestCacheGCS")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+
+	bucket := gcsfake.NewBucketHandle()
+	client := &gcsfake.GCSClient{}
+	client.AddTestBucket("bucket", bucket)
+	w := bucket.Object("db.mmdb").NewWriter(context.Background())
+	_, err = w.Write([]byte("database"))
+	rtx.Must(err, "Could not write object")
+	c := CacheConfig{Dir: dir, Key: "gs://bucket/db.mmdb", MaxStaleness: time.Hour}
+
+	p := Cache(&gcsProvider{client: client, bucket: "bucket", filename: "db.mmdb"}, c)
+	b, err := p.Get(context.Background())
+	if string(b) != "database" || err != nil {
+		t.Fatalf("Get() = %q, %v, want database", b, err)
+	}
+
+	// Restart while the object is unavailable.
+	delete(bucket.Objs, "db.mmdb")
+	p = Cache(&gcsProvider{client: client, bucket: "bucket", filename: "db.mmdb"}, c)
+	r, meta, err := Open(context.Background(), p)
+	rtx.Must(err, "Could not Open the cached copy")
+	b, err = ioutil.ReadAll(r)
+	r.Close()
+	if string(b) != "database" || err != nil || meta.Size != 8 || len(meta.MD5) != 16 {
+		t.Errorf("Open() = %q, %+v, %v, want the cached database", b, meta, err)
+	}
+	// The caller already has the cached copy, so later failures are errors.
+	if _, err := p.Get(context.Background()); err == nil || err == ErrNoChange {
+		t.Errorf("Get() error = %v, want the error of the origin", err)
+	}
+
+	// The cached copy is too old.
+	c.MaxStaleness = time.Nanosecond
+	p = Cache(&gcsProvider{client: client, bucket: "bucket", filename: "db.mmdb"}, c)
+	if _, err := p.Get(context.Background()); err == nil {
+		t.Error("Get() should have failed with a stale cache")
+	}
+
+	// Another key has no cached copy.
+	c.Key = "gs://bucket/other.mmdb"
+	c.MaxStaleness = 0
+	p = Cache(&gcsProvider{client: client, bucket: "bucket", filename: "db.mmdb"}, c)
+	if _, err := p.Get(context.Background()); err == nil {
+		t.Error("Get() should have failed without a cached copy")
+	}
+}
+
+func TestCacheHTTPS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestCacheHTTPS")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+
+	data := "version one"
+	srv := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-None-Match") == data {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", data)
+			io.WriteString(w, data)
+		}),
+	)
+	u, err := url.Parse(srv.URL)
+	rtx.Must(err, "failed to parse url from test server")
+	c := CacheConfig{Dir: dir, Key: srv.URL}
+	origin := func() Provider {
+		return &httpsProvider{u: *u, timeout: time.Second, client: srv.Client()}
+	}
+
+	p := Cache(origin(), c)
+	for _, want := range []string{"version one", "version two"} {
+		data = want
+		b, err := p.Get(context.Background())
+		if string(b) != want || err != nil {
+			t.Errorf("Get() = %q, %v, want %q", b, err, want)
+		}
+		if _, err := p.Get(context.Background()); err != ErrNoChange {
+			t.Errorf("Get() error = %v, want ErrNoChange", err)
+		}
+	}
+
+	// A partial read does not replace the cached copy.
+	data = "version three"
+	r, _, err := Open(context.Background(), Cache(origin(), c))
+	rtx.Must(err, "Could not Open")
+	r.Read(make([]byte, 4))
+	r.Close()
+
+	srv.Close()
+	b, err := Cache(origin(), c).Get(context.Background())
+	if string(b) != "version two" || err != nil {
+		t.Errorf("Get() = %q, %v, want the cached version two", b, err)
+	}
+
+	// A corrupt cached copy is not served.
+	files, err := ioutil.ReadDir(dir)
+	rtx.Must(err, "Could not read the cache dir")
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".data") {
+			rtx.Must(ioutil.WriteFile(filepath.Join(dir, f.Name()), []byte("version tw0"), 0644), "Could not corrupt the cache")
+		}
+	}
+	if b, err := Cache(origin(), c).Get(context.Background()); err == nil {
+		t.Errorf("Get() = %q, want an error for a corrupt cache", b)
+	}
+}
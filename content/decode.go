@@ -0,0 +1,206 @@
+package content
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/m-lab/go/content/internal/zstd"
+)
+
+// Decoder returns a reader of the decoded data read from r.
+type Decoder func(r io.Reader) (io.ReadCloser, error)
+
+var (
+	decodersMu sync.RWMutex
+	// decoders maps file extensions to Decoders.
+	decoders = map[string]Decoder{
+		".gz": func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+		".zst": func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(zstd.NewReader(r)), nil
+		},
+	}
+	// encodings maps Content-Encodings to file extensions.
+	encodings = map[string]string{
+		"gzip":   ".gz",
+		"x-gzip": ".gz",
+		"zstd":   ".zst",
+	}
+)
+
+// RegisterDecoder makes Decode use d for file names ending in ext and, if
+// encoding is not empty, for data with that Content-Encoding. gzip (".gz")
+// and zstd (".zst") are built in.
+//
+// RegisterDecoder panics if d is nil or ext is already registered.
+func RegisterDecoder(ext, encoding string, d Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	if d == nil {
+		panic("content: RegisterDecoder of a nil Decoder for " + ext)
+	}
+	if _, dup := decoders[ext]; dup {
+		panic("content: RegisterDecoder called twice for " + ext)
+	}
+	decoders[ext] = d
+	if encoding != "" {
+		encodings[strings.ToLower(encoding)] = ext
+	}
+}
+
+// layers returns the Decoders for the Content-Encoding and the extensions of
+// name, outermost first, and whether the decoded data is a tar archive.
+//
+// Servers commonly send the Content-Encoding of a compressed file that they
+// serve as is, e.g. gzip for "db.gz", so a Content-Encoding that matches the
+// outermost extension is decoded only once.
+func layers(name, encoding string) ([]Decoder, bool) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	var ds []Decoder
+	encoded := encodings[strings.ToLower(encoding)]
+	if d, ok := decoders[encoded]; ok {
+		ds = append(ds, d)
+	}
+	for first := true; ; first = false {
+		ext := path.Ext(name)
+		if ext == "" {
+			return ds, false
+		}
+		if ext == ".tgz" {
+			ext, name = ".gz", strings.TrimSuffix(name, ".tgz")+".tar"
+		} else {
+			name = strings.TrimSuffix(name, ext)
+		}
+		d, ok := decoders[ext]
+		if !ok {
+			return ds, ext == ".tar"
+		}
+		if first && ext == encoded {
+			continue
+		}
+		ds = append(ds, d)
+	}
+}
+
+// decodedProvider decodes the data of a Provider.
+type decodedProvider struct {
+	p            Provider
+	name, member string
+}
+
+// Decode returns a Provider of the decoded data of p. The Decoders are chosen
+// by the Content-Encoding of the data, if known, and then by the extensions of
+// name, e.g. "db.mmdb.gz" is decoded with gzip and "db.mmdb.zst" with zstd. A
+// Content-Encoding that matches the outermost extension is applied only once.
+// If the decoded data is a tar archive, e.g. "db.tar.gz" or "db.tgz", only the
+// file member is returned. A member matches a file in the archive with the
+// same name or the same trailing path components, and the empty member matches
+// the first regular file.
+//
+// Truncated or corrupt data fails to decode, so Get returns an error instead
+// of partial data. Combine Decode with Verify to also check the digest of the
+// data before it is decoded.
+func Decode(p Provider, name, member string) Provider {
+	return &decodedProvider{p: p, name: name, member: member}
+}
+
+func (d *decodedProvider) Get(ctx context.Context) ([]byte, error) {
+	return readAll(ctx, d)
+}
+
+func (d *decodedProvider) Open(ctx context.Context) (io.ReadCloser, Meta, error) {
+	r, meta, err := Open(ctx, d.p)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	ds, isTar := layers(d.name, meta.ContentEncoding)
+	if len(ds) == 0 && !isTar {
+		return r, meta, nil
+	}
+	raw, commit := detachCommit(r)
+	dr := &decodeReader{raw: raw}
+	var decoded io.Reader = raw
+	for _, dec := range ds {
+		rc, err := dec(decoded)
+		if err != nil {
+			dr.Close()
+			return nil, Meta{}, fmt.Errorf("Could not decode %q: %w", d.name, err)
+		}
+		dr.closers = append(dr.closers, rc)
+		decoded = rc
+	}
+	dr.Reader = decoded
+	if isTar {
+		dr.rest = decoded
+		dr.Reader, err = tarMember(decoded, d.member)
+		if err != nil {
+			dr.Close()
+			return nil, Meta{}, fmt.Errorf("Could not find %q in %q: %w", d.member, d.name, err)
+		}
+	}
+	meta.Size = -1
+	meta.ContentEncoding = ""
+	return &commitReader{ReadCloser: dr, commit: commit}, meta, nil
+}
+
+// tarMember returns a reader of the first regular file in the archive that
+// matches member.
+func tarMember(r io.Reader, member string) (io.Reader, error) {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("No such file in the archive")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if member == "" || h.Name == member || strings.HasSuffix(h.Name, "/"+member) {
+			return tr, nil
+		}
+	}
+}
+
+// decodeReader reads decoded data. At the end of the data, it reads the rest
+// of the tar archive, if any, and of the raw data, so that every Decoder and
+// a verifyReader underneath get to check their input to the end.
+type decodeReader struct {
+	io.Reader
+	rest    io.Reader
+	raw     io.ReadCloser
+	closers []io.Closer
+}
+
+func (d *decodeReader) Read(p []byte) (int, error) {
+	n, err := d.Reader.Read(p)
+	if err == io.EOF {
+		for _, r := range []io.Reader{d.rest, d.raw} {
+			if r == nil {
+				continue
+			}
+			if _, err := io.Copy(ioutil.Discard, r); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, err
+}
+
+func (d *decodeReader) Close() error {
+	for i := len(d.closers) - 1; i >= 0; i-- {
+		d.closers[i].Close()
+	}
+	return d.raw.Close()
+}
@@ -0,0 +1,239 @@
+package content
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func gzipped(b []byte) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func tarred(files ...string) []byte {
+	buf := &bytes.Buffer{}
+	w := tar.NewWriter(buf)
+	for i := 0; i < len(files); i += 2 {
+		w.WriteHeader(&tar.Header{Name: files[i], Mode: 0644, Size: int64(len(files[i+1])), Typeflag: tar.TypeReg})
+		w.Write([]byte(files[i+1]))
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	archive := gzipped(tarred("GeoLite2_20200101/LICENSE.txt", "license", "GeoLite2_20200101/GeoLite2-City.mmdb", "mmdb"))
+	tests := []struct {
+		name    string
+		data    []byte
+		file    string
+		member  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "Not compressed",
+			data: []byte("plain"),
+			file: "db.mmdb",
+			want: "plain",
+		},
+		{
+			name: "gzip",
+			data: gzipped([]byte("unzipped")),
+			file: "db.mmdb.gz",
+			want: "unzipped",
+		},
+		{
+			name:   "tar.gz member",
+			data:   archive,
+			file:   "GeoLite2-City.tar.gz",
+			member: "GeoLite2-City.mmdb",
+			want:   "mmdb",
+		},
+		{
+			name: "tgz first file",
+			data: archive,
+			file: "GeoLite2-City.tgz",
+			want: "license",
+		},
+		{
+			name:    "Missing member",
+			data:    archive,
+			file:    "GeoLite2-City.tar.gz",
+			member:  "GeoLite2-ASN.mmdb",
+			wantErr: true,
+		},
+		{
+			name:    "Truncated",
+			data:    archive[:len(archive)-10],
+			file:    "GeoLite2-City.tar.gz",
+			member:  "GeoLite2-City.mmdb",
+			wantErr: true,
+		},
+		{
+			name:    "Not gzip",
+			data:    []byte("plain"),
+			file:    "db.gz",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &dataProvider{data: tt.data}
+			p := Decode(src, tt.file, tt.member)
+			b, err := p.Get(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if b != nil || src.read {
+					t.Errorf("Get() = %q and read = %v after an error", b, src.read)
+				}
+				return
+			}
+			if string(b) != tt.want {
+				t.Errorf("Get() = %q, want %q", b, tt.want)
+			}
+			if _, err := p.Get(context.Background()); err != ErrNoChange {
+				t.Errorf("Get() error = %v, want ErrNoChange", err)
+			}
+		})
+	}
+}
+
+func TestDecodeZstd(t *testing.T) {
+	fox := strings.Repeat("the quick brown fox jumps over the lazy dog\n", 100)
+	tests := []struct {
+		name    string
+		file    string
+		member  string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "zst", file: "testdata/fox.txt.zst", want: fox},
+		{name: "tar.zst member", file: "testdata/fox.tar.zst", member: "fox.txt", want: fox},
+		{name: "Not zstd", file: "db.zst", data: []byte("plain"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.data
+			if data == nil {
+				var err error
+				data, err = ioutil.ReadFile(tt.file)
+				rtx.Must(err, "Could not read test data")
+			}
+			b, err := Decode(&dataProvider{data: data}, tt.file, tt.member).Get(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(b) != tt.want {
+				t.Errorf("Get() = %q, want %q", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeContentEncoding(t *testing.T) {
+	tests := []struct {
+		name, member string
+		data         []byte
+	}{
+		{name: "config.json", data: gzipped([]byte("decoded"))},
+		// The Content-Encoding describes the extension, so gzip is decoded once.
+		{name: "config.json.gz", data: gzipped([]byte("decoded"))},
+		{name: "db.tar.gz", member: "db", data: gzipped(tarred("db", "decoded"))},
+		{name: "db.tgz", member: "db", data: gzipped(tarred("db", "decoded"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.data
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Encoding", "gzip")
+					w.Write(data)
+				}),
+			)
+			defer srv.Close()
+			// Loopback http:// URLs use the production client.
+			u, err := url.Parse(srv.URL + "/" + tt.name)
+			rtx.Must(err, "Could not parse URL")
+			p, err := FromURL(context.Background(), u)
+			rtx.Must(err, "Could not create Provider")
+			b, err := Decode(p, tt.name, tt.member).Get(context.Background())
+			if string(b) != "decoded" || err != nil {
+				t.Errorf("Get() = %q, %v, want decoded", b, err)
+			}
+		})
+	}
+}
+
+func TestDecodeContentEncodingDefaultClient(t *testing.T) {
+	data := gzipped([]byte("db"))
+	sum := sha256.Sum256(data)
+	srv := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// Like many servers, send a .gz file with its Content-Encoding,
+			// whatever the client accepts.
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(data)
+		}),
+	)
+	defer srv.Close()
+	// Trust the test server, while still using http.DefaultClient.
+	defer func(rt http.RoundTripper) { http.DefaultTransport = rt }(http.DefaultTransport)
+	http.DefaultTransport = srv.Client().Transport
+
+	u, err := url.Parse(srv.URL + "/db.mmdb.gz#sha256=" + hex.EncodeToString(sum[:]))
+	rtx.Must(err, "Could not parse URL")
+	p, err := FromURL(context.Background(), u)
+	rtx.Must(err, "Could not create Provider")
+	b, err := Decode(p, "db.mmdb.gz", "").Get(context.Background())
+	if string(b) != "db" || err != nil {
+		t.Errorf("Get() = %q, %v, want db", b, err)
+	}
+}
+
+func TestDecodeVerified(t *testing.T) {
+	data := gzipped([]byte("db"))
+	sum := sha256.Sum256(data)
+	src := &dataProvider{data: data}
+	p := Decode(Verify(src, sum[:]), "db.gz", "")
+	if b, err := p.Get(context.Background()); string(b) != "db" || err != nil {
+		t.Errorf("Get() = %q, %v, want db", b, err)
+	}
+
+	// Trailing garbage is not decoded, but it is verified.
+	src = &dataProvider{data: append(data, 0)}
+	p = Decode(Verify(src, sum[:]), "db.gz", "")
+	if _, err := p.Get(context.Background()); err == nil {
+		t.Error("Get() should have failed")
+	}
+	if src.read {
+		t.Error("The copy that failed verification was recorded as read")
+	}
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterDecoder(.gz) should have panicked")
+		}
+	}()
+	RegisterDecoder(".gz", "gzip", nil)
+}
@@ -0,0 +1,7 @@
+//go:build race
+// +build race
+
+package zstd
+
+// raceEnabled replaces internal/race.Enabled of the standard library.
+const raceEnabled = true
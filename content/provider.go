@@ -4,9 +4,11 @@ package content
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -33,6 +35,89 @@ type Provider interface {
 	Get(ctx context.Context) ([]byte, error)
 }
 
+// Opener is implemented by Providers that can stream their data instead of
+// returning it all at once. Every Provider returned by FromURL is an Opener.
+type Opener interface {
+	// Open returns a reader of the latest copy of the provider URL and the Meta
+	// describing that copy. Like Get, Open returns ErrNoChange if the data is
+	// unchanged since the last copy that was read to the end. The caller must
+	// Close the returned reader.
+	Open(ctx context.Context) (io.ReadCloser, Meta, error)
+}
+
+// Meta describes one copy of the data returned by a Provider. Fields that a
+// Provider cannot know are left as their zero value.
+type Meta struct {
+	// Size is the length of the data in bytes, or -1 if it is not known.
+	Size int64
+	// MD5 is the MD5 hash of the data, as reported by GCS.
+	MD5 []byte
+	// ETag is the entity tag reported by an HTTPS server or GCS.
+	ETag string
+	// LastModified is the time the data was last changed.
+	LastModified time.Time
+	// ContentEncoding is the Content-Encoding reported by an HTTPS server, if
+	// the data was not already decoded in transit.
+	ContentEncoding string
+}
+
+// Open returns a reader of the data of p. If p is not an Opener, Open calls
+// p.Get and returns a reader of the result.
+func Open(ctx context.Context, p Provider) (io.ReadCloser, Meta, error) {
+	if o, ok := p.(Opener); ok {
+		return o.Open(ctx)
+	}
+	b, err := p.Get(ctx)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), Meta{Size: int64(len(b))}, nil
+}
+
+// commitReader calls commit once its reader has been read to the end, so that
+// a Provider only records a copy as seen when the caller has received all of
+// it.
+type commitReader struct {
+	io.ReadCloser
+	commit func()
+}
+
+func (c *commitReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if err == io.EOF && c.commit != nil {
+		c.commit()
+		c.commit = nil
+	}
+	return n, err
+}
+
+// detachCommit removes the commit function from r, so that a wrapper can
+// decide when the copy counts as seen. It returns a no-op function if r was
+// not returned by a Provider of this package.
+func detachCommit(r io.ReadCloser) (io.ReadCloser, func()) {
+	c, ok := r.(*commitReader)
+	if !ok || c.commit == nil {
+		return r, func() {}
+	}
+	commit := c.commit
+	c.commit = nil
+	return c, commit
+}
+
+// readAll implements Get for an Opener.
+func readAll(ctx context.Context, o Opener) ([]byte, error) {
+	r, _, err := o.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
 // gcsProvider gets zip files from Google Cloud Storage.
 type gcsProvider struct {
 	bucket, filename string
@@ -41,83 +126,176 @@ type gcsProvider struct {
 }
 
 func (g *gcsProvider) Get(ctx context.Context) ([]byte, error) {
+	return readAll(ctx, g)
+}
+
+func (g *gcsProvider) Open(ctx context.Context) (io.ReadCloser, Meta, error) {
 	o := g.client.Bucket(g.bucket).Object(g.filename)
 	oa, err := o.Attrs(ctx)
 	if err != nil {
-		return nil, err
+		return nil, Meta{}, err
 	}
 	if g.md5 != nil && bytes.Equal(g.md5, oa.MD5) {
-		return nil, ErrNoChange
+		return nil, Meta{}, ErrNoChange
 	}
 
 	// Otherise, we know that either g.md5 == nil || g.md5 != oa.MD5.
 	// Reload data only if the object changed or the data was never loaded in the first place.
 	r, err := o.NewReader(ctx)
 	if err != nil {
-		return nil, err
+		return nil, Meta{}, err
 	}
-	data, err := ioutil.ReadAll(r)
-	if err != nil {
-		return nil, err
+	meta := Meta{
+		Size:         oa.Size,
+		MD5:          oa.MD5,
+		ETag:         oa.Etag,
+		LastModified: oa.Updated,
 	}
-	if g.md5 != nil {
-		metrics.GCSFilesLoaded.WithLabelValues(hex.EncodeToString(g.md5)).Set(0)
+	commit := func() {
+		if g.md5 != nil {
+			metrics.GCSFilesLoaded.WithLabelValues(hex.EncodeToString(g.md5)).Set(0)
+		}
+		g.md5 = oa.MD5
+		metrics.GCSFilesLoaded.WithLabelValues(hex.EncodeToString(g.md5)).Set(1)
 	}
-	g.md5 = oa.MD5
-	metrics.GCSFilesLoaded.WithLabelValues(hex.EncodeToString(g.md5)).Set(1)
-	return data, nil
+	return &commitReader{ReadCloser: r, commit: commit}, meta, nil
 }
 
 // fileProvider gets files from the local disk.
 type fileProvider struct {
 	filename string
 	mtime    time.Time
+	size     int64
 }
 
 func (f *fileProvider) Get(ctx context.Context) ([]byte, error) {
-	s, err := os.Stat(f.filename)
+	return readAll(ctx, f)
+}
+
+func (f *fileProvider) Open(ctx context.Context) (io.ReadCloser, Meta, error) {
+	file, err := os.Open(f.filename)
 	if err != nil {
-		return nil, fmt.Errorf("Could not os.Stat(%q): %w", f.filename, err)
+		return nil, Meta{}, fmt.Errorf("Could not os.Open(%q): %w", f.filename, err)
 	}
-	newtime := s.ModTime()
-	if newtime == f.mtime {
-		return nil, ErrNoChange
-	}
-	b, err := ioutil.ReadFile(f.filename)
+	// Stat the open file, so the Meta describes the data that is read even if
+	// the file is replaced in the meantime.
+	s, err := file.Stat()
 	if err != nil {
-		return nil, err
+		file.Close()
+		return nil, Meta{}, fmt.Errorf("Could not Stat(%q): %w", f.filename, err)
 	}
-	f.mtime = newtime
-	return b, nil
+	mtime, size := s.ModTime(), s.Size()
+	if mtime.Equal(f.mtime) && size == f.size {
+		file.Close()
+		return nil, Meta{}, ErrNoChange
+	}
+	meta := Meta{
+		Size:         size,
+		LastModified: mtime,
+	}
+	commit := func() {
+		f.mtime = mtime
+		f.size = size
+	}
+	return &commitReader{ReadCloser: file, commit: commit}, meta, nil
 }
 
 // httpsProvider gets files from public HTTPS URLs (i.e. no authentication).
+// When the server sends an ETag or Last-Modified header, later requests are
+// conditional, so unchanged files are not downloaded again. It also serves
+// loopback http:// URLs, and s3:// URLs through an authorize function.
 type httpsProvider struct {
 	u       url.URL
 	timeout time.Duration
 	client  *http.Client
+
+	// authorize, if not nil, is called to add credentials to every request
+	// after all other headers are set.
+	authorize func(r *http.Request) error
+
+	etag         string
+	lastModified string
 }
 
 func (h *httpsProvider) Get(ctx context.Context) ([]byte, error) {
+	return readAll(ctx, h)
+}
+
+func (h *httpsProvider) Open(ctx context.Context) (io.ReadCloser, Meta, error) {
 	reqCtx, cancel := context.WithTimeout(ctx, h.timeout)
-	defer cancel()
 	r, err := http.NewRequestWithContext(reqCtx, http.MethodGet, h.u.String(), nil)
 	if err != nil {
-		return nil, err
+		cancel()
+		return nil, Meta{}, err
+	}
+	// Ask for the data as is. Otherwise the client would decode a gzip
+	// Content-Encoding on its own, and Decode and Verify would see
+	// different bytes depending on the client.
+	r.Header.Set("Accept-Encoding", "identity")
+	if h.etag != "" {
+		r.Header.Set("If-None-Match", h.etag)
+	}
+	if h.lastModified != "" {
+		r.Header.Set("If-Modified-Since", h.lastModified)
+	}
+	if h.authorize != nil {
+		if err := h.authorize(r); err != nil {
+			cancel()
+			return nil, Meta{}, err
+		}
 	}
 	resp, err := h.client.Do(r)
 	if err != nil {
-		return nil, err
+		cancel()
+		return nil, Meta{}, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		resp.Body.Close()
+		cancel()
+		return nil, Meta{}, ErrNoChange
+	default:
+		resp.Body.Close()
+		cancel()
+		return nil, Meta{}, fmt.Errorf("Could not GET %q: %s", h.u.String(), resp.Status)
+	}
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	meta := Meta{
+		Size:            resp.ContentLength,
+		ETag:            etag,
+		ContentEncoding: resp.Header.Get("Content-Encoding"),
 	}
-	defer resp.Body.Close()
-	return ioutil.ReadAll(resp.Body)
+	if t, err := http.ParseTime(lastModified); err == nil {
+		meta.LastModified = t
+	}
+	commit := func() {
+		h.etag = etag
+		h.lastModified = lastModified
+	}
+	body := &cancelCloser{ReadCloser: resp.Body, cancel: cancel}
+	return &commitReader{ReadCloser: body, commit: commit}, meta, nil
+}
+
+// cancelCloser cancels the context of a request when its body is closed.
+type cancelCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
 }
 
 // FromURL returns a new rawfile.Provider based on the passed-in URL. Supported
-// URL schemes are currently: gs://bucket/filename, file:localpath, and
-// https://. Whether the path contained in the URL is valid isn't known until
-// the Get() method of the returned Provider is called. Unsupported URL schemes
-// cause this to return ErrUnsupportedURLScheme.
+// URL schemes are currently: gs://bucket/filename, file:localpath, https://,
+// http:// to a loopback address, s3://bucket/key and data:, as well as any
+// scheme added with Register. Whether the path contained in the URL is valid
+// isn't known until the Get() method of the returned Provider is called.
+// Unsupported URL schemes cause this to return ErrUnsupportedURLScheme. If the
+// URL has a fragment like #sha256=<hex digest>, the returned Provider fails
+// unless the data has that digest, as described for Verify.
 //
 // Users interested in having the daemon download the data directly from MaxMind
 // using credentials should implement an alternate https case in the below
@@ -125,35 +303,49 @@ func (h *httpsProvider) Get(ctx context.Context) ([]byte, error) {
 // reduce load on their servers and to eliminate a runtime dependency on a third
 // party service.
 func FromURL(ctx context.Context, u *url.URL) (Provider, error) {
-	switch u.Scheme {
-	case "gs":
-		client, err := storage.NewClient(ctx)
-		filename := strings.TrimPrefix(u.Path, "/")
-		if len(filename) == 0 {
-			return nil, errors.New("Bad GS url, no filename detected")
-		}
-		return &gcsProvider{
-			client:   stiface.AdaptClient(client),
-			bucket:   u.Host,
-			filename: filename,
-		}, err
-	case "file":
-		if u.Path == "" {
-			return &fileProvider{
-				filename: u.Opaque,
-			}, nil
-		}
-		return &fileProvider{
-			filename: u.Path,
-		}, nil
+	f := lookup(u.Scheme)
+	if f == nil {
+		return nil, ErrUnsupportedURLScheme
+	}
+	p, err := f(ctx, u)
+	if err != nil || !strings.HasPrefix(u.Fragment, "sha256=") {
+		return p, err
+	}
+	sum, err := hex.DecodeString(strings.TrimPrefix(u.Fragment, "sha256="))
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("Bad SHA-256 digest in URL fragment %q", u.Fragment)
+	}
+	return Verify(p, sum), nil
+}
 
-	case "https":
-		return &httpsProvider{
-			u:       *u,
-			timeout: time.Minute,
-			client:  http.DefaultClient,
+func fromGCSURL(ctx context.Context, u *url.URL) (Provider, error) {
+	client, err := storage.NewClient(ctx)
+	filename := strings.TrimPrefix(u.Path, "/")
+	if len(filename) == 0 {
+		return nil, errors.New("Bad GS url, no filename detected")
+	}
+	return &gcsProvider{
+		client:   stiface.AdaptClient(client),
+		bucket:   u.Host,
+		filename: filename,
+	}, err
+}
+
+func fromFileURL(ctx context.Context, u *url.URL) (Provider, error) {
+	if u.Path == "" {
+		return &fileProvider{
+			filename: u.Opaque,
 		}, nil
-	default:
-		return nil, ErrUnsupportedURLScheme
 	}
+	return &fileProvider{
+		filename: u.Path,
+	}, nil
+}
+
+func fromHTTPSURL(ctx context.Context, u *url.URL) (Provider, error) {
+	return &httpsProvider{
+		u:       *u,
+		timeout: time.Minute,
+		client:  http.DefaultClient,
+	}, nil
 }
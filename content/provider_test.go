@@ -191,6 +191,10 @@ func (s *stifaceReaderThatsJustAnIOReader) Read(p []byte) (int, error) {
 	return s.r.Read(p)
 }
 
+func (s *stifaceReaderThatsJustAnIOReader) Close() error {
+	return nil
+}
+
 type readerWhereReadFails struct {
 	stiface.Reader
 }
@@ -199,6 +203,10 @@ func (*readerWhereReadFails) Read(p []byte) (int, error) {
 	return 0, errors.New("This reader fails for test purposes")
 }
 
+func (*readerWhereReadFails) Close() error {
+	return nil
+}
+
 type fakeObjectHandle struct {
 	stiface.ObjectHandle
 	attrErr   error
@@ -423,3 +431,127 @@ func Test_httpsProvider_Get(t *testing.T) {
 		})
 	}
 }
+
+func Test_httpsProvider_Conditional(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		check  string
+	}{
+		{
+			name:   "etag",
+			header: "ETag",
+			value:  `"v1"`,
+			check:  "If-None-Match",
+		},
+		{
+			name:   "last-modified",
+			header: "Last-Modified",
+			value:  "Mon, 02 Jan 2006 15:04:05 GMT",
+			check:  "If-Modified-Since",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			downloads := 0
+			srv := httptest.NewTLSServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					if r.Header.Get(tt.check) == tt.value {
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+					downloads++
+					w.Header().Set(tt.header, tt.value)
+					io.WriteString(w, "{}")
+				}),
+			)
+			defer srv.Close()
+			u, err := url.Parse(srv.URL)
+			rtx.Must(err, "failed to parse url from test server")
+			h := &httpsProvider{u: *u, timeout: time.Second, client: srv.Client()}
+
+			r, meta, err := h.Open(context.Background())
+			rtx.Must(err, "Could not Open")
+			if meta.Size != 2 {
+				t.Errorf("Open() Size = %d, want 2", meta.Size)
+			}
+			// The copy is not recorded until it has been read to the end.
+			r.Close()
+			got, err := h.Get(context.Background())
+			if err != nil || string(got) != "{}" {
+				t.Errorf("Get() = %q, %v, want {}", got, err)
+			}
+			if _, err = h.Get(context.Background()); err != ErrNoChange {
+				t.Errorf("Get() error = %v, want ErrNoChange", err)
+			}
+			if downloads != 2 {
+				t.Errorf("Server sent the data %d times, want 2", downloads)
+			}
+		})
+	}
+}
+
+func Test_httpsProvider_BadStatus(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	rtx.Must(err, "failed to parse url from test server")
+	h := &httpsProvider{u: *u, timeout: time.Second, client: srv.Client()}
+	if _, err := h.Get(context.Background()); err == nil || err == ErrNoChange {
+		t.Errorf("Get() error = %v, want a status error", err)
+	}
+}
+
+func Test_fileProvider_Open(t *testing.T) {
+	tf, err := ioutil.TempFile("", "")
+	rtx.Must(err, "Could not create tempfile")
+	defer os.Remove(tf.Name())
+	mtime := time.Now().Add(-time.Hour)
+	write := func(s string) {
+		rtx.Must(ioutil.WriteFile(tf.Name(), []byte(s), 0644), "Could not write tempfile")
+		// Keep the same mtime, so only the size tells the versions apart.
+		rtx.Must(os.Chtimes(tf.Name(), mtime, mtime), "Could not set mtime")
+	}
+	write("one")
+	f := &fileProvider{filename: tf.Name()}
+	r, meta, err := f.Open(context.Background())
+	rtx.Must(err, "Could not Open")
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if string(b) != "one" || meta.Size != 3 || !meta.LastModified.Equal(mtime) {
+		t.Errorf("Open() = %q, %+v, want one with size 3 and mtime %v", b, meta, mtime)
+	}
+	if _, _, err = f.Open(context.Background()); err != ErrNoChange {
+		t.Errorf("Open() error = %v, want ErrNoChange", err)
+	}
+	write("three")
+	b, err = f.Get(context.Background())
+	if string(b) != "three" || err != nil {
+		t.Errorf("Get() = %q, %v, want three", b, err)
+	}
+}
+
+type getOnlyProvider []byte
+
+func (g getOnlyProvider) Get(ctx context.Context) ([]byte, error) {
+	return []byte(g), nil
+}
+
+func TestOpen(t *testing.T) {
+	r, meta, err := Open(context.Background(), getOnlyProvider("hello"))
+	rtx.Must(err, "Could not Open")
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if string(b) != "hello" || err != nil || meta.Size != 5 {
+		t.Errorf("Open() = %q, %+v, %v, want hello", b, meta, err)
+	}
+
+	u, err := url.Parse("file:provider.go")
+	rtx.Must(err, "Could not parse URL")
+	p, err := FromURL(context.Background(), u)
+	rtx.Must(err, "Could not create provider")
+	if _, ok := p.(Opener); !ok {
+		t.Errorf("FromURL() = %T, which is not an Opener", p)
+	}
+}
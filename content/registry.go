@@ -0,0 +1,152 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrNotLoopback is returned for http:// URLs whose host is not a loopback
+// address. Plain HTTP is only allowed to servers on the same machine.
+var ErrNotLoopback = errors.New("HTTP URLs must name a loopback host")
+
+// ProviderFunc creates a Provider from a URL. The URL scheme has already been
+// matched by FromURL.
+type ProviderFunc func(ctx context.Context, u *url.URL) (Provider, error)
+
+var (
+	schemesMu sync.RWMutex
+	schemes   = map[string]ProviderFunc{
+		"gs":    fromGCSURL,
+		"file":  fromFileURL,
+		"https": fromHTTPSURL,
+		"http":  fromLoopbackURL,
+		"s3":    fromS3URL,
+		"data":  fromDataURL,
+	}
+)
+
+// Register makes FromURL use f for URLs with the given scheme. It is meant to
+// be called from the init function of the package that implements the
+// Provider. Register panics if f is nil or the scheme is already registered,
+// including the built-in schemes.
+func Register(scheme string, f ProviderFunc) {
+	schemesMu.Lock()
+	defer schemesMu.Unlock()
+	scheme = strings.ToLower(scheme)
+	if f == nil {
+		panic("content: Register of a nil ProviderFunc for " + scheme)
+	}
+	if _, dup := schemes[scheme]; dup {
+		panic("content: Register called twice for " + scheme)
+	}
+	schemes[scheme] = f
+}
+
+// lookup returns the ProviderFunc for the scheme, or nil if there is none.
+func lookup(scheme string) ProviderFunc {
+	schemesMu.RLock()
+	defer schemesMu.RUnlock()
+	return schemes[strings.ToLower(scheme)]
+}
+
+// fromLoopbackURL creates a Provider for http:// URLs, e.g. of a sidecar config
+// server. The host is checked here, and the address is checked again when
+// connecting, so neither DNS nor a redirect can lead the Provider elsewhere.
+func fromLoopbackURL(ctx context.Context, u *url.URL) (Provider, error) {
+	if !isLoopback(u.Hostname()) {
+		return nil, fmt.Errorf("%w: %q", ErrNotLoopback, u.Host)
+	}
+	return &httpsProvider{
+		u:       *u,
+		timeout: time.Minute,
+		client:  loopbackClient,
+	}, nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// loopbackClient refuses to connect to anything but loopback addresses.
+var loopbackClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(network, address string, c syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+					return fmt.Errorf("%w: %q", ErrNotLoopback, address)
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+}
+
+// dataProvider returns the data inlined in a data: URL, as described in RFC
+// 2397. The data never changes, so every call after the first one that read
+// it returns ErrNoChange.
+type dataProvider struct {
+	data []byte
+	read bool
+}
+
+func (d *dataProvider) Get(ctx context.Context) ([]byte, error) {
+	return readAll(ctx, d)
+}
+
+func (d *dataProvider) Open(ctx context.Context) (io.ReadCloser, Meta, error) {
+	if d.read {
+		return nil, Meta{}, ErrNoChange
+	}
+	r := ioutil.NopCloser(bytes.NewReader(d.data))
+	commit := func() { d.read = true }
+	return &commitReader{ReadCloser: r, commit: commit}, Meta{Size: int64(len(d.data))}, nil
+}
+
+func fromDataURL(ctx context.Context, u *url.URL) (Provider, error) {
+	// The whole data: URL is opaque, e.g. data:text/plain;base64,aGVsbG8=,
+	// except for anything after a literal '?', which url.Parse treats as a
+	// query.
+	opaque := u.Opaque
+	if u.RawQuery != "" || u.ForceQuery {
+		opaque += "?" + u.RawQuery
+	}
+	comma := strings.Index(opaque, ",")
+	if comma < 0 {
+		return nil, errors.New("Bad data url, no comma detected")
+	}
+	header, payload := opaque[:comma], opaque[comma+1:]
+	var data []byte
+	var err error
+	if strings.HasSuffix(header, ";base64") {
+		data, err = base64.StdEncoding.DecodeString(payload)
+	} else {
+		var s string
+		s, err = url.PathUnescape(payload)
+		data = []byte(s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Bad data url: %w", err)
+	}
+	return &dataProvider{data: data}, nil
+}
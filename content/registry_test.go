@@ -0,0 +1,127 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func TestRegister(t *testing.T) {
+	called := false
+	Register("test-scheme", func(ctx context.Context, u *url.URL) (Provider, error) {
+		called = true
+		return getOnlyProvider("hello"), nil
+	})
+	u, err := url.Parse("TEST-SCHEME://anything")
+	rtx.Must(err, "Could not parse URL")
+	p, err := FromURL(context.Background(), u)
+	rtx.Must(err, "Could not create provider")
+	if b, _ := p.Get(context.Background()); !called || string(b) != "hello" {
+		t.Errorf("Get() = %q, want hello from the registered scheme", b)
+	}
+
+	for _, scheme := range []string{"test-scheme", "https"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%q) should have panicked", scheme)
+				}
+			}()
+			Register(scheme, fromFileURL)
+		}()
+	}
+}
+
+func TestFromDataURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "Plain",
+			url:  "data:,hello%20world",
+			want: "hello world",
+		},
+		{
+			name: "Plain with a question mark",
+			url:  "data:text/plain,what?",
+			want: "what?",
+		},
+		{
+			name: "Base64",
+			url:  "data:application/json;base64,eyJhIjoxfQ==",
+			want: `{"a":1}`,
+		},
+		{
+			name:    "Bad base64",
+			url:     "data:;base64,!!!",
+			wantErr: true,
+		},
+		{
+			name:    "No comma",
+			url:     "data:text/plain",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			rtx.Must(err, "Could not parse URL")
+			p, err := FromURL(context.Background(), u)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FromURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			b, err := p.Get(context.Background())
+			if string(b) != tt.want || err != nil {
+				t.Errorf("Get() = %q, %v, want %q", b, err, tt.want)
+			}
+			if _, err = p.Get(context.Background()); err != ErrNoChange {
+				t.Errorf("Get() error = %v, want ErrNoChange", err)
+			}
+		})
+	}
+}
+
+func TestFromLoopbackURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v1"`)
+			io.WriteString(w, "{}")
+		}),
+	)
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/config.json")
+	rtx.Must(err, "Could not parse URL")
+	p, err := FromURL(context.Background(), u)
+	rtx.Must(err, "Could not create provider")
+	b, err := p.Get(context.Background())
+	if string(b) != "{}" || err != nil {
+		t.Errorf("Get() = %q, %v, want {}", b, err)
+	}
+
+	for _, s := range []string{"http://example.com/config.json", "http://10.0.0.1/"} {
+		u, err := url.Parse(s)
+		rtx.Must(err, "Could not parse URL")
+		if _, err := FromURL(context.Background(), u); !errors.Is(err, ErrNotLoopback) {
+			t.Errorf("FromURL(%q) error = %v, want ErrNotLoopback", s, err)
+		}
+	}
+
+	// Even with a loopback name, the client refuses other addresses.
+	h := &httpsProvider{u: url.URL{Scheme: "http", Host: "192.0.2.1:80"}, timeout: time.Second, client: loopbackClient}
+	if _, err := h.Get(context.Background()); !errors.Is(err, ErrNotLoopback) {
+		t.Errorf("Get() error = %v, want ErrNotLoopback", err)
+	}
+}
@@ -0,0 +1,194 @@
+package content
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptySHA256 is the hex SHA-256 of an empty payload, which is the payload of
+// every request sent to S3 by this package.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// fromS3URL creates a Provider for s3://bucket/key URLs. The endpoint and
+// region may be set with the "endpoint" and "region" query parameters, and
+// otherwise come from the AWS_ENDPOINT_URL and AWS_REGION environment
+// variables. The endpoint is addressed path-style, which every S3-compatible
+// store, e.g. MinIO, supports. Requests are signed with the credentials in
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, or sent
+// anonymously if there are none.
+func fromS3URL(ctx context.Context, u *url.URL) (Provider, error) {
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, errors.New("Bad S3 url, no bucket or key detected")
+	}
+	q := u.Query()
+	region := firstNonEmpty(q.Get("region"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	endpoint := firstNonEmpty(q.Get("endpoint"), os.Getenv("AWS_ENDPOINT_URL"), "https://s3."+region+".amazonaws.com")
+	e, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Bad S3 endpoint %q: %w", endpoint, err)
+	}
+	client := http.DefaultClient
+	switch e.Scheme {
+	case "https":
+	case "http":
+		if !isLoopback(e.Hostname()) {
+			return nil, fmt.Errorf("%w: %q", ErrNotLoopback, e.Host)
+		}
+		client = loopbackClient
+	default:
+		return nil, fmt.Errorf("Bad S3 endpoint %q: %w", endpoint, ErrUnsupportedURLScheme)
+	}
+
+	object := url.URL{Scheme: e.Scheme, Host: e.Host}
+	object.Path = strings.TrimSuffix(e.Path, "/") + "/" + u.Host + "/" + key
+	// Send the path exactly as it is signed.
+	object.RawPath = s3Escape(object.Path, true)
+	h := &httpsProvider{
+		u:       object,
+		timeout: time.Minute,
+		client:  client,
+	}
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		s := &s3Signer{
+			accessKey:    id,
+			secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			region:       region,
+			now:          time.Now,
+		}
+		h.authorize = s.sign
+	}
+	return h, nil
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// s3Signer signs requests with AWS Signature Version 4.
+type s3Signer struct {
+	accessKey, secretKey, sessionToken, region string
+	now                                        func() time.Time
+}
+
+// sign adds the Authorization header to r. Every header already set on r is
+// signed, along with the host.
+func (s *s3Signer) sign(r *http.Request) error {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	r.Header.Set("X-Amz-Date", amzDate)
+	r.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if s.sessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		path,
+		s3CanonicalQuery(r.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptySHA256,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	r.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3CanonicalQuery returns the query sorted by name and escaped as required
+// by Signature Version 4.
+func s3CanonicalQuery(q url.Values) string {
+	var params [][2]string
+	for k, vs := range q {
+		for _, v := range vs {
+			params = append(params, [2]string{s3Escape(k, false), s3Escape(v, false)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	parts := make([]string, len(params))
+	for i, p := range params {
+		parts[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent-encodes every byte of s except the unreserved characters of
+// RFC 3986 and, if keepSlash is true, the '/'.
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,131 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strings"
+)
+
+// ErrDigestMismatch is returned when the data of a Provider does not have the
+// expected SHA-256 digest.
+var ErrDigestMismatch = errors.New("Data does not match its SHA-256 digest")
+
+// verifiedProvider fails unless the data of a Provider has the expected
+// SHA-256 digest. The expected digest is either pinned or read from a
+// checksum file.
+type verifiedProvider struct {
+	p    Provider
+	sum  []byte
+	sums Provider
+	name string
+}
+
+// Verify returns a Provider of the data of p that fails with
+// ErrDigestMismatch unless the data has the given SHA-256 digest. A copy that
+// fails verification is not recorded as seen by p, so it is downloaded again
+// on the next call, as long as p is a Provider of this package.
+//
+// FromURL calls Verify for URLs with a fragment like #sha256=<hex digest>.
+func Verify(p Provider, sum []byte) Provider {
+	return &verifiedProvider{p: p, sum: sum}
+}
+
+// VerifyWith is like Verify, but the expected digest is read from a checksum
+// file provided by checksums, e.g. the detached .sha256 file next to an
+// archive. The file uses the format of sha256sum: one line per file, holding
+// the hex digest and the file name. The line for name is used, or the only
+// line if there is just one.
+func VerifyWith(p Provider, checksums Provider, name string) Provider {
+	return &verifiedProvider{p: p, sums: checksums, name: name}
+}
+
+func (v *verifiedProvider) Get(ctx context.Context) ([]byte, error) {
+	return readAll(ctx, v)
+}
+
+func (v *verifiedProvider) Open(ctx context.Context) (io.ReadCloser, Meta, error) {
+	r, meta, err := Open(ctx, v.p)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	want, err := v.want(ctx)
+	if err != nil {
+		r.Close()
+		return nil, Meta{}, err
+	}
+	// Only record the copy as seen once it has been verified.
+	r, commit := detachCommit(r)
+	vr := &verifyReader{ReadCloser: r, hash: sha256.New(), want: want}
+	return &commitReader{ReadCloser: vr, commit: commit}, meta, nil
+}
+
+// want returns the expected digest, reading the checksum file if it changed.
+func (v *verifiedProvider) want(ctx context.Context) ([]byte, error) {
+	if v.sums == nil {
+		return v.sum, nil
+	}
+	b, err := v.sums.Get(ctx)
+	if err == ErrNoChange && v.sum != nil {
+		return v.sum, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not get the checksum of %q: %w", v.name, err)
+	}
+	sum, err := parseChecksums(b, v.name)
+	if err != nil {
+		return nil, err
+	}
+	v.sum = sum
+	return sum, nil
+}
+
+// parseChecksums returns the digest for name from a file in the format of
+// sha256sum.
+func parseChecksums(b []byte, name string) ([]byte, error) {
+	var sums [][]byte
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		sum, err := hex.DecodeString(fields[0])
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("Bad checksum line %q", line)
+		}
+		// sha256sum marks files read in binary mode with a '*'.
+		if len(fields) > 1 && path.Base(strings.TrimPrefix(fields[1], "*")) == path.Base(name) {
+			return sum, nil
+		}
+		sums = append(sums, sum)
+	}
+	if len(sums) != 1 {
+		return nil, fmt.Errorf("Could not find the checksum of %q", name)
+	}
+	return sums[0], nil
+}
+
+// verifyReader hashes the data it reads, and returns ErrDigestMismatch
+// instead of io.EOF if the digest is wrong.
+type verifyReader struct {
+	io.ReadCloser
+	hash hash.Hash
+	want []byte
+}
+
+func (v *verifyReader) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF {
+		if got := v.hash.Sum(nil); !bytes.Equal(got, v.want) {
+			return n, fmt.Errorf("%w: got %x, want %x", ErrDigestMismatch, got, v.want)
+		}
+	}
+	return n, err
+}
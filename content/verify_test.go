@@ -0,0 +1,114 @@
+package content
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFromURLWithDigest(t *testing.T) {
+	tf, err := ioutil.TempFile("", "")
+	rtx.Must(err, "Could not create tempfile")
+	defer os.Remove(tf.Name())
+	rtx.Must(ioutil.WriteFile(tf.Name(), []byte("data"), 0644), "Could not write tempfile")
+
+	u, err := url.Parse("file:" + tf.Name() + "#sha256=" + sha256Hex("data"))
+	rtx.Must(err, "Could not parse URL")
+	p, err := FromURL(context.Background(), u)
+	rtx.Must(err, "Could not create provider")
+	if b, err := p.Get(context.Background()); string(b) != "data" || err != nil {
+		t.Errorf("Get() = %q, %v, want data", b, err)
+	}
+	if _, err := p.Get(context.Background()); err != ErrNoChange {
+		t.Errorf("Get() error = %v, want ErrNoChange", err)
+	}
+
+	u.Fragment = "sha256=" + sha256Hex("other")
+	p, err = FromURL(context.Background(), u)
+	rtx.Must(err, "Could not create provider")
+	for i := 0; i < 2; i++ {
+		// A copy that fails verification is read again.
+		if b, err := p.Get(context.Background()); b != nil || !errors.Is(err, ErrDigestMismatch) {
+			t.Errorf("Get() = %q, %v, want ErrDigestMismatch", b, err)
+		}
+	}
+
+	u.Fragment = "sha256=abc"
+	if _, err := FromURL(context.Background(), u); err == nil {
+		t.Error("FromURL() should have failed with a short digest")
+	}
+}
+
+func TestVerifyWith(t *testing.T) {
+	sums, err := FromURL(context.Background(), &url.URL{
+		Scheme: "data",
+		Opaque: "," + url.PathEscape(sha256Hex("other")+"  other.gz\n"+sha256Hex("data")+" *dir/db.gz\n"),
+	})
+	rtx.Must(err, "Could not create checksum provider")
+	p := VerifyWith(&dataProvider{data: []byte("data")}, sums, "db.gz")
+	if b, err := p.Get(context.Background()); string(b) != "data" || err != nil {
+		t.Errorf("Get() = %q, %v, want data", b, err)
+	}
+	if _, err := p.Get(context.Background()); err != ErrNoChange {
+		t.Errorf("Get() error = %v, want ErrNoChange", err)
+	}
+
+	p = VerifyWith(&dataProvider{data: []byte("data")}, sums, "missing.gz")
+	if _, err := p.Get(context.Background()); err == nil {
+		t.Error("Get() should have failed without a checksum")
+	}
+}
+
+func Test_parseChecksums(t *testing.T) {
+	one := sha256Hex("one")
+	tests := []struct {
+		name    string
+		file    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "Digest only",
+			file: one + "\n",
+			want: one,
+		},
+		{
+			name: "Single line with another name",
+			file: one + "  GeoLite2-City_20200101.tar.gz\n",
+			want: one,
+		},
+		{
+			name:    "Ambiguous",
+			file:    one + "  a\n" + one + "  b\n",
+			wantErr: true,
+		},
+		{
+			name:    "Not hex",
+			file:    "xyz  db.gz\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseChecksums([]byte(tt.file), "db.gz")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseChecksums() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && hex.EncodeToString(got) != tt.want {
+				t.Errorf("parseChecksums() = %x, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/m-lab/go/memoryless"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The metrics of every Watcher, labeled by WatcherConfig.Name. Like the
+// metrics of memoryless/metrics and memoryless/fleet, they are registered
+// when the package that writes them is imported.
+var (
+	fetchDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "content_watcher_fetch_duration_seconds",
+			Help:    "The time taken by each fetch of a watched Provider.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 12),
+		},
+		[]string{"name"})
+	fetchFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "content_watcher_fetch_failures_total",
+			Help: "The number of fetches of a watched Provider that failed or were rejected by validation.",
+		},
+		[]string{"name", "reason"})
+	lastSuccess = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "content_watcher_last_success_timestamp_seconds",
+			Help: "The Unix time of the last fetch of a watched Provider that succeeded or found the data unchanged.",
+		},
+		[]string{"name"})
+)
+
+// WatcherConfig describes how a Watcher polls its Provider.
+type WatcherConfig struct {
+	// Name labels the Prometheus metrics of the Watcher.
+	Name string
+	// Schedule is the time to wait between fetches. To poll at a fixed
+	// interval instead of a memoryless one, set Min, Expected and Max to the
+	// same value.
+	Schedule memoryless.Config
+	// Validate, if not nil, is called with every new copy of the data. If it
+	// returns an error, the copy is discarded and the last good copy is kept.
+	Validate func(data []byte) error
+	// OnChange, if not nil, is called with every new copy of the data that
+	// passed validation. It is called synchronously, so a slow OnChange delays
+	// the next fetch.
+	OnChange func(data []byte)
+}
+
+// Watcher polls a Provider and keeps the last good copy of its data. New
+// copies are delivered through WatcherConfig.OnChange and the Updates channel
+// only when the data changes.
+type Watcher struct {
+	provider Provider
+	config   WatcherConfig
+	updates  chan []byte
+
+	mu       sync.Mutex
+	data     []byte
+	rejected bool // Whether the last fetched copy failed validation.
+}
+
+// NewWatcher creates a Watcher of p. The Watcher does nothing until Refresh or
+// Watch is called.
+func NewWatcher(p Provider, c WatcherConfig) (*Watcher, error) {
+	if err := c.Schedule.Check(); err != nil {
+		return nil, err
+	}
+	return &Watcher{
+		provider: p,
+		config:   c,
+		updates:  make(chan []byte, 1),
+	}, nil
+}
+
+// Get returns the last good copy of the data, or nil if there is none yet.
+func (w *Watcher) Get() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.data
+}
+
+// Updates returns a channel on which new copies of the data are delivered.
+// Writes to the channel never block: if the reader falls behind, an undelivered
+// copy is replaced by the newer one.
+func (w *Watcher) Updates() <-chan []byte {
+	return w.updates
+}
+
+// Refresh fetches the data once. It returns nil if the data is new or
+// unchanged, and an error if the fetch failed or the new data was rejected by
+// validation. In both error cases the last good copy is kept.
+//
+// A copy rejected by validation is not recorded as seen by the Providers of
+// this package, so the next Refresh fetches it again. For other Providers,
+// which may report ErrNoChange for the rejected copy, Refresh keeps returning
+// an error until a new copy passes validation.
+func (w *Watcher) Refresh(ctx context.Context) error {
+	start := time.Now()
+	data, commit, err := w.fetch(ctx)
+	fetchDuration.WithLabelValues(w.config.Name).Observe(time.Since(start).Seconds())
+	if errors.Is(err, ErrNoChange) {
+		if w.isRejected() {
+			fetchFailures.WithLabelValues(w.config.Name, "invalid").Inc()
+			return fmt.Errorf("Invalid data for %q is unchanged: %w", w.config.Name, err)
+		}
+		lastSuccess.WithLabelValues(w.config.Name).SetToCurrentTime()
+		return nil
+	}
+	if err != nil {
+		fetchFailures.WithLabelValues(w.config.Name, "fetch").Inc()
+		return err
+	}
+	if w.config.Validate != nil {
+		if err := w.config.Validate(data); err != nil {
+			w.setRejected(true)
+			fetchFailures.WithLabelValues(w.config.Name, "invalid").Inc()
+			return fmt.Errorf("Invalid data for %q: %w", w.config.Name, err)
+		}
+	}
+	commit()
+	w.setRejected(false)
+	lastSuccess.WithLabelValues(w.config.Name).SetToCurrentTime()
+
+	if w.store(data) && w.config.OnChange != nil {
+		w.config.OnChange(data)
+	}
+	return nil
+}
+
+// fetch reads the data of the Provider. The returned function records the
+// copy as seen, for Providers that only do so once it is read to the end.
+func (w *Watcher) fetch(ctx context.Context) ([]byte, func(), error) {
+	r, _, err := Open(ctx, w.provider)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+	r, commit := detachCommit(r)
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, commit, nil
+}
+
+func (w *Watcher) isRejected() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rejected
+}
+
+func (w *Watcher) setRejected(rejected bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.rejected = rejected
+}
+
+// store saves data as the last good copy and delivers it to the Updates
+// channel. It returns false if data is the same as the last good copy.
+func (w *Watcher) store(data []byte) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// Not every Provider detects changes, so compare with the last copy too.
+	if w.data != nil && bytes.Equal(w.data, data) {
+		return false
+	}
+	w.data = data
+	select {
+	case <-w.updates:
+	default:
+	}
+	w.updates <- data
+	return true
+}
+
+// Watch calls Refresh immediately and then on every tick of the schedule,
+// until the context is canceled. Errors from Refresh are logged.
+func (w *Watcher) Watch(ctx context.Context) error {
+	refresh := func() {
+		if err := w.Refresh(ctx); err != nil {
+			log.Printf("Could not refresh %q: %v", w.config.Name, err)
+		}
+	}
+	refresh()
+	return memoryless.Run(ctx, refresh, w.config.Schedule)
+}
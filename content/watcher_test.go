@@ -0,0 +1,199 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/memoryless"
+	"github.com/m-lab/go/prometheusx/promtest"
+	"github.com/m-lab/go/rtx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// sequenceProvider returns the next of its results on every call to Get.
+type sequenceProvider struct {
+	results []result
+	calls   int
+}
+
+type result struct {
+	data string
+	err  error
+}
+
+func (s *sequenceProvider) Get(ctx context.Context) ([]byte, error) {
+	r := s.results[s.calls]
+	s.calls++
+	if r.err != nil {
+		return nil, r.err
+	}
+	return []byte(r.data), nil
+}
+
+func TestWatcherRefresh(t *testing.T) {
+	p := &sequenceProvider{
+		results: []result{
+			{data: "one"},
+			{err: ErrNoChange},
+			{err: errors.New("fetch failed")},
+			{data: "bad"},
+			{data: "one"},
+			{data: "two"},
+		},
+	}
+	var changes []string
+	w, err := NewWatcher(p, WatcherConfig{
+		Name:     "TestWatcherRefresh",
+		Schedule: memoryless.Config{Expected: time.Second},
+		Validate: func(data []byte) error {
+			if string(data) == "bad" {
+				return errors.New("bad data")
+			}
+			return nil
+		},
+		OnChange: func(data []byte) {
+			changes = append(changes, string(data))
+		},
+	})
+	rtx.Must(err, "Could not create Watcher")
+	if w.Get() != nil {
+		t.Errorf("Get() = %q before any Refresh, want nil", w.Get())
+	}
+
+	wantErrs := []bool{false, false, true, true, false, false}
+	for i, wantErr := range wantErrs {
+		if err := w.Refresh(context.Background()); (err != nil) != wantErr {
+			t.Errorf("Refresh() #%d error = %v, wantErr %v", i, err, wantErr)
+		}
+		if string(w.Get()) != "one" && i < 5 {
+			t.Errorf("Get() after Refresh() #%d = %q, want one", i, w.Get())
+		}
+	}
+	if string(w.Get()) != "two" {
+		t.Errorf("Get() = %q, want two", w.Get())
+	}
+	if len(changes) != 2 || changes[0] != "one" || changes[1] != "two" {
+		t.Errorf("OnChange was called with %q, want [one two]", changes)
+	}
+	// The reader fell behind, so only the newest copy is waiting.
+	if got := string(<-w.Updates()); got != "two" {
+		t.Errorf("<-Updates() = %q, want two", got)
+	}
+	select {
+	case got := <-w.Updates():
+		t.Errorf("<-Updates() = %q, want nothing", got)
+	default:
+	}
+}
+
+func TestWatcherRejectThenUnchanged(t *testing.T) {
+	validate := func(data []byte) error {
+		if string(data) == "bad" {
+			return errors.New("bad data")
+		}
+		return nil
+	}
+
+	// A Provider that records the rejected copy as seen.
+	p := &sequenceProvider{
+		results: []result{{data: "bad"}, {err: ErrNoChange}, {data: "good"}, {err: ErrNoChange}},
+	}
+	w, err := NewWatcher(p, WatcherConfig{
+		Name:     "TestWatcherRejectThenUnchanged",
+		Schedule: memoryless.Config{Expected: time.Second},
+		Validate: validate,
+	})
+	rtx.Must(err, "Could not create Watcher")
+	for i, wantErr := range []bool{true, true, false, false} {
+		if err := w.Refresh(context.Background()); (err != nil) != wantErr {
+			t.Errorf("Refresh() #%d error = %v, wantErr %v", i, err, wantErr)
+		}
+	}
+
+	// A file Provider of this package fetches the rejected copy again.
+	name := path.Join(t.TempDir(), "data")
+	rtx.Must(ioutil.WriteFile(name, []byte("bad"), 0644), "Could not write file")
+	f, err := FromURL(context.Background(), &url.URL{Scheme: "file", Path: name})
+	rtx.Must(err, "Could not create Provider")
+	w, err = NewWatcher(f, WatcherConfig{
+		Name:     "TestWatcherRejectThenUnchangedFile",
+		Schedule: memoryless.Config{Expected: time.Second},
+		Validate: validate,
+	})
+	rtx.Must(err, "Could not create Watcher")
+	for i := 0; i < 2; i++ {
+		err := w.Refresh(context.Background())
+		if err == nil || errors.Is(err, ErrNoChange) {
+			t.Errorf("Refresh() #%d error = %v, want the validation error", i, err)
+		}
+	}
+	rtx.Must(ioutil.WriteFile(name, []byte("good"), 0644), "Could not write file")
+	if err := w.Refresh(context.Background()); err != nil || string(w.Get()) != "good" {
+		t.Errorf("Refresh() = %v and Get() = %q, want nil and good", err, w.Get())
+	}
+}
+
+func TestWatcherWatch(t *testing.T) {
+	p := &sequenceProvider{
+		results: []result{{data: "one"}, {data: "two"}},
+	}
+	w, err := NewWatcher(p, WatcherConfig{
+		Name:     "TestWatcherWatch",
+		Schedule: memoryless.Config{Expected: time.Millisecond, Once: true},
+	})
+	rtx.Must(err, "Could not create Watcher")
+	rtx.Must(w.Watch(context.Background()), "Could not Watch")
+	if p.calls != 2 || string(w.Get()) != "two" {
+		t.Errorf("Watch() made %d calls and got %q, want 2 calls and two", p.calls, w.Get())
+	}
+}
+
+func TestNewWatcherBadSchedule(t *testing.T) {
+	_, err := NewWatcher(&sequenceProvider{}, WatcherConfig{
+		Schedule: memoryless.Config{Expected: -1},
+	})
+	if err == nil {
+		t.Error("NewWatcher() should have failed with a negative schedule")
+	}
+}
+
+func TestWatcherMetrics(t *testing.T) {
+	p := &sequenceProvider{
+		results: []result{{data: "one"}, {err: errors.New("fail")}, {data: "bad"}},
+	}
+	w, err := NewWatcher(p, WatcherConfig{
+		Name: "TestWatcherMetrics",
+		Validate: func(data []byte) error {
+			if string(data) == "bad" {
+				return errors.New("bad data")
+			}
+			return nil
+		},
+	})
+	rtx.Must(err, "Could not create Watcher")
+	for i := 0; i < len(p.results); i++ {
+		w.Refresh(context.Background())
+	}
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"fetch", testutil.ToFloat64(fetchFailures.WithLabelValues("TestWatcherMetrics", "fetch")), 1},
+		{"invalid", testutil.ToFloat64(fetchFailures.WithLabelValues("TestWatcherMetrics", "invalid")), 1},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s failures = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if testutil.ToFloat64(lastSuccess.WithLabelValues("TestWatcherMetrics")) == 0 {
+		t.Error("The last success timestamp was not set")
+	}
+	promtest.LintMetrics(t)
+}
@@ -0,0 +1,80 @@
+package memoryless
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Clock is the source of time used by a Ticker. Tests may provide a fake Clock
+// through Config to control exactly when a Ticker wakes up, e.g. the FakeClock
+// in the memorylesstest package.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer creates a Timer that sends the current time on its channel after
+	// at least duration d.
+	NewTimer(d time.Duration) Timer
+	// AfterFunc creates a Timer that calls f after at least duration d.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is the interface of a time.Timer created by a Clock.
+type Timer interface {
+	// C returns the channel on which the time is delivered. Timers created by
+	// Clock.AfterFunc return a nil channel.
+	C() <-chan time.Time
+	// Stop prevents the Timer from firing. It returns false if the Timer has
+	// already fired or been stopped.
+	Stop() bool
+	// Reset changes the Timer to fire after duration d. It returns true if the
+	// Timer had been active.
+	Reset(d time.Duration) bool
+}
+
+// Rand is the source of random numbers used to choose wait times. A
+// *rand.Rand satisfies Rand, but is not safe for concurrent use, so it should
+// not be shared between Tickers.
+type Rand interface {
+	// ExpFloat64 returns an exponentially distributed float64 with rate 1.
+	ExpFloat64() float64
+	// Float64 returns a uniformly distributed float64 in [0.0, 1.0).
+	Float64() float64
+}
+
+// SystemClock is the Clock used when Config.Clock is nil. It uses the time
+// package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// globalRand uses the top-level math/rand functions, which are safe for
+// concurrent use.
+type globalRand struct{}
+
+func (globalRand) ExpFloat64() float64 {
+	return rand.ExpFloat64()
+}
+
+func (globalRand) Float64() float64 {
+	return rand.Float64()
+}
@@ -0,0 +1,174 @@
+package memoryless
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Distribution chooses the time to wait between runs of the function. The
+// default, used when Config.Distribution is nil, is Exponential with a Mean of
+// Config.Expected.
+type Distribution interface {
+	// Sample returns a random wait time beginning at now, using r as the source
+	// of randomness.
+	Sample(now time.Time, r Rand) time.Duration
+	// Check returns an error if the distribution parameters make no sense.
+	Check() error
+}
+
+// Exponential is the exponential distribution, which produces a memoryless
+// (Poisson) sequence of events.
+type Exponential struct {
+	Mean time.Duration
+}
+
+// Sample returns an exponentially distributed wait time.
+func (e Exponential) Sample(now time.Time, r Rand) time.Duration {
+	return time.Duration(r.ExpFloat64() * float64(e.Mean))
+}
+
+// Check returns an error if the Mean is negative.
+func (e Exponential) Check() error {
+	if e.Mean < 0 {
+		return fmt.Errorf("Exponential Mean(%v) must not be negative.", e.Mean)
+	}
+	return nil
+}
+
+// Uniform is the uniform distribution over [Period-Jitter, Period+Jitter). It
+// is not memoryless, but is useful for spreading out periodic work.
+type Uniform struct {
+	Period time.Duration
+	Jitter time.Duration
+}
+
+// Sample returns a uniformly distributed wait time.
+func (u Uniform) Sample(now time.Time, r Rand) time.Duration {
+	return u.Period - u.Jitter + time.Duration(r.Float64()*float64(2*u.Jitter))
+}
+
+// Check returns an error unless 0 <= Jitter <= Period.
+func (u Uniform) Check() error {
+	if !(0 <= u.Jitter && u.Jitter <= u.Period) {
+		return fmt.Errorf("Uniform requires 0 <= Jitter <= Period, but that is not true for Jitter(%v) Period(%v).",
+			u.Jitter, u.Period)
+	}
+	return nil
+}
+
+// Mean returns the mean wait time, which is Period.
+func (u Uniform) Mean() time.Duration {
+	return u.Period
+}
+
+// TruncatedExponential is the exponential distribution with mean Expected,
+// restricted to wait times between Min and Max. If Max is zero, there is no
+// upper bound. Unlike clamping with Config.Min and Config.Max, values are
+// sampled exactly from the truncated distribution, so no probability
+// accumulates at the bounds. Because the exponential distribution is
+// memoryless, the wait time is Min plus an exponentially distributed time,
+// truncated at Max-Min.
+type TruncatedExponential struct {
+	Expected time.Duration
+	Min      time.Duration
+	Max      time.Duration
+}
+
+// Sample returns a wait time from the truncated exponential distribution,
+// using inverse transform sampling.
+func (t TruncatedExponential) Sample(now time.Time, r Rand) time.Duration {
+	e := float64(t.Expected)
+	if t.Max == 0 {
+		return t.Min + time.Duration(r.ExpFloat64()*e)
+	}
+	p := -math.Expm1(-float64(t.Max-t.Min) / e)
+	return t.Min + time.Duration(-e*math.Log1p(-r.Float64()*p))
+}
+
+// Check returns an error unless 0 <= Min < Max (or Max is 0) and Expected is
+// positive.
+func (t TruncatedExponential) Check() error {
+	if !(0 < t.Expected && 0 <= t.Min && (t.Max == 0 || t.Min < t.Max)) {
+		return fmt.Errorf(
+			"TruncatedExponential requires 0 < Expected and 0 <= Min < Max (or Max is 0), "+
+				"but that is not true for Min(%v) Expected(%v) Max(%v).",
+			t.Min, t.Expected, t.Max)
+	}
+	return nil
+}
+
+// Mean returns the mean wait time. It is less than Min+Expected when Max is
+// set.
+func (t TruncatedExponential) Mean() time.Duration {
+	e := float64(t.Expected)
+	if t.Max == 0 {
+		return t.Min + t.Expected
+	}
+	w := float64(t.Max - t.Min)
+	return t.Min + time.Duration(e-w/math.Expm1(w/e))
+}
+
+// Diurnal is a Poisson process whose rate changes with the hour of the day,
+// e.g. to measure more often during busy hours. Rates[h] is the expected number
+// of events per hour between h:00 and h+1:00 in Location. Within each hour the
+// events are memoryless. If Location is nil, UTC is used.
+type Diurnal struct {
+	Rates    [24]float64
+	Location *time.Location
+}
+
+// Sample returns the wait time until the next event of the non-homogeneous
+// Poisson process, beginning at now.
+func (d Diurnal) Sample(now time.Time, r Rand) time.Duration {
+	loc := d.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	// The wait ends once the integral of the rate over the wait reaches an
+	// exponentially distributed amount with rate 1.
+	remaining := r.ExpFloat64()
+	t := now.In(loc)
+	var wait time.Duration
+	for {
+		// Find the end of the local hour. Unlike time.Date, this always moves
+		// forward, even across daylight saving time changes.
+		span := time.Hour - time.Duration(t.Minute())*time.Minute -
+			time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond())
+		end := t.Add(span)
+		rate := d.Rates[t.Hour()]
+		events := rate * span.Hours()
+		if rate > 0 && events >= remaining {
+			return wait + time.Duration(remaining/rate*float64(time.Hour))
+		}
+		remaining -= events
+		wait += span
+		t = end
+	}
+}
+
+// Check returns an error if any rate is negative or if every rate is zero.
+func (d Diurnal) Check() error {
+	total := 0.0
+	for h, rate := range d.Rates {
+		if rate < 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+			return fmt.Errorf("Diurnal Rates[%d](%v) must be a non-negative number.", h, rate)
+		}
+		total += rate
+	}
+	if total == 0 {
+		return errors.New("Diurnal Rates must not all be zero.")
+	}
+	return nil
+}
+
+// Mean returns the mean wait time over a whole day, i.e. 24 hours divided by
+// the expected number of events per day.
+func (d Diurnal) Mean() time.Duration {
+	total := 0.0
+	for _, rate := range d.Rates {
+		total += rate
+	}
+	return time.Duration(24 / total * float64(time.Hour))
+}
@@ -0,0 +1,171 @@
+package memoryless_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/memoryless"
+	"github.com/m-lab/go/memoryless/memorylesstest"
+	"github.com/m-lab/go/rtx"
+)
+
+func TestDistributionMeans(t *testing.T) {
+	var busy [24]float64
+	for h := 8; h < 20; h++ {
+		busy[h] = 6
+	}
+	busy[0] = 1
+	tests := []struct {
+		name     string
+		dist     memoryless.Distribution
+		wantMean time.Duration
+		min, max time.Duration
+	}{
+		{
+			name:     "exponential",
+			dist:     memoryless.Exponential{Mean: time.Second},
+			wantMean: time.Second,
+		},
+		{
+			name:     "uniform",
+			dist:     memoryless.Uniform{Period: 10 * time.Second, Jitter: 5 * time.Second},
+			wantMean: memoryless.Uniform{Period: 10 * time.Second}.Mean(),
+			min:      5 * time.Second,
+			max:      15 * time.Second,
+		},
+		{
+			name:     "truncated-exponential",
+			dist:     memoryless.TruncatedExponential{Expected: time.Second, Min: 100 * time.Millisecond, Max: 2 * time.Second},
+			wantMean: memoryless.TruncatedExponential{Expected: time.Second, Min: 100 * time.Millisecond, Max: 2 * time.Second}.Mean(),
+			min:      100 * time.Millisecond,
+			max:      2 * time.Second,
+		},
+		{
+			name:     "truncated-exponential-no-max",
+			dist:     memoryless.TruncatedExponential{Expected: time.Second, Min: time.Second},
+			wantMean: 2 * time.Second,
+			min:      time.Second,
+		},
+		{
+			name:     "diurnal",
+			dist:     memoryless.Diurnal{Rates: busy},
+			wantMean: memoryless.Diurnal{Rates: busy}.Mean(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rtx.Must(tt.dist.Check(), "Bad distribution")
+			r := rand.New(rand.NewSource(1))
+			now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			const n = 100000
+			var total time.Duration
+			for i := 0; i < n; i++ {
+				// Sample a sequence of events, so time-varying distributions
+				// cover every hour.
+				wt := tt.dist.Sample(now, r)
+				if wt < tt.min || (tt.max != 0 && wt > tt.max) {
+					t.Fatalf("Sample() = %v, want a value in [%v, %v]", wt, tt.min, tt.max)
+				}
+				now = now.Add(wt)
+				total += wt
+			}
+			mean := total / n
+			if diff := math.Abs(float64(mean-tt.wantMean)) / float64(tt.wantMean); diff > 0.02 {
+				t.Errorf("Sample() mean = %v, want %v (off by %.1f%%)", mean, tt.wantMean, diff*100)
+			}
+		})
+	}
+}
+
+func TestTruncatedExponentialMean(t *testing.T) {
+	// Truncation must lower the mean below that of the untruncated distribution,
+	// unlike clamping to Max, which pulls values up to Max.
+	d := memoryless.TruncatedExponential{Expected: time.Second, Max: time.Second}
+	want := float64(time.Second) * (1 - 1/(math.E-1))
+	if got := d.Mean(); math.Abs(float64(got)-want) > 1 {
+		t.Errorf("Mean() = %v, want %v", got, time.Duration(want))
+	}
+}
+
+func TestDiurnalQuietHours(t *testing.T) {
+	var rates [24]float64
+	rates[9] = 60
+	rates[17] = 60
+	nyc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone data is not available:", err)
+	}
+	d := memoryless.Diurnal{Rates: rates, Location: nyc}
+	r := rand.New(rand.NewSource(1))
+	now := time.Date(2020, 3, 7, 0, 0, 0, 0, nyc)
+	for i := 0; i < 10000; i++ {
+		now = now.Add(d.Sample(now, r))
+		if h := now.In(nyc).Hour(); h != 9 && h != 17 {
+			t.Fatalf("Event at %v is outside the busy hours", now.In(nyc))
+		}
+	}
+}
+
+func TestDistributionCheck(t *testing.T) {
+	var zero, negative [24]float64
+	negative[3] = -1
+	for _, d := range []memoryless.Distribution{
+		memoryless.Exponential{Mean: -1},
+		memoryless.Uniform{Period: time.Second, Jitter: 2 * time.Second},
+		memoryless.Uniform{Period: time.Second, Jitter: -1},
+		memoryless.TruncatedExponential{},
+		memoryless.TruncatedExponential{Expected: time.Second, Min: 2, Max: 1},
+		memoryless.TruncatedExponential{Expected: time.Second, Min: -1},
+		memoryless.Diurnal{Rates: zero},
+		memoryless.Diurnal{Rates: negative},
+	} {
+		if d.Check() == nil {
+			t.Errorf("Check() should fail for %+v", d)
+		}
+		c := memoryless.Config{Distribution: d}
+		if c.Check() == nil {
+			t.Errorf("Config.Check() should fail for %+v", d)
+		}
+	}
+	for _, c := range []memoryless.Config{
+		{Min: 2, Max: 1, Distribution: memoryless.Exponential{Mean: 1}},
+		{Min: -1, Distribution: memoryless.Exponential{Mean: 1}},
+	} {
+		if c.Check() == nil {
+			t.Errorf("Config.Check() should fail for %+v", c)
+		}
+	}
+	// Expected is ignored when a Distribution is given.
+	c := memoryless.Config{Min: time.Second, Distribution: memoryless.Exponential{Mean: time.Minute}}
+	rtx.Must(c.Check(), "Config with Distribution should be valid")
+}
+
+func TestConfigDistribution(t *testing.T) {
+	start := time.Now()
+	clock := memorylesstest.NewFakeClock(start)
+	for _, tt := range []struct {
+		config memoryless.Config
+		want   time.Duration
+	}{
+		{
+			config: memoryless.Config{Distribution: memoryless.Uniform{Period: time.Minute, Jitter: 10 * time.Second}},
+			want:   time.Minute,
+		},
+		{
+			// Min and Max still clamp the distribution.
+			config: memoryless.Config{Max: 30 * time.Second, Distribution: memoryless.Uniform{Period: time.Minute}},
+			want:   30 * time.Second,
+		},
+	} {
+		tt.config.Clock = clock
+		tt.config.Rand = memorylesstest.NewRand(0.5)
+		timer, err := memoryless.NewClockTimer(tt.config)
+		rtx.Must(err, "Could not make timer")
+		if got := clock.Deadlines(); len(got) != 1 || got[0].Sub(start) != tt.want {
+			t.Errorf("NewClockTimer() deadlines = %v, want %v", got, start.Add(tt.want))
+		}
+		timer.Stop()
+	}
+}
@@ -0,0 +1,228 @@
+// Package fleet schedules memoryless runs of a function across a whole fleet
+// of instances, such that the aggregate rate of runs stays near a target no
+// matter how many instances are running.
+package fleet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m-lab/go/content"
+	"github.com/m-lab/go/memoryless"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	fleetSize = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "memoryless_fleet_size",
+			Help: "The number of instances sharing a fleet-wide memoryless schedule.",
+		},
+		[]string{"schedule"})
+	fleetTargetRate = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "memoryless_fleet_target_rate",
+			Help: "The target number of runs per second across the whole fleet.",
+		},
+		[]string{"schedule"})
+	fleetInstanceRate = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "memoryless_fleet_instance_rate",
+			Help: "The effective number of runs per second of this instance.",
+		},
+		[]string{"schedule"})
+	fleetExpectedSeconds = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "memoryless_fleet_expected_seconds",
+			Help: "The expected time between runs of this instance.",
+		},
+		[]string{"schedule"})
+)
+
+// DefaultRefresh is how often the fleet size is reloaded when Config.Refresh
+// is zero.
+const DefaultRefresh = time.Minute
+
+// Config describes a fleet-wide schedule.
+type Config struct {
+	// Name labels the Prometheus metrics of the schedule.
+	Name string
+	// Rate is the target number of runs per second, summed over every instance
+	// in the fleet.
+	Rate float64
+	// Size provides the number of instances in the fleet, as a decimal integer.
+	// Use content.FromURL to read it from a file, GCS, or HTTPS.
+	Size content.Provider
+	// Refresh is how often Size is reloaded. If zero, DefaultRefresh is used.
+	Refresh time.Duration
+
+	// MinFactor and MaxFactor, if not zero, clamp every wait to these multiples
+	// of the per-instance expected wait. See memoryless.Config for the bias that
+	// clamping introduces.
+	MinFactor float64
+	MaxFactor float64
+
+	// Clock and Rand are passed on to memoryless.Config.
+	Clock memoryless.Clock
+	Rand  memoryless.Rand
+}
+
+// Scheduler runs a function on one instance of a fleet. Every instance waits
+// an exponentially distributed time with a mean of the fleet size divided by
+// the target Rate, so the aggregate runs form a Poisson process with the
+// target Rate.
+type Scheduler struct {
+	config Config
+	mu     sync.Mutex
+	size   int
+}
+
+// New creates a Scheduler and loads the initial fleet size. It returns an
+// error if the Config makes no sense or if the fleet size cannot be loaded,
+// including when Size reports content.ErrNoChange before any size was read.
+func New(ctx context.Context, c Config) (*Scheduler, error) {
+	if !(c.Rate > 0) {
+		return nil, fmt.Errorf("The fleet Rate(%v) must be positive.", c.Rate)
+	}
+	if c.Size == nil {
+		return nil, errors.New("The fleet Size must be provided.")
+	}
+	if !(0 <= c.MinFactor && c.MinFactor <= 1 && (c.MaxFactor == 0 || c.MaxFactor >= 1)) {
+		return nil, fmt.Errorf(
+			"It should be true that 0 <= MinFactor <= 1 <= MaxFactor (or MaxFactor is 0), "+
+				"but that is not true for MinFactor(%v) MaxFactor(%v).",
+			c.MinFactor, c.MaxFactor)
+	}
+	s := &Scheduler{config: c}
+	if _, err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	if s.Size() == 0 {
+		// A Provider reports ErrNoChange if it was already read elsewhere.
+		return nil, errors.New("The initial fleet Size was not loaded; it reported no change.")
+	}
+	return s, nil
+}
+
+// Size returns the current fleet size.
+func (s *Scheduler) Size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Expected returns the current expected time between runs on this instance.
+func (s *Scheduler) Expected() time.Duration {
+	return time.Duration(float64(s.Size()) / s.config.Rate * float64(time.Second))
+}
+
+// memorylessConfig returns the memoryless.Config for the next wait.
+func (s *Scheduler) memorylessConfig() memoryless.Config {
+	e := s.Expected()
+	return memoryless.Config{
+		Expected: e,
+		Min:      time.Duration(s.config.MinFactor * float64(e)),
+		Max:      time.Duration(s.config.MaxFactor * float64(e)),
+		Clock:    s.config.Clock,
+		Rand:     s.config.Rand,
+	}
+}
+
+// load reads the fleet size and reports whether it changed.
+func (s *Scheduler) load(ctx context.Context) (bool, error) {
+	b, err := s.config.Size.Get(ctx)
+	if errors.Is(err, content.ErrNoChange) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return false, fmt.Errorf("Could not parse fleet size: %w", err)
+	}
+	if size < 1 {
+		return false, fmt.Errorf("The fleet size(%d) must be positive.", size)
+	}
+	s.mu.Lock()
+	changed := size != s.size
+	s.size = size
+	s.mu.Unlock()
+
+	name := s.config.Name
+	fleetSize.WithLabelValues(name).Set(float64(size))
+	fleetTargetRate.WithLabelValues(name).Set(s.config.Rate)
+	fleetInstanceRate.WithLabelValues(name).Set(s.config.Rate / float64(size))
+	fleetExpectedSeconds.WithLabelValues(name).Set(s.Expected().Seconds())
+	return changed, nil
+}
+
+// refresh reloads the fleet size every Refresh period until the context is
+// canceled, and signals changes on the given channel. Errors are logged and
+// the previous size is kept.
+func (s *Scheduler) refresh(ctx context.Context, changed chan<- struct{}) {
+	clock := s.config.Clock
+	if clock == nil {
+		clock = memoryless.SystemClock
+	}
+	period := s.config.Refresh
+	if period == 0 {
+		period = DefaultRefresh
+	}
+	for {
+		timer := clock.NewTimer(period)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+		ok, err := s.load(ctx)
+		if err != nil {
+			log.Println("Could not reload fleet size:", err)
+			continue
+		}
+		if ok {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Run calls f repeatedly until the context is canceled, waiting a memoryless
+// time between calls. When the fleet size changes, the current wait is
+// replaced by a new one with the new expected time. Because the exponential
+// distribution is memoryless, this keeps the runs a Poisson process.
+func (s *Scheduler) Run(ctx context.Context, f func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+	defer cancel()
+
+	changed := make(chan struct{}, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.refresh(ctx, changed)
+	}()
+	for ctx.Err() == nil {
+		// The config is valid by construction, so there is no error.
+		timer, _ := memoryless.NewClockTimer(s.memorylessConfig())
+		select {
+		case <-ctx.Done():
+		case <-changed:
+		case <-timer.C():
+			f()
+		}
+		timer.Stop()
+	}
+}
@@ -0,0 +1,186 @@
+package fleet_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/content"
+	"github.com/m-lab/go/memoryless/fleet"
+	"github.com/m-lab/go/memoryless/memorylesstest"
+	"github.com/m-lab/go/prometheusx/promtest"
+	"github.com/m-lab/go/rtx"
+)
+
+// fakeSize is a content.Provider of a fleet size.
+type fakeSize struct {
+	mu   sync.Mutex
+	data string
+	err  error
+}
+
+func (f *fakeSize) Get(ctx context.Context) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return []byte(f.data), f.err
+}
+
+func (f *fakeSize) set(data string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data, f.err = data, err
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  fleet.Config
+		want    time.Duration
+		wantErr bool
+	}{
+		{
+			name:   "success",
+			config: fleet.Config{Rate: 2, Size: &fakeSize{data: "10\n"}},
+			want:   5 * time.Second,
+		},
+		{
+			name:   "factors",
+			config: fleet.Config{Rate: 0.5, Size: &fakeSize{data: "1"}, MinFactor: 0.1, MaxFactor: 2.5},
+			want:   2 * time.Second,
+		},
+		{
+			name:    "bad-rate",
+			config:  fleet.Config{Rate: 0, Size: &fakeSize{data: "10"}},
+			wantErr: true,
+		},
+		{
+			name:    "missing-size",
+			config:  fleet.Config{Rate: 1},
+			wantErr: true,
+		},
+		{
+			name:    "bad-factors",
+			config:  fleet.Config{Rate: 1, Size: &fakeSize{data: "10"}, MinFactor: 2},
+			wantErr: true,
+		},
+		{
+			name:    "size-error",
+			config:  fleet.Config{Rate: 1, Size: &fakeSize{err: errors.New("fail")}},
+			wantErr: true,
+		},
+		{
+			name:    "size-not-a-number",
+			config:  fleet.Config{Rate: 1, Size: &fakeSize{data: "many"}},
+			wantErr: true,
+		},
+		{
+			name:    "size-no-change",
+			config:  fleet.Config{Rate: 1, Size: &fakeSize{err: content.ErrNoChange}},
+			wantErr: true,
+		},
+		{
+			name:    "size-empty",
+			config:  fleet.Config{Rate: 1, Size: &fakeSize{data: "\n"}},
+			wantErr: true,
+		},
+		{
+			name:    "size-zero",
+			config:  fleet.Config{Rate: 1, Size: &fakeSize{data: "0"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Name = tt.name
+			s, err := fleet.New(context.Background(), tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() wrong error; got %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if s.Expected() != tt.want {
+				t.Errorf("Expected() = %v, want %v", s.Expected(), tt.want)
+			}
+		})
+	}
+}
+
+// waitForDeadlines waits until the clock has exactly the wanted deadlines.
+func waitForDeadlines(t *testing.T, clock *memorylesstest.FakeClock, want ...time.Time) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		got := clock.Deadlines()
+		if len(got) != len(want) {
+			continue
+		}
+		match := true
+		for i := range want {
+			match = match && got[i].Equal(want[i])
+		}
+		if match {
+			return
+		}
+	}
+	t.Fatalf("Deadlines() = %v, want %v", clock.Deadlines(), want)
+}
+
+func TestSchedulerRun(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := memorylesstest.NewFakeClock(start)
+	size := &fakeSize{data: "10"}
+	s, err := fleet.New(context.Background(), fleet.Config{
+		Name:    "run",
+		Rate:    2,
+		Size:    size,
+		Refresh: time.Minute,
+		Clock:   clock,
+		// Waits are 1 and then 100 times the expected wait.
+		Rand: memorylesstest.NewRand(1, 100),
+	})
+	rtx.Must(err, "Could not create scheduler")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := make(chan time.Time)
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx, func() { calls <- clock.Now() })
+		close(done)
+	}()
+
+	// Ten instances sharing two runs per second each wait 5s on average.
+	waitForDeadlines(t, clock, start.Add(5*time.Second), start.Add(time.Minute))
+	clock.Advance(5 * time.Second)
+	if got := <-calls; !got.Equal(start.Add(5 * time.Second)) {
+		t.Errorf("Run() called f at %v, want %v", got, start.Add(5*time.Second))
+	}
+	waitForDeadlines(t, clock, start.Add(time.Minute), start.Add(505*time.Second))
+
+	// When the fleet doubles, the pending wait is replaced by one with twice the
+	// expected time.
+	size.set("20", nil)
+	clock.Advance(55 * time.Second)
+	waitForDeadlines(t, clock, start.Add(2*time.Minute), start.Add(1060*time.Second))
+	if s.Expected() != 10*time.Second {
+		t.Errorf("Expected() = %v, want 10s", s.Expected())
+	}
+
+	// Errors and unchanged sizes keep the current schedule.
+	size.set("", content.ErrNoChange)
+	clock.Advance(time.Minute)
+	waitForDeadlines(t, clock, start.Add(3*time.Minute), start.Add(1060*time.Second))
+	size.set("", errors.New("fail"))
+	clock.Advance(time.Minute)
+	if s.Size() != 20 {
+		t.Errorf("Size() = %d, want 20", s.Size())
+	}
+
+	cancel()
+	<-done
+}
+
+func TestMetrics(t *testing.T) {
+	promtest.LintMetrics(t)
+}
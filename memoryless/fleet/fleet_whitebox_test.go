@@ -0,0 +1,36 @@
+package fleet
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// staticSize is a content.Provider of a fixed fleet size.
+type staticSize string
+
+func (s staticSize) Get(ctx context.Context) ([]byte, error) {
+	return []byte(s), nil
+}
+
+func TestGauges(t *testing.T) {
+	_, err := New(context.Background(), Config{Name: "gauges", Rate: 4, Size: staticSize("8")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name  string
+		gauge float64
+		want  float64
+	}{
+		{"size", testutil.ToFloat64(fleetSize.WithLabelValues("gauges")), 8},
+		{"target-rate", testutil.ToFloat64(fleetTargetRate.WithLabelValues("gauges")), 4},
+		{"instance-rate", testutil.ToFloat64(fleetInstanceRate.WithLabelValues("gauges")), 0.5},
+		{"expected-seconds", testutil.ToFloat64(fleetExpectedSeconds.WithLabelValues("gauges")), 2},
+	} {
+		if tt.gauge != tt.want {
+			t.Errorf("%s gauge = %v, want %v", tt.name, tt.gauge, tt.want)
+		}
+	}
+}
@@ -5,7 +5,7 @@ package memoryless
 import (
 	"context"
 	"fmt"
-	"math/rand"
+	"log"
 	"time"
 )
 
@@ -15,6 +15,9 @@ import (
 //  0 <= Min <= Expected <= Max (or 0 <= Min <= Expected and Max is 0)
 // If Max is zero or unset, it will be ignored. If Min is zero or unset, it will
 // be ignored.
+//
+// If Distribution is set, Expected is ignored, and a valid config will have:
+//  0 <= Min <= Max (or 0 <= Min and Max is 0)
 type Config struct {
 	// Expected records the expected/mean/average amount of time between runs.
 	Expected time.Duration
@@ -31,10 +34,46 @@ type Config struct {
 	// The zero value of this struct has Once set to false, which means the value
 	// only needs to be set explicitly in codepaths where it might be true.
 	Once bool
+
+	// Clock, if not nil, replaces the system clock for Tickers, NewClockTimer
+	// and ClockAfterFunc. Tests may use a fake Clock to advance time by hand.
+	Clock Clock
+	// Rand, if not nil, replaces the math/rand source of random wait times.
+	Rand Rand
+
+	// Distribution, if not nil, replaces the exponential distribution with mean
+	// Expected. Wait times are still clamped to Min and Max.
+	Distribution Distribution
+
+	// StateFile, if not empty, is the path of a file where a Ticker records the
+	// time of its next tick. A Ticker started with an existing StateFile, e.g.
+	// after a restart, resumes the saved schedule instead of starting over, so
+	// rapid restarts do not bunch up ticks.
+	StateFile string
+	// CatchUp controls what a Ticker with a StateFile does with ticks that came
+	// due while the process was not running.
+	CatchUp CatchUp
+}
+
+func (c Config) clock() Clock {
+	if c.Clock == nil {
+		return SystemClock
+	}
+	return c.Clock
 }
 
-func (c Config) waittime() time.Duration {
-	wt := time.Duration(rand.ExpFloat64() * float64(c.Expected))
+// waittime chooses the time to wait, beginning at now. This function assumes
+// that the config has no errors.
+func (c Config) waittime(now time.Time) time.Duration {
+	r := c.Rand
+	if r == nil {
+		r = globalRand{}
+	}
+	d := c.Distribution
+	if d == nil {
+		d = Exponential{Mean: c.Expected}
+	}
+	wt := d.Sample(now, r)
 	if wt < c.Min {
 		wt = c.Min
 	}
@@ -47,6 +86,18 @@ func (c Config) waittime() time.Duration {
 // Check whether the config contrains sensible values. It return an error if the
 // config makes no mathematical sense, and nil if everything is okay.
 func (c Config) Check() error {
+	if c.CatchUp < CatchUpSkip || c.CatchUp > CatchUpAll {
+		return fmt.Errorf("The CatchUp(%d) policy is unknown.", c.CatchUp)
+	}
+	if c.Distribution != nil {
+		if !(0 <= c.Min && (c.Max == 0 || c.Min <= c.Max)) {
+			return fmt.Errorf(
+				"The arguments to Run make no sense. It should be true that Min <= Max (or Max is 0), "+
+					"but that is not true for Min(%v) Max(%v).",
+				c.Min, c.Max)
+		}
+		return c.Distribution.Check()
+	}
 	if !(0 <= c.Min && c.Min <= c.Expected && (c.Max == 0 || c.Expected <= c.Max)) {
 		return fmt.Errorf(
 			"The arguments to Run make no sense. It should be true that Min <= Expected <= Max (or Min <= Expected and Max is 0), "+
@@ -56,36 +107,66 @@ func (c Config) Check() error {
 	return nil
 }
 
-// newTimer constructs and returns a timer. This function assumes that the
-// config has no errors.
-func newTimer(c Config) *time.Timer {
-	return time.NewTimer(c.waittime())
+// checkSystemClock checks the config like Check, and also that it does not set
+// a Clock, which the time.Timer functions cannot use instead of alt.
+func (c Config) checkSystemClock(alt string) error {
+	if err := c.Check(); err != nil {
+		return err
+	}
+	if _, ok := c.clock().(systemClock); !ok {
+		return fmt.Errorf("A time.Timer can not use Config.Clock(%T); use %s instead.", c.Clock, alt)
+	}
+	return nil
 }
 
 // NewTimer constructs a single-shot time.Timer that, if repeatedly used to
 // construct a series of timers, will ensure that the resulting events conform
 // to the memoryless distribution. For more on how this could and should be
 // used, see the comments to Ticker. It is intended to be a drop-in replacement
-// for time.NewTimer.
+// for time.NewTimer, so it always uses the system clock, and returns an error if
+// Config.Clock is set to any other Clock. Use NewClockTimer to respect
+// Config.Clock.
 func NewTimer(c Config) (*time.Timer, error) {
+	if err := c.checkSystemClock("NewClockTimer"); err != nil {
+		return nil, err
+	}
+
+	return time.NewTimer(c.waittime(time.Now())), nil
+}
+
+// NewClockTimer is like NewTimer, but creates the Timer using Config.Clock.
+func NewClockTimer(c Config) (Timer, error) {
 	if err := c.Check(); err != nil {
 		return nil, err
 	}
 
-	return newTimer(c), nil
+	clock := c.clock()
+	return clock.NewTimer(c.waittime(clock.Now())), nil
 }
 
 // AfterFunc constructs a single-shot time.Timer that, if repeatedly used to
 // construct a series of timers, will ensure that the resulting events conform
 // to the memoryless distribution. For more on how this could and should be
 // used, see the comments to Ticker. It is intended to be a drop-in replacement
-// for time.AfterFunc.
+// for time.AfterFunc, so it always uses the system clock, and returns an error
+// if Config.Clock is set to any other Clock. Use ClockAfterFunc to respect
+// Config.Clock.
 func AfterFunc(c Config, f func()) (*time.Timer, error) {
+	if err := c.checkSystemClock("ClockAfterFunc"); err != nil {
+		return nil, err
+	}
+
+	return time.AfterFunc(c.waittime(time.Now()), f), nil
+}
+
+// ClockAfterFunc is like AfterFunc, but creates the Timer using Config.Clock.
+func ClockAfterFunc(c Config, f func()) (Timer, error) {
 	if err := c.Check(); err != nil {
 		return nil, err
 	}
 
-	return time.AfterFunc(c.waittime(), f), nil
+	clock := c.clock()
+	return clock.AfterFunc(c.waittime(clock.Now()), f), nil
 }
 
 // Ticker is a struct that waits a config.Expected amount of time on average
@@ -122,15 +203,43 @@ func AfterFunc(c Config, f func()) (*time.Timer, error) {
 // some pathological cases we would like to avoid. In particular, queuing can
 // cause long-term correlations if the queue gets big, which is the exact
 // opposite of what a memoryless system is trying to achieve.
+//
+// If config.StateFile is set, the time of the next tick is saved before each
+// wait, and a new Ticker resumes the saved schedule. Ticks missed while no
+// Ticker was running are handled according to config.CatchUp. Unlike other
+// ticks, missed ticks are delivered with blocking writes, each carrying the
+// time it was scheduled.
 type Ticker struct {
 	C         <-chan time.Time // The channel on which the ticks are delivered.
 	config    Config
 	writeChan chan<- time.Time
 	cancel    func()
+	next      time.Time   // The resumed time of the next tick, if not zero.
+	missed    []time.Time // Missed ticks to deliver first.
+}
+
+// schedule returns the time of the next tick and saves it to the StateFile.
+func (t *Ticker) schedule() time.Time {
+	if !t.next.IsZero() {
+		// The resumed time was saved by resume.
+		next := t.next
+		t.next = time.Time{}
+		return next
+	}
+	now := t.config.clock().Now()
+	next := now.Add(t.config.waittime(now))
+	if t.config.StateFile != "" {
+		if err := writeState(t.config.StateFile, next); err != nil {
+			log.Println("Could not save memoryless state:", err)
+		}
+	}
+	return next
 }
 
 func (t *Ticker) singleIteration(ctx context.Context) {
-	timer := newTimer(t.config)
+	clock := t.config.clock()
+	next := t.schedule()
+	timer := clock.NewTimer(next.Sub(clock.Now()))
 	defer timer.Stop()
 	// Wait until the timer is done or the context is canceled. If both conditions
 	// are true, which case gets called is unspecified.
@@ -142,14 +251,14 @@ func (t *Ticker) singleIteration(ctx context.Context) {
 		// that it could be true that the timer is done AND the context is canceled,
 		// and we have no guarantee that in that case the canceled context case will
 		// be the one that is selected.
-	case <-timer.C:
+	case <-timer.C():
 	}
 	// Just like time.Ticker, writes to the channel are non-blocking. If a user of
 	// this module can't keep up with the timer they set, that's on them. There
 	// are some potential pathological cases associated with queueing events in
 	// the channel, and we want to avoid them.
 	select {
-	case t.writeChan <- time.Now():
+	case t.writeChan <- clock.Now():
 	default:
 	}
 }
@@ -158,6 +267,14 @@ func (t *Ticker) runTicker(ctx context.Context) {
 	// No matter what, when this function exits the channel should never be written to again.
 	defer close(t.writeChan)
 
+	for _, m := range t.missed {
+		select {
+		case t.writeChan <- m:
+		case <-ctx.Done():
+			return
+		}
+	}
+
 	if t.config.Once {
 		if ctx.Err() == nil {
 			t.singleIteration(ctx)
@@ -188,20 +305,27 @@ func NewTicker(ctx context.Context, config Config) (*Ticker, error) {
 		return nil, err
 	}
 	c := make(chan time.Time)
-	ctx, cancel := context.WithCancel(ctx)
 	ticker := &Ticker{
 		C:         c,
 		config:    config,
 		writeChan: c,
-		cancel:    cancel,
 	}
+	if config.StateFile != "" {
+		var err error
+		ticker.next, ticker.missed, err = config.resume(config.clock().Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+	ctx, ticker.cancel = context.WithCancel(ctx)
 	go ticker.runTicker(ctx)
 	return ticker, nil
 }
 
 // Run calls the given function repeatedly, using a memoryless.Ticker to wait
 // between function calls. It is a convenience function for code that does not
-// want to use the channel interface.
+// want to use the channel interface. Because f is called synchronously, a slow
+// f delays later ticks; use RunContext if that matters.
 func Run(ctx context.Context, f func(), c Config) error {
 	ticker, err := MakeTicker(ctx, c)
 	if err != nil {
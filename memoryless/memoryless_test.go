@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/m-lab/go/memoryless"
+	"github.com/m-lab/go/memoryless/memorylesstest"
 	"github.com/m-lab/go/rtx"
 )
 
@@ -151,3 +152,124 @@ func TestAfterFunc(t *testing.T) {
 		t.Error("It should be:", start, "<=", funcTime, "<=", end)
 	}
 }
+
+func TestTickerFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := memorylesstest.NewFakeClock(start)
+	config := memoryless.Config{
+		Expected: 10 * time.Second,
+		Min:      2 * time.Second,
+		Max:      30 * time.Second,
+		Clock:    clock,
+		// Wait times are 1s (clamped to Min), 15s, and 50s (clamped to Max).
+		Rand: memorylesstest.NewRand(0.1, 1.5, 5),
+	}
+	ticker, err := memoryless.NewTicker(context.Background(), config)
+	rtx.Must(err, "Could not make ticker")
+	defer ticker.Stop()
+
+	var mu sync.Mutex
+	var ticks []time.Time
+	done := make(chan struct{})
+	go func() {
+		for tick := range ticker.C {
+			mu.Lock()
+			ticks = append(ticks, tick)
+			mu.Unlock()
+		}
+		close(done)
+	}()
+	var wakeups []time.Time
+	for _, want := range []time.Duration{2 * time.Second, 15 * time.Second, 30 * time.Second, 30 * time.Second} {
+		clock.BlockUntil(1)
+		// Ticks are dropped if the receiver is not ready, so check the wake-up
+		// time of the ticker itself.
+		deadlines := clock.Deadlines()
+		if len(deadlines) != 1 || deadlines[0].Sub(clock.Now()) != want {
+			t.Fatalf("Ticker should wait %v; got deadlines %v at %v", want, deadlines, clock.Now())
+		}
+		wakeups = append(wakeups, deadlines[0])
+		clock.Advance(want)
+	}
+	ticker.Stop()
+	// Like cancellation, Stop may deliver one last tick before the channel is
+	// closed, but it must not wait for time to pass.
+	<-done
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ticks) == 0 {
+		t.Error("Ticker did not deliver any ticks")
+	}
+	for _, tick := range ticks {
+		found := false
+		for _, w := range wakeups {
+			found = found || tick.Equal(w)
+		}
+		if !found {
+			t.Errorf("Ticker delivered %v, want one of %v", tick, wakeups)
+		}
+	}
+}
+
+func TestTickerCancelFakeClock(t *testing.T) {
+	clock := memorylesstest.NewFakeClock(time.Now())
+	ctx, cancel := context.WithCancel(context.Background())
+	ticker, err := memoryless.NewTicker(ctx, memoryless.Config{Expected: time.Minute, Clock: clock})
+	rtx.Must(err, "Could not make ticker")
+	clock.BlockUntil(1)
+	cancel()
+	// The ticker may or may not deliver one last tick when the context is
+	// canceled, but it must stop and close the channel without time passing.
+	for range ticker.C {
+	}
+	if clock.Timers() != 0 {
+		t.Errorf("Ticker should stop its timer; %d timers remain", clock.Timers())
+	}
+}
+
+func TestClockTimers(t *testing.T) {
+	start := time.Now()
+	clock := memorylesstest.NewFakeClock(start)
+	config := memoryless.Config{Expected: time.Second, Clock: clock, Rand: memorylesstest.NewRand(2)}
+
+	timer, err := memoryless.NewClockTimer(config)
+	rtx.Must(err, "Could not make timer")
+	called := false
+	_, err = memoryless.ClockAfterFunc(config, func() { called = clock.Now().Equal(start.Add(2 * time.Second)) })
+	rtx.Must(err, "Could not make timer")
+
+	clock.Advance(time.Second)
+	select {
+	case <-timer.C():
+		t.Error("Timer fired early")
+	default:
+	}
+	clock.Advance(time.Second)
+	if got := <-timer.C(); !got.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("Timer fired at %v, want %v", got, start.Add(2*time.Second))
+	}
+	if !called {
+		t.Error("AfterFunc was not called at the right time")
+	}
+
+	_, err = memoryless.NewTimer(config)
+	if err == nil {
+		t.Error("NewTimer should fail with a fake Clock")
+	}
+	_, err = memoryless.AfterFunc(config, func() {})
+	if err == nil {
+		t.Error("AfterFunc should fail with a fake Clock")
+	}
+	timer2, err := memoryless.NewTimer(memoryless.Config{Expected: time.Second, Clock: memoryless.SystemClock})
+	rtx.Must(err, "Could not make timer with the SystemClock")
+	timer2.Stop()
+
+	_, err = memoryless.NewClockTimer(memoryless.Config{Expected: -1})
+	if err == nil {
+		t.Error("NewClockTimer should fail with a bad config")
+	}
+	_, err = memoryless.ClockAfterFunc(memoryless.Config{Expected: -1}, func() {})
+	if err == nil {
+		t.Error("ClockAfterFunc should fail with a bad config")
+	}
+}
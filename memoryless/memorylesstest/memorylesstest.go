@@ -0,0 +1,213 @@
+// Package memorylesstest provides a fake Clock and Rand for deterministic
+// tests of code that uses memoryless.
+package memorylesstest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/m-lab/go/memoryless"
+)
+
+// FakeClock implements memoryless.Clock. Time only passes when a test calls
+// Advance or Set, which fires every Timer that becomes due, in order.
+type FakeClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFakeClock creates a FakeClock starting at the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	f := &FakeClock{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// Now returns the current fake time.
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// NewTimer creates a Timer that sends the fake time on its channel once the
+// clock is advanced by at least d.
+func (f *FakeClock) NewTimer(d time.Duration) memoryless.Timer {
+	return f.add(d, make(chan time.Time, 1), nil)
+}
+
+// AfterFunc creates a Timer that calls fn once the clock is advanced by at
+// least d. Unlike time.AfterFunc, fn is called synchronously by Advance or Set.
+func (f *FakeClock) AfterFunc(d time.Duration, fn func()) memoryless.Timer {
+	return f.add(d, nil, fn)
+}
+
+func (f *FakeClock) add(d time.Duration, c chan time.Time, fn func()) *fakeTimer {
+	t := &fakeTimer{clock: f, c: c, fn: fn}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.schedule(t, d)
+	return t
+}
+
+// schedule adds t to the pending timers. The caller must hold f.mu.
+func (f *FakeClock) schedule(t *fakeTimer, d time.Duration) {
+	t.when = f.now.Add(d)
+	f.timers = append(f.timers, t)
+	// Stable, so that timers due at the same time fire in creation order.
+	sort.SliceStable(f.timers, func(i, j int) bool {
+		return f.timers[i].when.Before(f.timers[j].when)
+	})
+	f.cond.Broadcast()
+}
+
+// remove removes t from the pending timers and reports whether it was pending.
+// The caller must hold f.mu.
+func (f *FakeClock) remove(t *fakeTimer) bool {
+	for i := range f.timers {
+		if f.timers[i] == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward by d, firing every Timer that is due along
+// the way. While a Timer fires, Now returns the time it was due.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock forward to t, firing every Timer that is due along the
+// way. Set never moves the clock backwards.
+func (f *FakeClock) Set(t time.Time) {
+	for {
+		f.mu.Lock()
+		if len(f.timers) == 0 || f.timers[0].when.After(t) {
+			if t.After(f.now) {
+				f.now = t
+			}
+			f.mu.Unlock()
+			return
+		}
+		next := f.timers[0]
+		f.timers = f.timers[1:]
+		if next.when.After(f.now) {
+			f.now = next.when
+		}
+		now := f.now
+		f.mu.Unlock()
+		next.fire(now)
+	}
+}
+
+// Timers returns the number of Timers waiting to fire.
+func (f *FakeClock) Timers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// Deadlines returns the times at which the waiting Timers will fire, in order.
+// Tests may use Deadlines to check the wait times chosen by a Ticker, even if
+// its ticks are dropped because no receiver is ready.
+func (f *FakeClock) Deadlines() []time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d := make([]time.Time, len(f.timers))
+	for i := range f.timers {
+		d[i] = f.timers[i].when
+	}
+	return d
+}
+
+// BlockUntil waits until at least n Timers are waiting to fire. Tests use
+// BlockUntil to know that a goroutine, such as a Ticker, is waiting on the
+// clock before calling Advance.
+func (f *FakeClock) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.timers) < n {
+		f.cond.Wait()
+	}
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	when  time.Time
+	c     chan time.Time
+	fn    func()
+}
+
+func (t *fakeTimer) fire(now time.Time) {
+	if t.fn != nil {
+		t.fn()
+		return
+	}
+	// Like time.Timer, the channel holds at most one value.
+	select {
+	case t.c <- now:
+	default:
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.clock.remove(t)
+	t.clock.schedule(t, d)
+	return active
+}
+
+// Rand implements memoryless.Rand by returning a fixed sequence of values.
+type Rand struct {
+	mu     sync.Mutex
+	values []float64
+	i      int
+}
+
+// NewRand creates a Rand that returns the given values from ExpFloat64 and
+// Float64 in order. Once all values are used, the last value is repeated. With
+// the default distribution, the wait time chosen by memoryless is the value
+// multiplied by Config.Expected, before clamping to Config.Min and Config.Max.
+// Values returned by Float64 should be in [0.0, 1.0).
+func NewRand(values ...float64) *Rand {
+	if len(values) == 0 {
+		values = []float64{1}
+	}
+	return &Rand{values: values}
+}
+
+// ExpFloat64 returns the next value in the sequence.
+func (r *Rand) ExpFloat64() float64 {
+	return r.next()
+}
+
+// Float64 returns the next value in the sequence.
+func (r *Rand) Float64() float64 {
+	return r.next()
+}
+
+func (r *Rand) next() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v := r.values[r.i]
+	if r.i < len(r.values)-1 {
+		r.i++
+	}
+	return v
+}
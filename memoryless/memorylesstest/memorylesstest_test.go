@@ -0,0 +1,77 @@
+package memorylesstest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/memoryless/memorylesstest"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := memorylesstest.NewFakeClock(start)
+	var fired []string
+	clock.AfterFunc(3*time.Second, func() { fired = append(fired, "c") })
+	clock.AfterFunc(time.Second, func() { fired = append(fired, "a") })
+	b := clock.AfterFunc(time.Second, func() { fired = append(fired, "b") })
+	stopped := clock.AfterFunc(2*time.Second, func() { fired = append(fired, "stopped") })
+	if !stopped.Stop() {
+		t.Error("Stop() should return true for a pending timer")
+	}
+	if stopped.Stop() {
+		t.Error("Stop() should return false for a stopped timer")
+	}
+	clock.Advance(2 * time.Second)
+	if b.Reset(2 * time.Second) {
+		t.Error("Reset() should return false for a fired timer")
+	}
+	clock.Advance(10 * time.Second)
+	want := []string{"a", "b", "c", "b"}
+	if len(fired) != len(want) {
+		t.Fatalf("Timers fired in the wrong order; got %v, want %v", fired, want)
+	}
+	for i := range want {
+		if fired[i] != want[i] {
+			t.Errorf("Timers fired in the wrong order; got %v, want %v", fired, want)
+		}
+	}
+	if got := clock.Now(); !got.Equal(start.Add(12 * time.Second)) {
+		t.Errorf("Now() = %v, want %v", got, start.Add(12*time.Second))
+	}
+	clock.Set(start)
+	if got := clock.Now(); !got.Equal(start.Add(12 * time.Second)) {
+		t.Errorf("Set() should not move the clock backwards; got %v", got)
+	}
+}
+
+func TestFakeClockTimerChannel(t *testing.T) {
+	start := time.Now()
+	clock := memorylesstest.NewFakeClock(start)
+	timer := clock.NewTimer(time.Second)
+	done := make(chan struct{})
+	go func() {
+		clock.BlockUntil(2)
+		clock.Advance(5 * time.Second)
+		close(done)
+	}()
+	clock.NewTimer(time.Minute)
+	<-done
+	if got := <-timer.C(); !got.Equal(start.Add(time.Second)) {
+		t.Errorf("Timer fired at %v, want %v", got, start.Add(time.Second))
+	}
+	if clock.Timers() != 1 {
+		t.Errorf("Timers() = %d, want 1", clock.Timers())
+	}
+}
+
+func TestRand(t *testing.T) {
+	r := memorylesstest.NewRand(0.5, 2)
+	for _, want := range []float64{0.5, 2, 2} {
+		if got := r.ExpFloat64(); got != want {
+			t.Errorf("ExpFloat64() = %v, want %v", got, want)
+		}
+	}
+	if got := memorylesstest.NewRand().ExpFloat64(); got != 1 {
+		t.Errorf("ExpFloat64() = %v, want 1", got)
+	}
+}
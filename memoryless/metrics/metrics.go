@@ -0,0 +1,65 @@
+// Package metrics exports the runs of memoryless.RunContext as Prometheus
+// metrics. It is a separate package so that memoryless does not depend on
+// Prometheus.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	runDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "memoryless_run_duration_seconds",
+			Help:    "The time taken by each run of a memoryless schedule.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 12),
+		},
+		[]string{"schedule"})
+	runSkipped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "memoryless_run_skipped_total",
+			Help: "The number of ticks skipped because earlier runs were still busy.",
+		},
+		[]string{"schedule"})
+	runErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "memoryless_run_errors_total",
+			Help: "The number of runs of a memoryless schedule that returned an error.",
+		},
+		[]string{"schedule"})
+	runCanceled = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "memoryless_run_canceled_total",
+			Help: "The number of runs canceled because a new tick arrived.",
+		},
+		[]string{"schedule"})
+)
+
+// Run implements memoryless.RunMetrics with Prometheus metrics labeled by the
+// schedule name. Use it as the Metrics of a memoryless.RunConfig:
+//
+//	memoryless.RunContext(ctx, f, c, memoryless.RunConfig{Name: "upload", Metrics: metrics.Run{}})
+type Run struct{}
+
+// Ran observes the duration of a run.
+func (Run) Ran(name string, d time.Duration) {
+	runDuration.WithLabelValues(name).Observe(d.Seconds())
+}
+
+// Skipped counts a skipped tick.
+func (Run) Skipped(name string) {
+	runSkipped.WithLabelValues(name).Inc()
+}
+
+// Failed counts a run that returned an error.
+func (Run) Failed(name string) {
+	runErrors.WithLabelValues(name).Inc()
+}
+
+// Canceled counts a run canceled by a new tick.
+func (Run) Canceled(name string) {
+	runCanceled.WithLabelValues(name).Inc()
+}
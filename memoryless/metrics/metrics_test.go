@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/memoryless"
+	"github.com/m-lab/go/prometheusx/promtest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Run must be usable as the Metrics of a RunConfig.
+var _ memoryless.RunMetrics = Run{}
+
+func TestRun(t *testing.T) {
+	r := Run{}
+	r.Ran("test", 2*time.Second)
+	r.Skipped("test")
+	r.Failed("test")
+	r.Failed("test")
+	r.Canceled("test")
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"skipped", testutil.ToFloat64(runSkipped.WithLabelValues("test")), 1},
+		{"errors", testutil.ToFloat64(runErrors.WithLabelValues("test")), 2},
+		{"canceled", testutil.ToFloat64(runCanceled.WithLabelValues("test")), 1},
+		{"durations", float64(testutil.CollectAndCount(runDuration)), 1},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	f := func(ctx context.Context) error { return errors.New("fail") }
+	c := memoryless.Config{Expected: time.Millisecond, Once: true}
+	err := memoryless.RunContext(context.Background(), f, c, memoryless.RunConfig{Name: "runcontext", Metrics: Run{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(runErrors.WithLabelValues("runcontext")); got != 1 {
+		t.Errorf("errors = %v, want 1", got)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	promtest.LintMetrics(t)
+}
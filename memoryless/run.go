@@ -0,0 +1,189 @@
+package memoryless
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RunMetrics records what happens to the ticks and runs of RunContext. Every
+// method is passed the RunConfig.Name of the schedule. The memoryless/metrics
+// package provides an implementation that exports Prometheus metrics.
+type RunMetrics interface {
+	// Ran is called after every run with its duration.
+	Ran(name string, d time.Duration)
+	// Skipped is called for every tick dropped because earlier runs were
+	// still busy.
+	Skipped(name string)
+	// Failed is called for every run that returned an error.
+	Failed(name string)
+	// Canceled is called for every run canceled because a new tick arrived.
+	Canceled(name string)
+}
+
+// noMetrics is the RunMetrics used when RunConfig.Metrics is nil.
+type noMetrics struct{}
+
+func (noMetrics) Ran(string, time.Duration) {}
+func (noMetrics) Skipped(string)            {}
+func (noMetrics) Failed(string)             {}
+func (noMetrics) Canceled(string)           {}
+
+// Overlap controls what RunContext does with a tick that arrives while
+// earlier runs are still busy.
+type Overlap int
+
+const (
+	// OverlapSkip drops ticks while a run is busy. This is the default.
+	OverlapSkip Overlap = iota
+	// OverlapConcurrent starts a new run for every tick, as long as fewer than
+	// RunConfig.Limit runs are busy. Other ticks are dropped.
+	OverlapConcurrent
+	// OverlapCancel cancels the context of the busy run, waits for it to
+	// return, and then starts a new run.
+	OverlapCancel
+)
+
+// RunConfig describes how RunContext calls its function.
+type RunConfig struct {
+	// Name identifies the schedule in log messages and Metrics.
+	Name string
+	// Overlap is the policy for ticks that arrive while a run is busy.
+	Overlap Overlap
+	// Limit is the maximum number of concurrent runs for OverlapConcurrent. It
+	// is ignored by other policies.
+	Limit int
+	// Report, if not nil, is called after every run with its duration and
+	// error. It is called from the goroutine of the run.
+	Report func(d time.Duration, err error)
+	// Metrics, if not nil, records the outcome of every tick and run. Its
+	// methods may be called from several goroutines at once.
+	Metrics RunMetrics
+}
+
+// Check whether the RunConfig makes sense.
+func (r RunConfig) Check() error {
+	if r.Overlap < OverlapSkip || r.Overlap > OverlapCancel {
+		return fmt.Errorf("Unknown Overlap policy(%d)", r.Overlap)
+	}
+	if r.Overlap == OverlapConcurrent && r.Limit < 1 {
+		return fmt.Errorf("The Limit(%d) of concurrent runs must be positive.", r.Limit)
+	}
+	return nil
+}
+
+// metrics returns the RunMetrics of the schedule.
+func (r RunConfig) metrics() RunMetrics {
+	if r.Metrics == nil {
+		return noMetrics{}
+	}
+	return r.Metrics
+}
+
+// limit returns the maximum number of concurrent runs.
+func (r RunConfig) limit() int {
+	if r.Overlap == OverlapConcurrent {
+		return r.Limit
+	}
+	return 1
+}
+
+// RunContext calls f on every tick of a memoryless.Ticker until the context
+// is canceled. Unlike Run, f runs in its own goroutine, so a slow f does not
+// delay later ticks. What happens to ticks that arrive while f is busy is
+// controlled by r.Overlap. The duration of every run, and every skipped tick,
+// error, and run canceled by a new tick, are recorded with r.Metrics. Errors
+// returned by f are logged. Durations are measured with c.Clock.
+//
+// The context passed to f is canceled when the outer context is canceled.
+// RunContext returns after every run has returned.
+func RunContext(ctx context.Context, f func(ctx context.Context) error, c Config, r RunConfig) error {
+	if err := r.Check(); err != nil {
+		return err
+	}
+	ticker, err := MakeTicker(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer ticker.Stop()
+
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+	m := r.metrics()
+	busy := make(chan struct{}, r.limit())
+	var cancelPrevious context.CancelFunc
+	var supersedePrevious func()
+	defer func() {
+		if cancelPrevious != nil {
+			cancelPrevious()
+		}
+	}()
+
+	for range ticker.C {
+		if r.Overlap == OverlapCancel && cancelPrevious != nil {
+			select {
+			case busy <- struct{}{}:
+			default:
+				supersedePrevious()
+				// Wait for the canceled run to return, unless the outer
+				// context ends first.
+				select {
+				case busy <- struct{}{}:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+			cancelPrevious()
+		} else {
+			select {
+			case busy <- struct{}{}:
+			default:
+				m.Skipped(r.Name)
+				continue
+			}
+		}
+		runCtx, cancel := context.WithCancel(ctx)
+		// The run is superseded if it is canceled because a new tick arrived.
+		superseded := new(int32)
+		cancelPrevious = cancel
+		supersedePrevious = func() {
+			atomic.StoreInt32(superseded, 1)
+			cancel()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-busy }()
+			defer cancel()
+			r.run(runCtx, f, m, c.clock(), func() bool { return atomic.LoadInt32(superseded) == 1 })
+		}()
+	}
+	return nil
+}
+
+// run calls f once and records the outcome. Runs that end with the error of
+// their context, because it was canceled or its deadline passed, are not
+// errors, and are only counted as canceled if a new tick superseded them.
+func (r RunConfig) run(ctx context.Context, f func(ctx context.Context) error, m RunMetrics, clock Clock, superseded func() bool) {
+	start := clock.Now()
+	err := f(ctx)
+	d := clock.Now().Sub(start)
+	m.Ran(r.Name, d)
+	switch {
+	case err == nil:
+	case ctx.Err() != nil && errors.Is(err, ctx.Err()):
+		if superseded() {
+			m.Canceled(r.Name)
+		}
+	default:
+		m.Failed(r.Name)
+		log.Printf("Memoryless run of %q failed: %v", r.Name, err)
+	}
+	if r.Report != nil {
+		r.Report(d, err)
+	}
+}
@@ -0,0 +1,237 @@
+package memoryless_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/memoryless"
+	"github.com/m-lab/go/memoryless/memorylesstest"
+)
+
+// busyCounter tracks how many runs are busy at once.
+type busyCounter struct {
+	mu                    sync.Mutex
+	busy, max, runs, errs int
+	canceled              int
+}
+
+func (b *busyCounter) start() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.busy++
+	b.runs++
+	if b.busy > b.max {
+		b.max = b.busy
+	}
+}
+
+func (b *busyCounter) stop(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.busy--
+	switch {
+	case errors.Is(err, context.Canceled):
+		b.canceled++
+	case err != nil:
+		b.errs++
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	fail := errors.New("fail")
+	tests := []struct {
+		name         string
+		run          memoryless.RunConfig
+		wantMax      int
+		wantCanceled bool
+		err          error
+	}{
+		{
+			name:    "skip",
+			run:     memoryless.RunConfig{Overlap: memoryless.OverlapSkip},
+			wantMax: 1,
+		},
+		{
+			name:    "concurrent",
+			run:     memoryless.RunConfig{Overlap: memoryless.OverlapConcurrent, Limit: 3},
+			wantMax: 3,
+			err:     fail,
+		},
+		{
+			name:         "cancel",
+			run:          memoryless.RunConfig{Overlap: memoryless.OverlapCancel},
+			wantMax:      1,
+			wantCanceled: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &busyCounter{}
+			tt.run.Name = tt.name
+			tt.run.Report = func(d time.Duration, err error) { b.stop(err) }
+			f := func(ctx context.Context) error {
+				b.start()
+				// Every run is much slower than the ticks, and honors cancellation.
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(20 * time.Millisecond):
+					return tt.err
+				}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			c := memoryless.Config{Expected: time.Millisecond, Max: time.Millisecond}
+			err := memoryless.RunContext(ctx, f, c, tt.run)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if b.busy != 0 {
+				t.Errorf("RunContext() returned with %d busy runs", b.busy)
+			}
+			if b.max != tt.wantMax {
+				t.Errorf("RunContext() ran %d at once, want %d", b.max, tt.wantMax)
+			}
+			// Apart from the runs busy at the end, runs are canceled only by
+			// OverlapCancel.
+			if gotCanceled := b.canceled > tt.wantMax; gotCanceled != tt.wantCanceled {
+				t.Errorf("RunContext() canceled %d runs, want canceled %t", b.canceled, tt.wantCanceled)
+			}
+			if tt.err != nil && b.errs == 0 {
+				t.Errorf("RunContext() reported no errors")
+			}
+		})
+	}
+}
+
+func TestRunContextClock(t *testing.T) {
+	clock := memorylesstest.NewFakeClock(time.Now())
+	c := memoryless.Config{Expected: time.Second, Clock: clock, Rand: memorylesstest.NewRand(1), Once: true}
+	var got time.Duration
+	r := memoryless.RunConfig{Name: "clock", Report: func(d time.Duration, err error) { got = d }}
+	f := func(ctx context.Context) error {
+		clock.Advance(3 * time.Second)
+		return nil
+	}
+	done := make(chan error)
+	go func() {
+		done <- memoryless.RunContext(context.Background(), f, c, r)
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got != 3*time.Second {
+		t.Errorf("RunContext() reported duration %v, want %v", got, 3*time.Second)
+	}
+}
+
+func TestRunContextBadArgs(t *testing.T) {
+	f := func(ctx context.Context) error { return nil }
+	c := memoryless.Config{Expected: time.Second}
+	for _, r := range []memoryless.RunConfig{
+		{Overlap: memoryless.OverlapConcurrent},
+		{Overlap: 7},
+	} {
+		if err := memoryless.RunContext(context.Background(), f, c, r); err == nil {
+			t.Errorf("RunContext() should fail for %+v", r)
+		}
+	}
+	if err := memoryless.RunContext(context.Background(), f, memoryless.Config{Expected: -1}, memoryless.RunConfig{}); err == nil {
+		t.Error("RunContext() should fail for a bad Config")
+	}
+}
+
+// countingMetrics counts the calls of every RunMetrics method.
+type countingMetrics struct {
+	mu                             sync.Mutex
+	ran, skipped, failed, canceled int
+}
+
+func (c *countingMetrics) Ran(string, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ran++
+}
+
+func (c *countingMetrics) Skipped(string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.skipped++
+}
+
+func (c *countingMetrics) Failed(string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failed++
+}
+
+func (c *countingMetrics) Canceled(string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.canceled++
+}
+
+func TestRunContextMetrics(t *testing.T) {
+	fail := errors.New("fail")
+	tests := []struct {
+		name         string
+		overlap      memoryless.Overlap
+		err          error
+		deadline     bool
+		wantSkipped  bool
+		wantFailed   bool
+		wantCanceled bool
+	}{
+		{name: "skip", overlap: memoryless.OverlapSkip, err: fail, wantSkipped: true, wantFailed: true},
+		{name: "cancel", overlap: memoryless.OverlapCancel, wantCanceled: true},
+		{name: "deadline", overlap: memoryless.OverlapSkip, deadline: true, wantSkipped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every run is slower than the ticks, so the last run is always
+			// canceled by the end of the outer context. That must count neither
+			// as canceled by a new tick nor as failed.
+			f := func(ctx context.Context) error {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(20 * time.Millisecond):
+					return tt.err
+				}
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer time.AfterFunc(100*time.Millisecond, cancel).Stop()
+			if tt.deadline {
+				ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+			}
+			m := &countingMetrics{}
+			c := memoryless.Config{Expected: time.Millisecond, Max: time.Millisecond}
+			r := memoryless.RunConfig{Name: tt.name, Overlap: tt.overlap, Metrics: m}
+			if err := memoryless.RunContext(ctx, f, c, r); err != nil {
+				t.Fatal(err)
+			}
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.ran == 0 {
+				t.Error("RunContext() recorded no runs")
+			}
+			if (m.skipped > 0) != tt.wantSkipped {
+				t.Errorf("RunContext() skipped %d ticks, want skipped %t", m.skipped, tt.wantSkipped)
+			}
+			if (m.failed > 0) != tt.wantFailed {
+				t.Errorf("RunContext() failed %d runs, want failed %t", m.failed, tt.wantFailed)
+			}
+			if (m.canceled > 0) != tt.wantCanceled {
+				t.Errorf("RunContext() canceled %d runs, want canceled %t", m.canceled, tt.wantCanceled)
+			}
+		})
+	}
+}
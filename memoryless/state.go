@@ -0,0 +1,111 @@
+package memoryless
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CatchUp controls what a Ticker with a Config.StateFile does with ticks that
+// were scheduled while the process was not running.
+type CatchUp int
+
+const (
+	// CatchUpSkip discards missed ticks. This is the default.
+	CatchUpSkip CatchUp = iota
+	// CatchUpOnce delivers a single tick for all missed ticks as soon as the
+	// Ticker starts.
+	CatchUpOnce
+	// CatchUpAll delivers every missed tick, up to maxMissed, as soon as the
+	// Ticker starts.
+	CatchUpAll
+)
+
+// maxMissed limits how many missed ticks are replayed after a long outage. If
+// more were missed, the schedule starts over.
+const maxMissed = 10000
+
+// tickerState is the content of a Config.StateFile.
+type tickerState struct {
+	Next time.Time `json:"next"`
+}
+
+// readState reads the next scheduled time from the named file. It returns
+// false if the file does not exist.
+func readState(path string) (time.Time, bool, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	var s tickerState
+	if err := json.Unmarshal(b, &s); err != nil {
+		return time.Time{}, false, fmt.Errorf("Could not read memoryless state from %s: %w", path, err)
+	}
+	return s.Next, true, nil
+}
+
+// writeState atomically replaces the named file with one recording the next
+// scheduled time. The file is written to a temporary file in the same
+// directory first, so a crash never leaves a partial file behind.
+func writeState(path string, next time.Time) error {
+	b, err := json.Marshal(tickerState{Next: next})
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// resume reads the schedule saved in the StateFile and returns the next time
+// to tick, and the missed ticks to deliver immediately according to the
+// CatchUp policy. Missed ticks are found by replaying the schedule from the
+// saved time, so the sequence of ticks is the same as if the process had kept
+// running. The returned next time is saved in the StateFile.
+func (c Config) resume(now time.Time) (next time.Time, missed []time.Time, err error) {
+	next, ok, err := readState(c.StateFile)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	if !ok {
+		next = now.Add(c.waittime(now))
+	}
+	for !next.After(now) && len(missed) < maxMissed {
+		missed = append(missed, next)
+		next = next.Add(c.waittime(next))
+	}
+	if !next.After(now) {
+		// The outage was too long to replay, so start over.
+		next = now.Add(c.waittime(now))
+	}
+	switch {
+	case c.CatchUp == CatchUpSkip:
+		missed = nil
+	case c.CatchUp == CatchUpOnce && len(missed) > 0:
+		missed = missed[len(missed)-1:]
+	}
+	if err := writeState(c.StateFile, next); err != nil {
+		return time.Time{}, nil, err
+	}
+	return next, missed, nil
+}
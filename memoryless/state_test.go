@@ -0,0 +1,170 @@
+package memoryless_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/memoryless"
+	"github.com/m-lab/go/memoryless/memorylesstest"
+	"github.com/m-lab/go/rtx"
+)
+
+func readNext(t *testing.T, file string) time.Time {
+	t.Helper()
+	b, err := ioutil.ReadFile(file)
+	rtx.Must(err, "Could not read state file")
+	var s struct{ Next time.Time }
+	rtx.Must(json.Unmarshal(b, &s), "Could not parse state file")
+	return s.Next
+}
+
+func writeNext(t *testing.T, file string, next time.Time) {
+	t.Helper()
+	b, err := json.Marshal(map[string]time.Time{"next": next})
+	rtx.Must(err, "Could not marshal state")
+	rtx.Must(ioutil.WriteFile(file, b, 0644), "Could not write state file")
+}
+
+func TestTickerStateFile(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := memorylesstest.NewFakeClock(start)
+	file := path.Join(t.TempDir(), "state.json")
+	config := memoryless.Config{
+		Expected:  10 * time.Second,
+		Clock:     clock,
+		Rand:      memorylesstest.NewRand(1),
+		StateFile: file,
+	}
+
+	// A new schedule is saved before the first wait.
+	ticker, err := memoryless.NewTicker(context.Background(), config)
+	rtx.Must(err, "Could not make ticker")
+	clock.BlockUntil(1)
+	if got := readNext(t, file); !got.Equal(start.Add(10 * time.Second)) {
+		t.Errorf("State file has next %v, want %v", got, start.Add(10*time.Second))
+	}
+	done := make(chan struct{})
+	go func(c <-chan time.Time) {
+		for range c {
+		}
+		close(done)
+	}(ticker.C)
+	clock.Advance(10 * time.Second)
+	clock.BlockUntil(1)
+	if got := readNext(t, file); !got.Equal(start.Add(20 * time.Second)) {
+		t.Errorf("State file has next %v, want %v", got, start.Add(20*time.Second))
+	}
+	ticker.Stop()
+	<-done
+
+	// A restarted ticker resumes the saved schedule instead of starting over.
+	clock.Advance(3 * time.Second)
+	ticker, err = memoryless.NewTicker(context.Background(), config)
+	rtx.Must(err, "Could not make ticker")
+	defer ticker.Stop()
+	clock.BlockUntil(1)
+	if d := clock.Deadlines(); !d[0].Equal(start.Add(20 * time.Second)) {
+		t.Errorf("Restarted ticker waits until %v, want %v", d[0], start.Add(20*time.Second))
+	}
+}
+
+func TestTickerCatchUp(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		catchUp    memoryless.CatchUp
+		wantMissed []time.Time
+	}{
+		{
+			name:    "skip",
+			catchUp: memoryless.CatchUpSkip,
+		},
+		{
+			name:       "once",
+			catchUp:    memoryless.CatchUpOnce,
+			wantMissed: []time.Time{start.Add(-5 * time.Second)},
+		},
+		{
+			name:       "all",
+			catchUp:    memoryless.CatchUpAll,
+			wantMissed: []time.Time{start.Add(-25 * time.Second), start.Add(-15 * time.Second), start.Add(-5 * time.Second)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := memorylesstest.NewFakeClock(start)
+			file := path.Join(t.TempDir(), "state.json")
+			// The process was down while ticks at -25s, -15s, and -5s came due.
+			writeNext(t, file, start.Add(-25*time.Second))
+			config := memoryless.Config{
+				Expected:  10 * time.Second,
+				Clock:     clock,
+				Rand:      memorylesstest.NewRand(1),
+				StateFile: file,
+				CatchUp:   tt.catchUp,
+			}
+			ticker, err := memoryless.NewTicker(context.Background(), config)
+			rtx.Must(err, "Could not make ticker")
+			defer ticker.Stop()
+			for _, want := range tt.wantMissed {
+				if got := <-ticker.C; !got.Equal(want) {
+					t.Errorf("Missed tick at %v, want %v", got, want)
+				}
+			}
+			// The replayed schedule continues where it would have been.
+			clock.BlockUntil(1)
+			if d := clock.Deadlines(); !d[0].Equal(start.Add(5 * time.Second)) {
+				t.Errorf("Ticker waits until %v, want %v", d[0], start.Add(5*time.Second))
+			}
+			if got := readNext(t, file); !got.Equal(start.Add(5 * time.Second)) {
+				t.Errorf("State file has next %v, want %v", got, start.Add(5*time.Second))
+			}
+		})
+	}
+}
+
+func TestTickerLongOutage(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := memorylesstest.NewFakeClock(start)
+	file := path.Join(t.TempDir(), "state.json")
+	writeNext(t, file, start.Add(-365*24*time.Hour))
+	config := memoryless.Config{
+		Expected:  time.Second,
+		Clock:     clock,
+		Rand:      memorylesstest.NewRand(1),
+		StateFile: file,
+		CatchUp:   memoryless.CatchUpAll,
+	}
+	ticker, err := memoryless.NewTicker(context.Background(), config)
+	rtx.Must(err, "Could not make ticker")
+	ticker.Stop()
+	count := 0
+	for range ticker.C {
+		count++
+	}
+	if count > 10000 {
+		t.Errorf("Ticker delivered %d missed ticks, want at most 10000", count)
+	}
+	if got := readNext(t, file); !got.Equal(start.Add(time.Second)) {
+		t.Errorf("State file has next %v, want %v", got, start.Add(time.Second))
+	}
+}
+
+func TestTickerStateFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	corrupt := path.Join(dir, "corrupt.json")
+	rtx.Must(ioutil.WriteFile(corrupt, []byte("{"), 0644), "Could not write state file")
+	for _, c := range []memoryless.Config{
+		{Expected: time.Second, StateFile: corrupt},
+		{Expected: time.Second, StateFile: path.Join(dir, "missing", "state.json")},
+		{Expected: time.Second, StateFile: path.Join(dir, "state.json"), CatchUp: 7},
+	} {
+		if _, err := memoryless.NewTicker(context.Background(), c); err == nil {
+			t.Errorf("NewTicker() should fail for %+v", c)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package shx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// If creates an IfJob that runs then if cond succeeds, and otherwise runs els.
+// els may be nil.
+func If(cond, then, els Job) *IfJob {
+	return &IfJob{
+		Cond: cond,
+		Then: then,
+		Else: els,
+	}
+}
+
+// IfJob implements the Job interface for conditional execution.
+type IfJob struct {
+	Cond Job
+	Then Job
+	Else Job
+}
+
+// Run executes Cond, and then either Then or Else. Like a shell, any error from
+// Cond selects Else; Cond errors are not returned. If the context is canceled
+// while Cond runs, neither Then nor Else runs and the context error is returned.
+func (c *IfJob) Run(ctx context.Context, s *State) error {
+	err := c.Cond.Run(ctx, s)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == nil {
+		return c.Then.Run(ctx, s)
+	}
+	if c.Else != nil {
+		return c.Else.Run(ctx, s)
+	}
+	return nil
+}
+
+// Describe generates a description of the conditional.
+func (c *IfJob) Describe(d *Description) {
+	endlist := d.StartSequence("if ", "")
+	c.Cond.Describe(d)
+	endlist(" ; then")
+	d.Depth++
+	c.Then.Describe(d)
+	d.Depth--
+	if c.Else != nil {
+		d.Append("else")
+		d.Depth++
+		c.Else.Describe(d)
+		d.Depth--
+	}
+	d.Append("fi")
+}
+
+// ForEachFile creates a ForEachJob that runs body once for every file matching
+// the glob pattern, with the path of the file assigned to the named variable.
+// Relative patterns match relative to the running State Dir.
+func ForEachFile(name, pattern string, body Job) *ForEachJob {
+	return &ForEachJob{
+		Name: name,
+		Glob: pattern,
+		Body: body,
+	}
+}
+
+// ForEachLine creates a ForEachJob that runs body once for every non-empty line
+// written to stdout by source, with the line assigned to the named variable.
+func ForEachLine(name string, source, body Job) *ForEachJob {
+	return &ForEachJob{
+		Name:  name,
+		Lines: source,
+		Body:  body,
+	}
+}
+
+// ForEachJob implements the Job interface for running a Job once for every item
+// in a list. Items come from the Glob pattern, if it is set, or else from the
+// stdout of the Lines Job.
+type ForEachJob struct {
+	Name  string
+	Glob  string
+	Lines Job
+	Body  Job
+}
+
+// Run executes Body for every item. Like a shell loop, Body runs in the State
+// given to Run, so variables and directory changes made by Body persist after
+// the loop, and the named variable keeps the last item. The first error from
+// Body stops the loop.
+func (c *ForEachJob) Run(ctx context.Context, s *State) error {
+	items, err := c.items(ctx, s)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.SetEnv(c.Name, item)
+		s.Tracef("%s=%q", c.Name, item)
+		if err := c.Body.Run(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ForEachJob) items(ctx context.Context, s *State) ([]string, error) {
+	if c.Lines == nil {
+		return filepath.Glob(s.Path(c.Glob))
+	}
+	b := &bytes.Buffer{}
+	z := s.copy()
+	z.Stdout = b
+	if err := c.Lines.Run(ctx, z); err != nil {
+		return nil, err
+	}
+	var items []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line != "" {
+			items = append(items, line)
+		}
+	}
+	return items, nil
+}
+
+// Describe generates a description of the loop.
+func (c *ForEachJob) Describe(d *Description) {
+	if c.Lines == nil {
+		d.Append(fmt.Sprintf("for %s in %s ; do", c.Name, c.Glob))
+	} else {
+		endlist := d.StartSequence(fmt.Sprintf("for %s in $(", c.Name), "")
+		c.Lines.Describe(d)
+		endlist(") ; do")
+	}
+	d.Depth++
+	c.Body.Describe(d)
+	d.Depth--
+	d.Append("done")
+}
+
+// While creates a WhileJob that runs body for as long as cond succeeds.
+func While(cond, body Job) *WhileJob {
+	return &WhileJob{
+		Cond: cond,
+		Body: body,
+	}
+}
+
+// WhileJob implements the Job interface for a conditional loop.
+type WhileJob struct {
+	Cond Job
+	Body Job
+}
+
+// Run executes Cond and then Body until Cond fails, Body fails, or the context
+// is canceled. Cond errors end the loop and are not returned. Body errors and
+// context errors are returned.
+func (c *WhileJob) Run(ctx context.Context, s *State) error {
+	for {
+		err := c.Cond.Run(ctx, s)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return nil
+		}
+		if err := c.Body.Run(ctx, s); err != nil {
+			return err
+		}
+	}
+}
+
+// Describe generates a description of the loop.
+func (c *WhileJob) Describe(d *Description) {
+	endlist := d.StartSequence("while ", "")
+	c.Cond.Describe(d)
+	endlist(" ; do")
+	d.Depth++
+	c.Body.Describe(d)
+	d.Depth--
+	d.Append("done")
+}
+
+// Try creates a TryJob that runs job, and then always runs finally.
+func Try(job, finally Job) *TryJob {
+	return &TryJob{
+		Job:     job,
+		Finally: finally,
+	}
+}
+
+// TryJob implements the Job interface for running cleanup after a Job,
+// regardless of whether it succeeds.
+type TryJob struct {
+	Job     Job
+	Finally Job
+}
+
+// Run executes Job and then Finally. Finally runs even if Job fails or the
+// context is canceled. Because Finally runs with a context that is never
+// canceled, use Timeout to bound cleanup that may not complete. The error from
+// Job is returned, annotated with the error from Finally if both fail.
+func (c *TryJob) Run(ctx context.Context, s *State) error {
+	err := c.Job.Run(ctx, s)
+	ferr := c.Finally.Run(detachedContext{ctx}, s)
+	switch {
+	case err != nil && ferr != nil:
+		return fmt.Errorf("%w (finally: %v)", err, ferr)
+	case err != nil:
+		return err
+	default:
+		return ferr
+	}
+}
+
+// Describe generates a description of the Job and its cleanup, using the zsh
+// "always" syntax.
+func (c *TryJob) Describe(d *Description) {
+	d.Append("{")
+	d.Depth++
+	c.Job.Describe(d)
+	d.Depth--
+	d.Append("} always {")
+	d.Depth++
+	c.Finally.Describe(d)
+	d.Depth--
+	d.Append("}")
+}
+
+// detachedContext keeps the values of a parent context, but is never canceled.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
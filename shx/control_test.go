@@ -0,0 +1,244 @@
+package shx_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+
+	. "github.com/m-lab/go/shx"
+)
+
+func TestControl(t *testing.T) {
+	tmpdir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.log"} {
+		if err := ioutil.WriteFile(path.Join(tmpdir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		job     Job
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "if-then",
+			job:  If(Exec("true"), Println("then"), Println("else")),
+			want: "then\n",
+		},
+		{
+			name: "if-else",
+			job:  If(Exec("false"), Println("then"), Println("else")),
+			want: "else\n",
+		},
+		{
+			name: "if-no-else",
+			job:  If(Exec("false"), Println("then"), nil),
+		},
+		{
+			name:    "if-then-error",
+			job:     If(Exec("true"), Exec("false"), nil),
+			wantErr: true,
+		},
+		{
+			name: "foreach-file-expand",
+			job:  ForEachFile("F", "*.txt", System("cat $F; echo")),
+			want: "a.txt\nb.txt\n",
+		},
+		{
+			name: "foreach-file-none",
+			job:  ForEachFile("F", "*.none", Exec("false")),
+		},
+		{
+			name:    "foreach-file-bad-pattern",
+			job:     ForEachFile("F", "[", Exec("true")),
+			wantErr: true,
+		},
+		{
+			name: "foreach-line",
+			job:  ForEachLine("L", System("printf 'one\\n\\ntwo\\n'"), Println("<$L>")),
+			want: "<one>\n<two>\n",
+		},
+		{
+			name:    "foreach-line-source-error",
+			job:     ForEachLine("L", Exec("false"), Println("<$L>")),
+			wantErr: true,
+		},
+		{
+			name: "foreach-line-body-error",
+			job: ForEachLine("L", System("echo one; echo two"), Script(
+				Println("$L"),
+				Exec("false"),
+			)),
+			want:    "one\n",
+			wantErr: true,
+		},
+		{
+			name: "while",
+			job: Script(
+				SetEnv("N", ""),
+				// Script isolates the State, so the body must set N directly.
+				While(System(`test "$N" != "xxx"`), SetEnvFromJob("N", System(`echo "${N}x"`))),
+				Println("$N"),
+			),
+			want: "xxx\n",
+		},
+		{
+			name:    "while-body-error",
+			job:     While(Exec("true"), Exec("false")),
+			wantErr: true,
+		},
+		{
+			name: "try-success",
+			job:  Try(Println("try"), Println("finally")),
+			want: "try\nfinally\n",
+		},
+		{
+			name:    "try-error",
+			job:     Try(Exec("false"), Println("finally")),
+			want:    "finally\n",
+			wantErr: true,
+		},
+		{
+			name:    "try-finally-error",
+			job:     Try(Println("try"), Exec("false")),
+			want:    "try\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &bytes.Buffer{}
+			s := &State{Stdout: b, Dir: tmpdir}
+			err := tt.job.Run(context.Background(), s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Run() wrong error; got %v, wantErr %t", err, tt.wantErr)
+			}
+			if b.String() != tt.want {
+				t.Errorf("Run() wrong output; got %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestForEachState(t *testing.T) {
+	s := &State{}
+	f := ForEachLine("X", System("echo 1; echo 2"), SetEnv("LAST", "seen"))
+	if err := f.Run(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetEnv("X") != "2" || s.GetEnv("LAST") != "seen" {
+		t.Errorf("ForEach should modify the State; got %v", s.Env)
+	}
+}
+
+func TestControlCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errJob := Func("fail", func(ctx context.Context, s *State) error { return errors.New("fail") })
+	jobs := []Job{
+		If(errJob, Println("then"), Println("else")),
+		While(errJob, Println("body")),
+		ForEachLine("L", Println("line"), Println("body")),
+	}
+	for _, job := range jobs {
+		b := &bytes.Buffer{}
+		err := job.Run(ctx, &State{Stdout: b})
+		if err != context.Canceled {
+			t.Errorf("Run() wrong error; got %v, want %v", err, context.Canceled)
+		}
+		if b.String() != "" {
+			t.Errorf("Run() should not run jobs after cancellation; got %q", b.String())
+		}
+	}
+}
+
+func TestTryCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	b := &bytes.Buffer{}
+	s := &State{Stdout: b}
+	job := Try(Exec("sleep", "60"), Script(
+		Func("check-context", func(ctx context.Context, s *State) error {
+			return ctx.Err()
+		}),
+		Exec("echo", "cleaned up"),
+	))
+	err := job.Run(ctx, s)
+	if err == nil {
+		t.Errorf("Try.Run() should return the error from the canceled Job")
+	}
+	if b.String() != "cleaned up\n" {
+		t.Errorf("Try.Run() Finally did not run; got %q", b.String())
+	}
+}
+
+func TestControlDescribe(t *testing.T) {
+	tests := []struct {
+		name string
+		job  Job
+		want string
+	}{
+		{
+			name: "if",
+			job:  If(Exec("test", "-f", "x"), Exec("cat", "x"), nil),
+			want: " 1: if test -f x ; then\n 2:   cat x\n 3: fi\n",
+		},
+		{
+			name: "if-else",
+			job:  If(Pipe(Exec("ls"), Exec("grep", "x")), Exec("echo", "yes"), Exec("echo", "no")),
+			want: " 1: if ls | grep x ; then\n 2:   echo yes\n 3: else\n 4:   echo no\n 5: fi\n",
+		},
+		{
+			name: "foreach-file",
+			job:  ForEachFile("f", "*.txt", Exec("gzip", "$f")),
+			want: " 1: for f in *.txt ; do\n 2:   gzip $f\n 3: done\n",
+		},
+		{
+			name: "foreach-line",
+			job:  ForEachLine("host", ReadFile("hosts"), Exec("ping", "-c1", "$host")),
+			want: " 1: for host in $(cat < hosts) ; do\n 2:   ping -c1 $host\n 3: done\n",
+		},
+		{
+			name: "while",
+			job:  While(Exec("true"), Exec("sleep", "1")),
+			want: " 1: while true ; do\n 2:   sleep 1\n 3: done\n",
+		},
+		{
+			name: "try",
+			job:  Try(Exec("make"), Exec("rm", "-rf", "build")),
+			want: " 1: {\n 2:   make\n 3: } always {\n 4:   rm -rf build\n 5: }\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Description{}
+			tt.job.Describe(d)
+			if got := d.String(); got != tt.want {
+				t.Errorf("Describe() wrong result;\ngot  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func ExampleTryJob_Run() {
+	job := Try(
+		ForEachLine("N", System("seq 3"), If(
+			System(`test "$N" -lt 3`),
+			Println("step $N"),
+			Exec("false"),
+		)),
+		Println("cleanup"),
+	)
+	err := job.Run(context.Background(), New())
+	fmt.Println(err != nil)
+	// Output: step 1
+	// step 2
+	// cleanup
+	// true
+}
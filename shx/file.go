@@ -0,0 +1,229 @@
+package shx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Copy creates a Job that copies the src file to dst. If dst is an existing
+// directory, the file is copied into it. The dst file is created with the
+// permissions of src, and is truncated if it already exists. Like cp, Copy
+// fails if src and dst are the same file.
+func Copy(src, dst string) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			src, dst := s.Path(src), intoDir(s.Path(dst), src)
+			if !traceChange(s, fmt.Sprintf("cp %s %s", src, dst)) {
+				return nil
+			}
+			in, err := os.Open(src)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+			info, err := in.Stat()
+			if err != nil {
+				return err
+			}
+			if dinfo, err := os.Stat(dst); err == nil && os.SameFile(info, dinfo) {
+				// Truncating dst would destroy src before it is read.
+				return fmt.Errorf("%s and %s are the same file", src, dst)
+			}
+			out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+			if err != nil {
+				return err
+			}
+			if _, err = io.Copy(out, NewReaderContext(ctx, in)); err != nil {
+				out.Close()
+				return err
+			}
+			return out.Close()
+		},
+		Desc: func(d *Description) {
+			d.Append(fmt.Sprintf("cp %s %s", src, dst))
+		},
+	}
+}
+
+// Move creates a Job that renames src to dst. If dst is an existing directory,
+// src is moved into it. Like os.Rename, Move may fail when src and dst are on
+// different filesystems.
+func Move(src, dst string) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			src, dst := s.Path(src), intoDir(s.Path(dst), src)
+			if !traceChange(s, fmt.Sprintf("mv %s %s", src, dst)) {
+				return nil
+			}
+			return os.Rename(src, dst)
+		},
+		Desc: func(d *Description) {
+			d.Append(fmt.Sprintf("mv %s %s", src, dst))
+		},
+	}
+}
+
+// Remove creates a Job that removes the named file or empty directory.
+func Remove(path string) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			if !traceChange(s, fmt.Sprintf("rm %s", s.Path(path))) {
+				return nil
+			}
+			return os.Remove(s.Path(path))
+		},
+		Desc: func(d *Description) {
+			d.Append(fmt.Sprintf("rm %s", path))
+		},
+	}
+}
+
+// RemoveAll creates a Job that removes the named path and everything it
+// contains. Like "rm -rf", it is not an error if the path does not exist.
+func RemoveAll(path string) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			if !traceChange(s, fmt.Sprintf("rm -rf %s", s.Path(path))) {
+				return nil
+			}
+			return os.RemoveAll(s.Path(path))
+		},
+		Desc: func(d *Description) {
+			d.Append(fmt.Sprintf("rm -rf %s", path))
+		},
+	}
+}
+
+// Mkdir creates a Job that creates the named directory, along with any missing
+// parents. Like "mkdir -p", it is not an error if the directory exists.
+func Mkdir(path string, perm os.FileMode) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			if !traceChange(s, fmt.Sprintf("mkdir -p %s", s.Path(path))) {
+				return nil
+			}
+			return os.MkdirAll(s.Path(path), perm)
+		},
+		Desc: func(d *Description) {
+			d.Append(fmt.Sprintf("mkdir -p %s", path))
+		},
+	}
+}
+
+// Chmod creates a Job that changes the permissions of the named file.
+func Chmod(path string, mode os.FileMode) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			if !traceChange(s, fmt.Sprintf("chmod %04o %s", mode, s.Path(path))) {
+				return nil
+			}
+			return os.Chmod(s.Path(path), mode)
+		},
+		Desc: func(d *Description) {
+			d.Append(fmt.Sprintf("chmod %04o %s", mode, path))
+		},
+	}
+}
+
+// Symlink creates a Job that creates link as a symbolic link to target. The
+// target is stored as given, so a relative target is relative to the directory
+// containing link, as with "ln -s".
+func Symlink(target, link string) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			if !traceChange(s, fmt.Sprintf("ln -s %s %s", target, s.Path(link))) {
+				return nil
+			}
+			return os.Symlink(target, s.Path(link))
+		},
+		Desc: func(d *Description) {
+			d.Append(fmt.Sprintf("ln -s %s %s", target, link))
+		},
+	}
+}
+
+// Glob creates a Job that assigns the named variable to the space separated
+// list of files matching the glob pattern. If no files match, the variable is
+// empty. Relative patterns match relative to the running State Dir.
+func Glob(name, pattern string) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			matches, err := filepath.Glob(s.Path(pattern))
+			if err != nil {
+				return err
+			}
+			s.SetEnv(name, strings.Join(matches, " "))
+			s.Tracef("export %s=%q", name, s.GetEnv(name))
+			return nil
+		},
+		Desc: func(d *Description) {
+			d.Append(fmt.Sprintf("export %s=$(echo %s)", name, pattern))
+		},
+	}
+}
+
+// WriteFileAtomic creates a Job that reads from the Job input and writes to the
+// named file, like WriteFile. The input is first written to a temporary file in
+// the same directory, which is renamed to path only once all input is written.
+// So, readers of path never observe a partial write, and path is unchanged if
+// the Job fails.
+func WriteFileAtomic(path string, perm os.FileMode) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			path := s.Path(path)
+			if !traceChange(s, fmt.Sprintf("cat > %s", path)) {
+				return discard(ctx, s.Stdin)
+			}
+			tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+			if err != nil {
+				return err
+			}
+			// After a successful rename, removing the temporary name fails harmlessly.
+			defer os.Remove(tmp.Name())
+			if _, err = io.Copy(tmp, NewReaderContext(ctx, s.Stdin)); err != nil {
+				tmp.Close()
+				return err
+			}
+			if err = tmp.Chmod(perm); err != nil {
+				tmp.Close()
+				return err
+			}
+			if err = tmp.Sync(); err != nil {
+				tmp.Close()
+				return err
+			}
+			if err = tmp.Close(); err != nil {
+				return err
+			}
+			return os.Rename(tmp.Name(), path)
+		},
+		Desc: func(d *Description) {
+			d.Append(fmt.Sprintf("cat > %s.tmp && mv %s.tmp %s", path, path, path))
+		},
+	}
+}
+
+// traceChange traces a command that changes the filesystem, and reports whether
+// the change should be made. Changes are not made when the State is a DryRun.
+func traceChange(s *State, cmd string) bool {
+	if s.DryRun {
+		s.Tracef("%s # dry run", cmd)
+		return false
+	}
+	s.Tracef("%s", cmd)
+	return true
+}
+
+// intoDir returns the path of src within dst when dst is an existing directory,
+// and dst otherwise.
+func intoDir(dst, src string) string {
+	if info, err := os.Stat(dst); err == nil && info.IsDir() {
+		return filepath.Join(dst, filepath.Base(src))
+	}
+	return dst
+}
@@ -0,0 +1,267 @@
+package shx_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	. "github.com/m-lab/go/shx"
+)
+
+func TestFileJobs(t *testing.T) {
+	tests := []struct {
+		name    string
+		job     Job
+		check   func(t *testing.T, dir string)
+		wantErr bool
+	}{
+		{
+			name: "copy",
+			job:  Copy("a.txt", "b.txt"),
+			check: func(t *testing.T, dir string) {
+				wantFile(t, path.Join(dir, "b.txt"), "a")
+				wantMode(t, path.Join(dir, "b.txt"), 0640)
+			},
+		},
+		{
+			name: "copy-into-dir",
+			job:  Copy("a.txt", "sub"),
+			check: func(t *testing.T, dir string) {
+				wantFile(t, path.Join(dir, "sub", "a.txt"), "a")
+			},
+		},
+		{
+			name: "copy-same-file",
+			job:  Copy("a.txt", "."),
+			check: func(t *testing.T, dir string) {
+				wantFile(t, path.Join(dir, "a.txt"), "a")
+			},
+			wantErr: true,
+		},
+		{
+			name: "copy-same-file-via-link",
+			job:  Script(Symlink("a.txt", "link.txt"), Copy("link.txt", "a.txt")),
+			check: func(t *testing.T, dir string) {
+				wantFile(t, path.Join(dir, "a.txt"), "a")
+			},
+			wantErr: true,
+		},
+		{
+			name:    "copy-missing",
+			job:     Copy("missing.txt", "b.txt"),
+			wantErr: true,
+		},
+		{
+			name: "move",
+			job:  Move("a.txt", "sub/moved.txt"),
+			check: func(t *testing.T, dir string) {
+				wantFile(t, path.Join(dir, "sub", "moved.txt"), "a")
+				wantMissing(t, path.Join(dir, "a.txt"))
+			},
+		},
+		{
+			name: "move-into-dir",
+			job:  Move("a.txt", "sub"),
+			check: func(t *testing.T, dir string) {
+				wantFile(t, path.Join(dir, "sub", "a.txt"), "a")
+			},
+		},
+		{
+			name: "remove",
+			job:  Remove("a.txt"),
+			check: func(t *testing.T, dir string) {
+				wantMissing(t, path.Join(dir, "a.txt"))
+			},
+		},
+		{
+			name:    "remove-non-empty-dir",
+			job:     Remove("sub"),
+			wantErr: true,
+		},
+		{
+			name: "remove-all",
+			job:  Script(RemoveAll("sub"), RemoveAll("missing")),
+			check: func(t *testing.T, dir string) {
+				wantMissing(t, path.Join(dir, "sub"))
+			},
+		},
+		{
+			name: "mkdir",
+			job:  Script(Mkdir("x/y/z", 0755), Mkdir("x/y", 0755)),
+			check: func(t *testing.T, dir string) {
+				wantMode(t, path.Join(dir, "x/y/z"), os.ModeDir|0755)
+			},
+		},
+		{
+			name: "chmod",
+			job:  Chmod("a.txt", 0600),
+			check: func(t *testing.T, dir string) {
+				wantMode(t, path.Join(dir, "a.txt"), 0600)
+			},
+		},
+		{
+			name: "symlink",
+			job:  Symlink("../a.txt", "sub/link.txt"),
+			check: func(t *testing.T, dir string) {
+				wantFile(t, path.Join(dir, "sub", "link.txt"), "a")
+			},
+		},
+		{
+			name: "glob",
+			job:  Script(Glob("FILES", "*.txt"), Glob("NONE", "*.none"), System(`echo "$FILES" "[$NONE]" > out`)),
+			check: func(t *testing.T, dir string) {
+				wantFile(t, path.Join(dir, "out"), path.Join(dir, "a.txt")+" "+path.Join(dir, "c.txt")+" []\n")
+			},
+		},
+		{
+			name: "write-file-atomic",
+			job:  Pipe(Println("new"), WriteFileAtomic("a.txt", 0600)),
+			check: func(t *testing.T, dir string) {
+				wantFile(t, path.Join(dir, "a.txt"), "new\n")
+				wantMode(t, path.Join(dir, "a.txt"), 0600)
+				files, _ := ioutil.ReadDir(dir)
+				if len(files) != 3 {
+					t.Errorf("WriteFileAtomic() left temporary files; got %d files", len(files))
+				}
+			},
+		},
+		{
+			name: "write-file-atomic-error",
+			job: Func("fail", func(ctx context.Context, s *State) error {
+				s.Stdin = io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("fail")))
+				return WriteFileAtomic("a.txt", 0600).Run(ctx, s)
+			}),
+			check: func(t *testing.T, dir string) {
+				wantFile(t, path.Join(dir, "a.txt"), "a")
+				files, _ := ioutil.ReadDir(dir)
+				if len(files) != 3 {
+					t.Errorf("WriteFileAtomic() left temporary files; got %d files", len(files))
+				}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range map[string]string{"a.txt": "a", "c.txt": "c", "sub/b.txt": "b"} {
+				os.MkdirAll(path.Dir(path.Join(dir, name)), 0755)
+				if err := ioutil.WriteFile(path.Join(dir, name), []byte(content), 0640); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := tt.job.Run(context.Background(), &State{Dir: dir})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Run() wrong error; got %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, dir)
+			}
+		})
+	}
+}
+
+func TestFileJobsDryRun(t *testing.T) {
+	dir := t.TempDir()
+	trace := &bytes.Buffer{}
+	s := &State{Dir: dir, DryRun: true, Trace: trace}
+	job := Script(
+		Mkdir("x", 0755),
+		Copy("a", "b"),
+		Move("a", "b"),
+		Chmod("a", 0644),
+		Symlink("a", "b"),
+		Remove("a"),
+		RemoveAll("x"),
+		Pipe(Println("data"), WriteFileAtomic("a", 0644)),
+	)
+	if err := job.Run(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("DryRun should not change the filesystem; got %d files", len(files))
+	}
+	want := []string{
+		"+ mkdir -p " + path.Join(dir, "x") + " # dry run",
+		"+ cp " + path.Join(dir, "a") + " " + path.Join(dir, "b") + " # dry run",
+		"+ mv " + path.Join(dir, "a") + " " + path.Join(dir, "b") + " # dry run",
+		"+ chmod 0644 " + path.Join(dir, "a") + " # dry run",
+		"+ ln -s a " + path.Join(dir, "b") + " # dry run",
+		"+ rm " + path.Join(dir, "a") + " # dry run",
+		"+ rm -rf " + path.Join(dir, "x") + " # dry run",
+		"+ cat > " + path.Join(dir, "a") + " # dry run",
+		"",
+	}
+	if got := trace.String(); got != strings.Join(want, "\n") {
+		t.Errorf("Run() wrong trace;\ngot  %q\nwant %q", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestFileJobsDescribe(t *testing.T) {
+	job := Script(
+		Mkdir("out", 0755),
+		Copy("a", "b"),
+		Move("a", "b"),
+		Chmod("run.sh", 0755),
+		Symlink("a", "b"),
+		Remove("a"),
+		RemoveAll("out"),
+		Glob("FILES", "*.txt"),
+		WriteFileAtomic("a", 0644),
+	)
+	want := " 1: (\n" +
+		" 2:   mkdir -p out\n" +
+		" 3:   cp a b\n" +
+		" 4:   mv a b\n" +
+		" 5:   chmod 0755 run.sh\n" +
+		" 6:   ln -s a b\n" +
+		" 7:   rm a\n" +
+		" 8:   rm -rf out\n" +
+		" 9:   export FILES=$(echo *.txt)\n" +
+		"10:   cat > a.tmp && mv a.tmp a\n" +
+		"11: )\n"
+	d := &Description{}
+	job.Describe(d)
+	if got := d.String(); got != want {
+		t.Errorf("Describe() wrong result;\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func wantFile(t *testing.T, name, want string) {
+	t.Helper()
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(b) != want {
+		t.Errorf("wrong content in %s; got %q, want %q", name, string(b), want)
+	}
+}
+
+func wantMode(t *testing.T, name string, want os.FileMode) {
+	t.Helper()
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if info.Mode() != want {
+		t.Errorf("wrong mode for %s; got %v, want %v", name, info.Mode(), want)
+	}
+}
+
+func wantMissing(t *testing.T, name string) {
+	t.Helper()
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("%s should not exist; got %v", name, err)
+	}
+}
@@ -2,8 +2,9 @@
 //
 // A Job represents one or more operations. A single-operation Job may represent
 // running a command (Exec, System), or reading or writing a file (ReadFile,
-// WriteFile), or a user defined operation (Func). A multiple-operation Job runs
-// several single operation jobs in a sequence (Script) or pipeline (Pipe).
+// WriteFile), or changing the filesystem (Copy, Move, Remove, Mkdir), or a user
+// defined operation (Func). A multiple-operation Job runs several single
+// operation jobs in a sequence (Script) or pipeline (Pipe).
 // Taken together, these primitive types allow the composition of more and more
 // complex operations.
 //
@@ -26,11 +27,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Description is used to produce a representation of a Job. Custom Job types
@@ -71,6 +74,10 @@ func (d *Description) Append(cmd string) {
 // "start" and subsequent calls to Append add commands to the end of the current
 // line, separating sequential commands with "sep". StartSequence returns a
 // function that ends the line and restores the default behavior of Append.
+//
+// A sequence started within another sequence counts as one command of the
+// enclosing sequence, so it is preceded by the enclosing separator only if it
+// is not the first command, and the command after it is separated from it.
 func (d *Description) StartSequence(start, sep string) (endlist func(end string)) {
 	d.seps = append(d.seps, sep)
 	d.idxs = append(d.idxs, 0)
@@ -80,8 +87,12 @@ func (d *Description) StartSequence(start, sep string) (endlist func(end string)
 		d.desc.WriteString(fmt.Sprintf("%2d: %s", d.line, prefix(d.Depth)))
 	}
 	if l > 1 {
-		// For deeper nesting, use the prior separator prior to current start.
-		d.desc.WriteString(d.seps[l-2])
+		// For deeper nesting, the sequence counts as one command of the
+		// enclosing sequence, so use the prior separator after the first.
+		d.idxs[l-2]++
+		if d.idxs[l-2] > 1 {
+			d.desc.WriteString(d.seps[l-2])
+		}
 	}
 	d.desc.WriteString(start)
 	endlist = func(end string) {
@@ -114,6 +125,21 @@ type State struct {
 	Stderr io.Writer
 	Dir    string
 	Env    []string
+
+	// Report, if not nil, collects a Result for every command, Script, and
+	// Pipe run using this State or any State derived from it.
+	Report *Report
+
+	// Trace, if not nil, receives a line for every command, directory change,
+	// and environment change as it runs, like "set -x" in a shell.
+	Trace io.Writer
+
+	// DryRun, if true, prevents commands from running and files from being
+	// written. They are only traced.
+	DryRun bool
+
+	// Process, if not nil, controls how commands are started and stopped.
+	Process *Process
 }
 
 // New creates a State instance based on the current process state, using
@@ -133,10 +159,14 @@ func New() *State {
 
 func (s *State) copy() *State {
 	c := &State{
-		Stdin:  s.Stdin,
-		Stdout: s.Stdout,
-		Stderr: s.Stderr,
-		Dir:    s.Dir,
+		Stdin:   s.Stdin,
+		Stdout:  s.Stdout,
+		Stderr:  s.Stderr,
+		Dir:     s.Dir,
+		Report:  s.Report,
+		Trace:   s.Trace,
+		DryRun:  s.DryRun,
+		Process: s.Process,
 	}
 	// Make independent copy of environment.
 	c.Env = append(c.Env, s.Env...)
@@ -200,6 +230,16 @@ func (s *State) GetEnv(name string) string {
 	return ""
 }
 
+// Tracef writes a formatted line to the State Trace, if any, prefixed with "+ "
+// like "set -x" in a shell. Custom Jobs should use Tracef to report the runtime
+// values of operations that affect the State or the filesystem.
+func (s *State) Tracef(format string, args ...interface{}) {
+	if s.Trace == nil {
+		return
+	}
+	fmt.Fprintf(s.Trace, "+ "+format+"\n", args...)
+}
+
 // Job is the interface for an operation. A Job controls how an operation is run
 // and represented.
 type Job interface {
@@ -223,30 +263,88 @@ func Exec(cmd string, args ...string) *ExecJob {
 // System is an Exec job that interprets the given command using "/bin/sh".
 func System(cmd string) *ExecJob {
 	return &ExecJob{
-		name: "/bin/sh",
-		args: []string{"-c", cmd},
+		name:  "/bin/sh",
+		args:  []string{"-c", cmd},
+		shell: true,
 	}
 }
 
 // ExecJob implements the Job interface for basic process execution.
 type ExecJob struct {
-	name string
-	args []string
+	name  string
+	args  []string
+	shell bool
 }
 
-// Run executes the command.
+// Run executes the command. If the State has a Report, a Result is added to it
+// once the command completes. If the State is a DryRun, the command is traced
+// and its input is discarded, but it does not run. If the State has a Process,
+// it controls how the command is started and terminated.
 func (f *ExecJob) Run(ctx context.Context, s *State) error {
-	cmd := exec.CommandContext(ctx, f.name, f.args...)
+	if s.Trace != nil {
+		traced := f.command()
+		if f.shell {
+			// Show variables as the shell will expand them.
+			traced = f.name + " -c " + os.Expand(f.args[1], s.GetEnv)
+		}
+		if s.DryRun {
+			traced += " # dry run"
+		}
+		s.Tracef("[%s] %s", s.Dir, traced)
+	}
+	if s.DryRun {
+		return discard(ctx, s.Stdin)
+	}
+	var cmd *exec.Cmd
+	if s.Process == nil {
+		cmd = exec.CommandContext(ctx, f.name, f.args...)
+	} else {
+		// Process terminates the command when the context is canceled.
+		cmd = exec.Command(f.name, f.args...)
+	}
 	cmd.Dir = s.Dir
 	cmd.Env = s.Env
 	cmd.Stdin = s.Stdin
 	cmd.Stdout = s.Stdout
 	cmd.Stderr = s.Stderr
-	err := cmd.Start()
-	if err != nil {
-		return err
+	if s.Report == nil {
+		return f.wait(ctx, cmd, s.Process)
+	}
+	var tail *tailWriter
+	finish := func() {}
+	if s.Report.tailSize() > 0 {
+		tail = &tailWriter{w: s.Stderr, max: s.Report.tailSize()}
+		var err error
+		if finish, err = captureStderr(cmd, tail); err != nil {
+			return err
+		}
+	}
+	start := time.Now()
+	err := f.wait(ctx, cmd, s.Process)
+	// Waiting for stderr is not part of the Wall time.
+	wall := time.Since(start)
+	finish()
+	res := newResult(f.command(), start, wall, cmd.ProcessState, err)
+	if tail != nil {
+		res.StderrTail = tail.bytes()
 	}
-	if err := cmd.Wait(); err != nil {
+	s.Report.add(res)
+	return err
+}
+
+func (f *ExecJob) wait(ctx context.Context, cmd *exec.Cmd, p *Process) error {
+	wait := cmd.Wait
+	if p == nil {
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if wait, err = p.start(ctx, cmd); err != nil {
+			return err
+		}
+	}
+	if err := wait(); err != nil {
 		return fmt.Errorf("%w: %s %s", err, f.name, strings.Join(f.args, " "))
 	}
 	return nil
@@ -254,11 +352,15 @@ func (f *ExecJob) Run(ctx context.Context, s *State) error {
 
 // Describe generates a description for this command.
 func (f *ExecJob) Describe(d *Description) {
+	d.Append(f.command())
+}
+
+func (f *ExecJob) command() string {
 	args := ""
 	if len(f.args) > 0 {
 		args = " " + strings.Join(f.args, " ")
 	}
-	d.Append(f.name + args)
+	return f.name + args
 }
 
 // Func creates a new FuncJob that runs the given function. Job functions should
@@ -310,6 +412,16 @@ func (r *readerCtx) Read(p []byte) (n int, err error) {
 	return r.Reader.Read(p)
 }
 
+// discard reads all input from r, so that writers earlier in a Pipe do not
+// block. Files, such as os.Stdin, are not read.
+func discard(ctx context.Context, r io.Reader) error {
+	if _, ok := r.(*os.File); ok || r == nil {
+		return nil
+	}
+	_, err := io.Copy(ioutil.Discard, NewReaderContext(ctx, r))
+	return err
+}
+
 // Read creates a Job that reads from the given reader and writes it to Job's
 // stdout. Read creates a context-aware reader from the given io.Reader.
 func Read(r io.Reader) Job {
@@ -329,6 +441,7 @@ func Read(r io.Reader) Job {
 func ReadFile(path string) Job {
 	return &FuncJob{
 		Job: func(ctx context.Context, s *State) error {
+			s.Tracef("cat < %s", s.Path(path))
 			file, err := os.Open(s.Path(path))
 			if err != nil {
 				return err
@@ -363,6 +476,11 @@ func Write(w io.Writer) Job {
 func WriteFile(path string, perm os.FileMode) Job {
 	return &FuncJob{
 		Job: func(ctx context.Context, s *State) error {
+			if s.DryRun {
+				s.Tracef("cat > %s # dry run", s.Path(path))
+				return discard(ctx, s.Stdin)
+			}
+			s.Tracef("cat > %s", s.Path(path))
 			file, err := os.OpenFile(s.Path(path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 			if err != nil {
 				return err
@@ -384,6 +502,7 @@ func Chdir(dir string) Job {
 	return &FuncJob{
 		Job: func(ctx context.Context, s *State) error {
 			s.Dir = s.Path(dir)
+			s.Tracef("cd %s", s.Dir)
 			return nil
 		},
 		Desc: func(d *Description) {
@@ -398,8 +517,8 @@ func Chdir(dir string) Job {
 func Println(message string) Job {
 	return &FuncJob{
 		Job: func(ctx context.Context, s *State) error {
-			message = os.Expand(message, s.GetEnv)
-			_, err := s.Stdout.Write([]byte(message + "\n"))
+			expanded := os.Expand(message, s.GetEnv)
+			_, err := s.Stdout.Write([]byte(expanded + "\n"))
 			return err
 		},
 		Desc: func(d *Description) {
@@ -414,6 +533,7 @@ func SetEnv(name string, value string) Job {
 	return &FuncJob{
 		Job: func(ctx context.Context, s *State) error {
 			s.SetEnv(name, value)
+			s.Tracef("export %s=%q", name, value)
 			return nil
 		},
 		Desc: func(d *Description) {
@@ -430,14 +550,20 @@ func SetEnvFromJob(name string, job Job) Job {
 		Job: func(ctx context.Context, s *State) error {
 			b := &bytes.Buffer{}
 			s2 := &State{
-				Stdout: b,
-				Env:    append([]string(nil), s.Env...),
+				Stdout:  b,
+				Dir:     s.Dir,
+				Env:     append([]string(nil), s.Env...),
+				Report:  s.Report,
+				Trace:   s.Trace,
+				DryRun:  s.DryRun,
+				Process: s.Process,
 			}
 			err := job.Run(ctx, s2)
 			if err != nil {
 				return err
 			}
 			s.SetEnv(name, strings.TrimSpace(b.String()))
+			s.Tracef("export %s=%q", name, s.GetEnv(name))
 			return nil
 		},
 		Desc: func(d *Description) {
@@ -508,8 +634,16 @@ type ScriptJob struct {
 }
 
 // Run sequentially executes every Job in the script. Any Job error stops
-// execution and generates an error describing the command that failed.
+// execution and generates an error describing the command that failed. If the
+// State has a Report, a Result for the whole script is added to it after the
+// Results of its Jobs.
 func (c *ScriptJob) Run(ctx context.Context, s *State) error {
+	return recordJob(s, c, func() error {
+		return c.run(ctx, s)
+	})
+}
+
+func (c *ScriptJob) run(ctx context.Context, s *State) error {
 	z := s.copy()
 	for i := range c.Jobs {
 		err := c.Jobs[i].Run(ctx, z)
@@ -518,7 +652,7 @@ func (c *ScriptJob) Run(ctx context.Context, s *State) error {
 			d := &Description{}
 			c.Describe(d)
 			str := d.String()
-			return fmt.Errorf("%w:\n%s - %s", ErrScriptError, str, err.Error())
+			return &scriptError{desc: str, err: err}
 		}
 		// All other errors.
 		if err != nil {
@@ -528,6 +662,25 @@ func (c *ScriptJob) Run(ctx context.Context, s *State) error {
 	return nil
 }
 
+// scriptError is an ErrScriptError that also wraps the error of the Job that
+// failed, so that callers may still inspect it with errors.Is and errors.As.
+type scriptError struct {
+	desc string
+	err  error
+}
+
+func (e *scriptError) Error() string {
+	return fmt.Sprintf("%s:\n%s - %s", ErrScriptError, e.desc, e.err)
+}
+
+func (e *scriptError) Is(target error) bool {
+	return target == ErrScriptError
+}
+
+func (e *scriptError) Unwrap() error {
+	return e.err
+}
+
 // Describe generates a description for all jobs in the script.
 func (c *ScriptJob) Describe(d *Description) {
 	d.Append("(")
@@ -557,18 +710,34 @@ type PipeJob struct {
 // Run executes every Job in the pipeline. The stdout from the first command is
 // passed to the stdin to the next command. The stderr for all commands is
 // inherited from the given State. If any Job returns an error, the first error
-// is returned for the entire PipeJob.
+// is returned for the entire PipeJob. If the State has a Report, a Result for
+// the whole pipeline is added to it after the Results of its Jobs.
 func (c *PipeJob) Run(ctx context.Context, z *State) error {
+	return recordJob(z, c, func() error {
+		return c.run(ctx, z)
+	})
+}
+
+func (c *PipeJob) run(ctx context.Context, z *State) error {
 	e := c.Jobs
 	p := nPipes(z.Stdin, z.Stdout, len(e))
 	s := make([]*State, len(e))
+	trace := z.Trace
+	if trace != nil {
+		// Jobs in the pipeline trace concurrently.
+		trace = &syncWriter{w: trace}
+	}
 	for i := range e {
 		s[i] = &State{
-			Stdin:  p[i].R,
-			Stdout: p[i].W,
-			Stderr: z.Stderr,
-			Dir:    z.Dir,
-			Env:    z.Env,
+			Stdin:   p[i].R,
+			Stdout:  p[i].W,
+			Stderr:  z.Stderr,
+			Dir:     z.Dir,
+			Env:     z.Env,
+			Report:  z.Report,
+			Trace:   trace,
+			DryRun:  z.DryRun,
+			Process: z.Process,
 		}
 	}
 	// Create channel for all pipe job return values.
@@ -395,6 +395,18 @@ func TestDescribe(t *testing.T) {
 			job:  Pipe(Exec("cat", "foo.list"), Pipe(Exec("echo", "ok"), Exec("cat"))),
 			want: " 1: cat foo.list | echo ok | cat\n",
 		},
+		{
+			// A nested sequence that comes first is not preceded by a
+			// separator, and is followed by one.
+			name: "pipe-pipe-first",
+			job:  Pipe(Pipe(Exec("echo", "ok"), Exec("cat")), Exec("cat", "-n"), Exec("cat")),
+			want: " 1: echo ok | cat | cat -n | cat\n",
+		},
+		{
+			name: "pipe-setenv-first",
+			job:  Pipe(SetEnvFromJob("key", Exec("echo", "ok")), Exec("cat")),
+			want: " 1: export key=$(echo ok) | cat\n",
+		},
 		{
 			name: "script",
 			job:  Script(Exec("echo", "ok")),
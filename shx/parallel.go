@@ -0,0 +1,394 @@
+package shx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrParallelError is a base Parallel and Graph error.
+	ErrParallelError = errors.New("parallel execution error")
+
+	// ErrGraphInvalid is returned by a GraphJob whose dependencies are
+	// undefined, duplicated, or cyclic.
+	ErrGraphInvalid = errors.New("invalid job graph")
+
+	// ErrDependencyFailed is reported for GraphJob jobs that did not run
+	// because one of their dependencies failed.
+	ErrDependencyFailed = errors.New("dependency failed")
+)
+
+// MultiError collects the errors from several Jobs that ran concurrently.
+type MultiError struct {
+	Errors []error
+}
+
+// Error reports every collected error on its own line.
+func (m *MultiError) Error() string {
+	s := make([]string, len(m.Errors))
+	for i := range m.Errors {
+		s[i] = m.Errors[i].Error()
+	}
+	return fmt.Sprintf("%s: %d job(s) failed:\n%s", ErrParallelError, len(m.Errors), strings.Join(s, "\n"))
+}
+
+// Is reports whether target is ErrParallelError or matches any collected
+// error.
+func (m *MultiError) Is(target error) bool {
+	if target == ErrParallelError {
+		return true
+	}
+	for _, err := range m.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parallel creates a Job that executes the given Jobs concurrently, like
+// running them in the background of a shell and waiting for all of them. By
+// default, there is no limit on concurrency and the first error cancels the
+// remaining Jobs.
+func Parallel(t ...Job) *ParallelJob {
+	return &ParallelJob{
+		Jobs: t,
+	}
+}
+
+// ParallelJob implements the Job interface for running Jobs concurrently.
+type ParallelJob struct {
+	Jobs []Job
+
+	// Limit is the maximum number of Jobs that may run at once. Zero means
+	// there is no limit.
+	Limit int
+
+	// CollectAll controls what happens when a Job fails. When false, the
+	// first error cancels the context of every other Job and is returned.
+	// When true, every Job runs to completion and all errors are returned
+	// together as a *MultiError.
+	CollectAll bool
+}
+
+// Run executes every Job concurrently, each with an independent copy of the
+// State, like a sub-Script. Writes to Stdout and Stderr are serialized, but may
+// be interleaved between Jobs. Because background jobs cannot share input,
+// every Job reads from an empty Stdin.
+func (c *ParallelJob) Run(ctx context.Context, s *State) error {
+	g := Graph()
+	g.Limit = c.Limit
+	g.CollectAll = c.CollectAll
+	for i := range c.Jobs {
+		g.Add(fmt.Sprint(i), c.Jobs[i])
+	}
+	return g.Run(ctx, s)
+}
+
+// Describe generates a description for all jobs, e.g. "( a & b & wait )".
+func (c *ParallelJob) Describe(d *Description) {
+	describeParallel(d, c.Jobs)
+}
+
+func describeParallel(d *Description, jobs []Job) {
+	endlist := d.StartSequence("( ", " & ")
+	for i := range jobs {
+		jobs[i].Describe(d)
+	}
+	endlist(" & wait )")
+}
+
+// Graph creates an empty GraphJob. Add named Jobs and their dependencies with
+// GraphJob.Add.
+func Graph() *GraphJob {
+	return &GraphJob{
+		byName: map[string]*graphNode{},
+	}
+}
+
+// GraphJob implements the Job interface for running a dependency graph of
+// Jobs. Every Job starts as soon as all of its dependencies have completed
+// successfully.
+type GraphJob struct {
+	// Limit is the maximum number of Jobs that may run at once. Zero means
+	// there is no limit.
+	Limit int
+
+	// CollectAll controls what happens when a Job fails. When false, the
+	// first error cancels every other Job and is returned. When true, Jobs
+	// that do not depend on the failed Job continue to run, Jobs that do are
+	// skipped with ErrDependencyFailed, and all errors are returned together
+	// as a *MultiError.
+	CollectAll bool
+
+	nodes  []*graphNode
+	byName map[string]*graphNode
+	err    error
+}
+
+type graphNode struct {
+	name string
+	job  Job
+	deps []string
+}
+
+// Add adds the named Job to the graph. The Job will not start until every
+// Job named in deps has completed successfully. Dependencies may be added
+// before or after the Jobs that depend on them. Add returns the GraphJob so
+// that calls may be chained.
+func (g *GraphJob) Add(name string, job Job, deps ...string) *GraphJob {
+	if _, ok := g.byName[name]; ok {
+		g.err = fmt.Errorf("%w: duplicate job %q", ErrGraphInvalid, name)
+		return g
+	}
+	n := &graphNode{name: name, job: job, deps: deps}
+	g.nodes = append(g.nodes, n)
+	g.byName[name] = n
+	return g
+}
+
+// levels groups the graph into levels, where every Job depends only on Jobs in
+// earlier levels. An error is returned if the graph is not a valid DAG.
+func (g *GraphJob) levels() ([][]*graphNode, error) {
+	if g.err != nil {
+		return nil, g.err
+	}
+	level := map[string]int{}
+	var visit func(n *graphNode, path []string) (int, error)
+	visit = func(n *graphNode, path []string) (int, error) {
+		if l, ok := level[n.name]; ok {
+			if l < 0 {
+				return 0, fmt.Errorf("%w: cycle %s", ErrGraphInvalid, strings.Join(append(path, n.name), " -> "))
+			}
+			return l, nil
+		}
+		level[n.name] = -1
+		l := 0
+		for _, dep := range n.deps {
+			d, ok := g.byName[dep]
+			if !ok {
+				return 0, fmt.Errorf("%w: %q depends on undefined job %q", ErrGraphInvalid, n.name, dep)
+			}
+			dl, err := visit(d, append(path, n.name))
+			if err != nil {
+				return 0, err
+			}
+			if dl+1 > l {
+				l = dl + 1
+			}
+		}
+		level[n.name] = l
+		return l, nil
+	}
+	var levels [][]*graphNode
+	for _, n := range g.nodes {
+		l, err := visit(n, nil)
+		if err != nil {
+			return nil, err
+		}
+		for len(levels) <= l {
+			levels = append(levels, nil)
+		}
+	}
+	// Preserve the order in which Jobs were added within each level.
+	for _, n := range g.nodes {
+		levels[level[n.name]] = append(levels[level[n.name]], n)
+	}
+	return levels, nil
+}
+
+// Run executes the graph. Every Job runs with an independent copy of the
+// State, like a sub-Script. Writes to Stdout and Stderr are serialized, but may
+// be interleaved between Jobs. Every Job reads from an empty Stdin.
+func (g *GraphJob) Run(ctx context.Context, s *State) error {
+	if _, err := g.levels(); err != nil {
+		return err
+	}
+	ctx2, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stdout := &syncWriter{w: s.Stdout}
+	stderr := &syncWriter{w: s.Stderr}
+	if sameWriter(s.Stderr, s.Stdout) {
+		stderr = stdout
+	}
+	var trace io.Writer
+	if s.Trace != nil {
+		trace = &syncWriter{w: s.Trace}
+	}
+	var sem chan struct{}
+	if g.Limit > 0 {
+		sem = make(chan struct{}, g.Limit)
+	}
+	done := map[string]chan struct{}{}
+	failed := map[string]bool{}
+	for _, n := range g.nodes {
+		done[n.name] = make(chan struct{})
+	}
+
+	mu := sync.Mutex{}
+	var errs []error
+	wg := sync.WaitGroup{}
+	for _, n := range g.nodes {
+		wg.Add(1)
+		go func(n *graphNode) {
+			defer wg.Done()
+			defer close(done[n.name])
+			fail := func(err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				failed[n.name] = true
+				errs = append(errs, err)
+				if !g.CollectAll {
+					cancel()
+				}
+			}
+			for _, dep := range n.deps {
+				<-done[dep]
+				mu.Lock()
+				f := failed[dep]
+				mu.Unlock()
+				if f {
+					mu.Lock()
+					failed[n.name] = true
+					mu.Unlock()
+					if g.CollectAll {
+						fail(fmt.Errorf("%w: %s: %q", ErrDependencyFailed, n.name, dep))
+					}
+					return
+				}
+			}
+			if sem != nil {
+				select {
+				case sem <- struct{}{}:
+					defer func() { <-sem }()
+				case <-ctx2.Done():
+					fail(ctx2.Err())
+					return
+				}
+			}
+			if ctx2.Err() != nil {
+				fail(ctx2.Err())
+				return
+			}
+			z := s.copy()
+			z.Stdin = eofReader{}
+			z.Stdout = stdout
+			z.Stderr = stderr
+			z.Trace = trace
+			if err := n.job.Run(ctx2, z); err != nil {
+				fail(describeError(n.job, err))
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	if !g.CollectAll {
+		// Jobs canceled because of the first error also report errors.
+		return errs[0]
+	}
+	return &MultiError{Errors: errs}
+}
+
+// describeError annotates err with the description of the Job that failed,
+// unless it was already annotated by a nested Script, Parallel, or Graph.
+func describeError(job Job, err error) error {
+	if errors.Is(err, ErrScriptError) || errors.Is(err, ErrParallelError) {
+		return err
+	}
+	d := &Description{}
+	job.Describe(d)
+	return &jobError{desc: d.String(), err: err}
+}
+
+// jobError is an ErrParallelError that also wraps the error of the Job that
+// failed, so that callers may still inspect it with errors.Is and errors.As.
+type jobError struct {
+	desc string
+	err  error
+}
+
+func (e *jobError) Error() string {
+	return fmt.Sprintf("%s:\n%s - %s", ErrParallelError, e.desc, e.err)
+}
+
+func (e *jobError) Is(target error) bool {
+	return target == ErrParallelError
+}
+
+func (e *jobError) Unwrap() error {
+	return e.err
+}
+
+// Describe generates a description of the graph as a sequence of parallel
+// groups, where every Job depends only on Jobs in earlier groups.
+func (g *GraphJob) Describe(d *Description) {
+	levels, err := g.levels()
+	if err != nil {
+		d.Append(fmt.Sprintf("# %v", err))
+		return
+	}
+	d.Append("(")
+	d.Depth++
+	for _, level := range levels {
+		jobs := make([]Job, len(level))
+		for i := range level {
+			jobs[i] = level[i].job
+		}
+		describeParallel(d, jobs)
+	}
+	d.Depth--
+	d.Append(")")
+}
+
+// syncWriter serializes writes from concurrent Jobs.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		// Like a State without Stdout, discard output.
+		return len(p), nil
+	}
+	return s.w.Write(p)
+}
+
+// sameWriter returns true if a and b may be the same writer, so that writes to
+// them must be serialized together. Unlike a == b, it does not panic for
+// writers that can not be compared, which are considered the same if their
+// types are.
+func sameWriter(a, b io.Writer) (same bool) {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	// Even writers of comparable types, like structs with interface fields,
+	// panic if they hold values that are not comparable.
+	defer func() {
+		if recover() != nil {
+			same = true
+		}
+	}()
+	return a == b
+}
+
+// eofReader is an empty Stdin.
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
@@ -0,0 +1,369 @@
+package shx_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/m-lab/go/shx"
+)
+
+// recorder records the order in which Jobs complete.
+type recorder struct {
+	mu    sync.Mutex
+	order []string
+}
+
+func (r *recorder) job(name string, delay time.Duration, err error) Job {
+	return Func(name, func(ctx context.Context, s *State) error {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.order = append(r.order, name)
+		return err
+	})
+}
+
+func TestParallel(t *testing.T) {
+	errFail := errors.New("fail")
+	tests := []struct {
+		name       string
+		jobs       []string
+		errs       map[string]error
+		collectAll bool
+		limit      int
+		want       []string
+		wantErr    error
+	}{
+		{
+			name: "success",
+			jobs: []string{"a", "b", "c"},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name:    "fail-fast",
+			jobs:    []string{"a", "b", "c"},
+			errs:    map[string]error{"a": errFail},
+			want:    []string{"a"},
+			wantErr: errFail,
+		},
+		{
+			name:       "collect-all",
+			jobs:       []string{"a", "b", "c"},
+			errs:       map[string]error{"a": errFail, "c": errFail},
+			collectAll: true,
+			want:       []string{"a", "b", "c"},
+			wantErr:    errFail,
+		},
+		{
+			name:  "limit",
+			jobs:  []string{"a", "b", "c", "d"},
+			limit: 1,
+			want:  []string{"a", "b", "c", "d"},
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{}
+			p := Parallel()
+			for i, name := range tt.jobs {
+				// The first job is fast; the rest are slow enough to be canceled.
+				delay := time.Duration(i) * 50 * time.Millisecond
+				p.Jobs = append(p.Jobs, r.job(name, delay, tt.errs[name]))
+			}
+			p.Limit = tt.limit
+			p.CollectAll = tt.collectAll
+			err := p.Run(context.Background(), New())
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("Parallel.Run() wrong error; got %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil && !errors.Is(err, ErrParallelError) {
+				t.Errorf("Parallel.Run() error should be ErrParallelError; got %v", err)
+			}
+			sort.Strings(r.order)
+			if strings.Join(r.order, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Parallel.Run() completed jobs; got %v, want %v", r.order, tt.want)
+			}
+			if tt.collectAll && err != nil {
+				m := &MultiError{}
+				if !errors.As(err, &m) || len(m.Errors) != len(tt.errs) {
+					t.Errorf("Parallel.Run() wrong MultiError; got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestParallelLimit(t *testing.T) {
+	var running, max int32
+	job := Func("count", func(ctx context.Context, s *State) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	p := Parallel(job, job, job, job, job, job)
+	p.Limit = 2
+	if err := p.Run(context.Background(), New()); err != nil {
+		t.Fatal(err)
+	}
+	if max != 2 {
+		t.Errorf("Parallel.Run() concurrency; got %d, want 2", max)
+	}
+}
+
+func TestParallelState(t *testing.T) {
+	b := &bytes.Buffer{}
+	s := &State{
+		Stdin:  strings.NewReader("should not be read"),
+		Stdout: b,
+		Env:    []string{"KEY=ORIGINAL"},
+	}
+	p := Parallel(
+		Script(SetEnv("KEY", "CHANGED"), System("echo $KEY")),
+		Read(strings.NewReader("ok\n")),
+		System("cat"),
+	)
+	if err := p.Run(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetEnv("KEY") != "ORIGINAL" {
+		t.Errorf("Parallel.Run() modified the original State; got KEY=%s", s.GetEnv("KEY"))
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	sort.Strings(lines)
+	if strings.Join(lines, ",") != "CHANGED,ok" {
+		t.Errorf("Parallel.Run() wrong output; got %q", b.String())
+	}
+}
+
+// unhashableWriter is a writer of a type that can not be compared with ==.
+type unhashableWriter struct {
+	names []string
+	b     *bytes.Buffer
+}
+
+func (w unhashableWriter) Write(p []byte) (int, error) {
+	return w.b.Write(p)
+}
+
+// wrappedWriter is a writer of a comparable type that panics when compared
+// with == if it holds an unhashableWriter.
+type wrappedWriter struct {
+	io.Writer
+}
+
+func TestParallelUncomparableWriter(t *testing.T) {
+	b := &bytes.Buffer{}
+	for _, w := range []io.Writer{
+		unhashableWriter{b: b},
+		wrappedWriter{unhashableWriter{b: b}},
+	} {
+		b.Reset()
+		s := &State{Stdout: w, Stderr: w}
+		if err := Parallel(System("echo out"), System("echo err >&2")).Run(context.Background(), s); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		sort.Strings(lines)
+		if strings.Join(lines, ",") != "err,out" {
+			t.Errorf("Parallel.Run() wrong output with %T; got %q", w, b.String())
+		}
+	}
+}
+
+func TestGraph(t *testing.T) {
+	errFail := errors.New("fail")
+	tests := []struct {
+		name       string
+		build      func(g *GraphJob, r *recorder)
+		collectAll bool
+		want       string
+		wantErr    error
+	}{
+		{
+			name: "success",
+			build: func(g *GraphJob, r *recorder) {
+				// Added out of order; "c" is fast but must wait for "a" and "b".
+				g.Add("c", r.job("c", 0, nil), "a", "b")
+				g.Add("a", r.job("a", 20*time.Millisecond, nil))
+				g.Add("b", r.job("b", 10*time.Millisecond, nil))
+				g.Add("d", r.job("d", 0, nil), "c")
+			},
+			want: "b,a,c,d",
+		},
+		{
+			name: "fail-fast",
+			build: func(g *GraphJob, r *recorder) {
+				g.Add("a", r.job("a", 0, errFail))
+				g.Add("b", r.job("b", 0, nil), "a")
+				g.Add("c", r.job("c", time.Second, nil))
+			},
+			want:    "a",
+			wantErr: errFail,
+		},
+		{
+			name: "collect-all-skips-dependents",
+			build: func(g *GraphJob, r *recorder) {
+				g.Add("a", r.job("a", 0, errFail))
+				g.Add("b", r.job("b", 0, nil), "a")
+				g.Add("c", r.job("c", 10*time.Millisecond, nil))
+				g.Add("d", r.job("d", 0, nil), "c")
+			},
+			collectAll: true,
+			want:       "a,c,d",
+			wantErr:    ErrDependencyFailed,
+		},
+		{
+			name: "error-undefined",
+			build: func(g *GraphJob, r *recorder) {
+				g.Add("a", r.job("a", 0, nil), "missing")
+			},
+			wantErr: ErrGraphInvalid,
+		},
+		{
+			name: "error-duplicate",
+			build: func(g *GraphJob, r *recorder) {
+				g.Add("a", r.job("a", 0, nil))
+				g.Add("a", r.job("a", 0, nil))
+			},
+			wantErr: ErrGraphInvalid,
+		},
+		{
+			name: "error-cycle",
+			build: func(g *GraphJob, r *recorder) {
+				g.Add("a", r.job("a", 0, nil), "c")
+				g.Add("b", r.job("b", 0, nil), "a")
+				g.Add("c", r.job("c", 0, nil), "b")
+			},
+			wantErr: ErrGraphInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{}
+			g := Graph()
+			g.CollectAll = tt.collectAll
+			tt.build(g, r)
+			err := g.Run(context.Background(), New())
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("Graph.Run() wrong error; got %v, want %v", err, tt.wantErr)
+			}
+			got := strings.Join(r.order, ",")
+			if tt.collectAll {
+				sort.Strings(r.order)
+				got = strings.Join(r.order, ",")
+			}
+			if got != tt.want {
+				t.Errorf("Graph.Run() completed jobs; got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g := Graph().Add("a", System("true"))
+	g.Limit = 1
+	if err := g.Run(ctx, New()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Graph.Run() wrong error; got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestParallelDescribe(t *testing.T) {
+	tests := []struct {
+		name string
+		job  Job
+		want string
+	}{
+		{
+			name: "parallel",
+			job:  Parallel(Exec("a"), Exec("b")),
+			want: " 1: ( a & b & wait )\n",
+		},
+		{
+			name: "parallel-pipe",
+			job:  Parallel(Pipe(Exec("a"), Exec("b")), Exec("c")),
+			want: " 1: ( a | b & c & wait )\n",
+		},
+		{
+			name: "graph",
+			job: Graph().
+				Add("test", Exec("test"), "build").
+				Add("build", Exec("build")).
+				Add("fetch", Exec("fetch")).
+				Add("deploy", Exec("deploy"), "test", "fetch"),
+			want: " 1: (\n 2:   ( build & fetch & wait )\n 3:   ( test & wait )\n 4:   ( deploy & wait )\n 5: )\n",
+		},
+		{
+			name: "graph-invalid",
+			job:  Graph().Add("a", Exec("a"), "b"),
+			want: " 1: # invalid job graph: \"a\" depends on undefined job \"b\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Description{}
+			tt.job.Describe(d)
+			if got := d.String(); got != tt.want {
+				t.Errorf("Describe() wrong result; got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	m := &MultiError{Errors: []error{errors.New("one"), errors.New("two")}}
+	want := "parallel execution error: 2 job(s) failed:\none\ntwo"
+	if m.Error() != want {
+		t.Errorf("MultiError.Error() got %q, want %q", m.Error(), want)
+	}
+	if errors.Is(m, ErrGraphInvalid) {
+		t.Errorf("MultiError.Is() should not match unrelated errors")
+	}
+}
+
+func ExampleParallelJob_Describe() {
+	p := Parallel(
+		Exec("sleep", "1"),
+		Exec("sleep", "2"),
+	)
+	d := &Description{}
+	p.Describe(d)
+	fmt.Println(d.String())
+	// Output:  1: ( sleep 1 & sleep 2 & wait )
+}
+
+func ExampleGraphJob_Run() {
+	g := Graph().
+		Add("second", Println("second"), "first").
+		Add("first", Println("first"))
+	err := g.Run(context.Background(), New())
+	if err != nil {
+		panic(err)
+	}
+	// Output: first
+	// second
+}
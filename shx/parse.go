@@ -0,0 +1,685 @@
+package shx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrParse is a base Parse error.
+var ErrParse = errors.New("parse error")
+
+// Parse converts a script written in a subset of the POSIX shell language into
+// the equivalent Job. The supported subset is:
+//
+//   - Simple commands, e.g. `ls -l`, run with Exec.
+//   - Pipelines, e.g. `ls | wc -l`, run with Pipe.
+//   - Sequences separated by newlines, `;`, or `&&`, run with Script. Like a
+//     shell script run with `set -e`, every sequence stops at the first error.
+//   - Subshells, e.g. `( cd /tmp && ls )`, run with Script, so that directory
+//     and environment changes do not affect the rest of the script.
+//   - Redirects from and to files, e.g. `sort < in > out`, run with ReadFile
+//     and WriteFile. Only the first command in a pipeline may read from a file,
+//     and only the last may write to one. `cat < file` and `cat > file` become
+//     ReadFile and WriteFile alone.
+//   - Variable assignments, e.g. `NAME=value` or `export NAME=value`, run with
+//     SetEnv, and `export NAME=$(command)`, run with SetEnvFromJob. All
+//     variables are exported. Assignments before a command, e.g.
+//     `NAME=value command`, only apply to that command.
+//   - `cd dir`, run with Chdir.
+//   - Single quotes, double quotes, backslash escapes, and `#` comments.
+//   - Variable expansion of `$NAME` and `${NAME}` from the running State
+//     environment. Expanded values are never split into multiple words, as if
+//     they were always quoted.
+//
+// Everything else, including `||`, `&`, `>>`, redirects of other file
+// descriptors such as `2>file`, control flow, unquoted glob characters (`*`,
+// `?`, and `[`), and command substitution other than as the value of an
+// assignment, is an error wrapping ErrParse.
+//
+// If the script consists of a single command, the Job for that command is
+// returned. Otherwise, the commands are returned in a ScriptJob. Because Parse
+// understands the output of Description for these Jobs (without line numbers),
+// many Jobs may be described and parsed again.
+func Parse(script string) (Job, error) {
+	p := &parser{src: script}
+	jobs, err := p.parseList(false)
+	if err != nil {
+		return nil, err
+	}
+	return oneOrScript(jobs), nil
+}
+
+func oneOrScript(jobs []Job) Job {
+	if len(jobs) == 1 {
+		return jobs[0]
+	}
+	return Script(jobs...)
+}
+
+// wordPart is a literal, a variable reference, or a command substitution.
+type wordPart struct {
+	lit  string
+	name string
+	sub  Job
+}
+
+// word is a single shell word made of parts that are expanded at runtime.
+type word []wordPart
+
+func (w *word) addLiteral(s string) {
+	if n := len(*w); n > 0 && (*w)[n-1].name == "" && (*w)[n-1].sub == nil {
+		(*w)[n-1].lit += s
+		return
+	}
+	*w = append(*w, wordPart{lit: s})
+}
+
+func (w word) static() bool {
+	for _, part := range w {
+		if part.name != "" || part.sub != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (w word) hasSub() bool {
+	for _, part := range w {
+		if part.sub != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// expand returns the value of the word using the State environment.
+func (w word) expand(s *State) string {
+	v := ""
+	for _, part := range w {
+		if part.name != "" {
+			v += s.GetEnv(part.name)
+			continue
+		}
+		v += part.lit
+	}
+	return v
+}
+
+// String renders the word, quoted if necessary, for descriptions.
+func (w word) String() string {
+	if w.static() {
+		v := w.expand(nil)
+		if v != "" && !strings.ContainsAny(v, " \t\n;&|()<>'\"\\$`#*?[]{}~=") {
+			return v
+		}
+		return "'" + strings.ReplaceAll(v, "'", `'\''`) + "'"
+	}
+	b := &strings.Builder{}
+	b.WriteString(`"`)
+	for _, part := range w {
+		if part.name != "" {
+			b.WriteString("${" + part.name + "}")
+			continue
+		}
+		for _, c := range part.lit {
+			if strings.ContainsRune("$`\"\\", c) {
+				b.WriteRune('\\')
+			}
+			b.WriteRune(c)
+		}
+	}
+	b.WriteString(`"`)
+	return b.String()
+}
+
+func render(words []word) string {
+	s := make([]string, len(words))
+	for i := range words {
+		s[i] = words[i].String()
+	}
+	return strings.Join(s, " ")
+}
+
+// assignment is a NAME=value word.
+type assignment struct {
+	name  string
+	value word
+}
+
+// command is a parsed simple command or subshell with its redirects.
+type command struct {
+	jobs []Job
+	in   Job
+	out  Job
+}
+
+type parser struct {
+	src string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	line := 1 + strings.Count(p.src[:p.pos], "\n")
+	return fmt.Errorf("%w: line %d: %s", ErrParse, line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) hasPrefix(s string) bool {
+	return strings.HasPrefix(p.src[p.pos:], s)
+}
+
+// skipBlanks skips spaces, tabs, line continuations, and comments, but not
+// newlines.
+func (p *parser) skipBlanks() {
+	for !p.done() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t':
+			p.pos++
+		case p.hasPrefix("\\\n"):
+			p.pos += 2
+		case c == '#':
+			for !p.done() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// parseList parses a sequence of commands until the end of the script, or a
+// closing parenthesis if inParen is true.
+func (p *parser) parseList(inParen bool) ([]Job, error) {
+	var jobs []Job
+	for {
+		p.skipBlanks()
+		switch c := p.peek(); {
+		case p.done():
+			if inParen {
+				return nil, p.errorf("missing \")\"")
+			}
+			return jobs, nil
+		case c == '\n' || c == ';':
+			p.pos++
+			continue
+		case c == ')':
+			if !inParen {
+				return nil, p.errorf("unexpected \")\"")
+			}
+			p.pos++
+			return jobs, nil
+		}
+		j, err := p.parseAndList()
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j...)
+		// Commands must be followed by a separator.
+		if c := p.peek(); !p.done() && c != '\n' && c != ';' && c != ')' {
+			return nil, p.errorf("unexpected %q", c)
+		}
+	}
+}
+
+// parseAndList parses pipelines separated by "&&".
+func (p *parser) parseAndList() ([]Job, error) {
+	var jobs []Job
+	for {
+		j, err := p.parsePipeline()
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j...)
+		p.skipBlanks()
+		switch {
+		case p.hasPrefix("&&"):
+			p.pos += 2
+			// Newlines may follow "&&".
+			for p.skipBlanks(); p.peek() == '\n'; p.skipBlanks() {
+				p.pos++
+			}
+		case p.hasPrefix("||"):
+			return nil, p.errorf("\"||\" is not supported")
+		case p.peek() == '&':
+			return nil, p.errorf("background jobs (\"&\") are not supported")
+		default:
+			return jobs, nil
+		}
+	}
+}
+
+// parsePipeline parses commands separated by "|".
+func (p *parser) parsePipeline() ([]Job, error) {
+	var cmds []*command
+	for {
+		cmd, err := p.parseCommand()
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+		p.skipBlanks()
+		if p.peek() != '|' || p.hasPrefix("||") {
+			break
+		}
+		p.pos++
+		// Newlines may follow "|".
+		for p.skipBlanks(); p.peek() == '\n'; p.skipBlanks() {
+			p.pos++
+		}
+	}
+	if len(cmds) == 1 && cmds[0].in == nil && cmds[0].out == nil {
+		return cmds[0].jobs, nil
+	}
+	var jobs []Job
+	for i, cmd := range cmds {
+		if len(cmd.jobs) > 1 {
+			return nil, p.errorf("assignments are not supported in a pipeline")
+		}
+		if cmd.in != nil {
+			if i != 0 {
+				return nil, p.errorf("only the first command in a pipeline may read from a file")
+			}
+			jobs = append(jobs, cmd.in)
+		}
+		jobs = append(jobs, cmd.jobs...)
+		if cmd.out != nil {
+			if i != len(cmds)-1 {
+				return nil, p.errorf("only the last command in a pipeline may write to a file")
+			}
+			jobs = append(jobs, cmd.out)
+		}
+	}
+	if len(jobs) == 1 {
+		return jobs, nil
+	}
+	return []Job{Pipe(jobs...)}, nil
+}
+
+// parseCommand parses a subshell or a simple command with its assignments and
+// redirects.
+func (p *parser) parseCommand() (*command, error) {
+	p.skipBlanks()
+	if p.peek() == '(' {
+		p.pos++
+		jobs, err := p.parseList(true)
+		if err != nil {
+			return nil, err
+		}
+		return &command{jobs: []Job{Script(jobs...)}}, nil
+	}
+	var assigns []assignment
+	var words []word
+	var in, out word
+	for {
+		p.skipBlanks()
+		if p.done() || strings.IndexByte("\n;&|()", p.peek()) >= 0 {
+			break
+		}
+		if c := p.peek(); c == '<' || c == '>' {
+			p.pos++
+			if p.peek() == '>' || p.peek() == '&' || p.peek() == '<' {
+				return nil, p.errorf("redirect %q is not supported", string(c)+string(p.peek()))
+			}
+			p.skipBlanks()
+			w, _, err := p.parseWord()
+			if err != nil {
+				return nil, err
+			}
+			if w == nil {
+				return nil, p.errorf("missing file name after %q", c)
+			}
+			if (c == '<' && in != nil) || (c == '>' && out != nil) {
+				return nil, p.errorf("more than one %q redirect", c)
+			}
+			if c == '<' {
+				in = w
+			} else {
+				out = w
+			}
+			continue
+		}
+		start := p.pos
+		w, name, err := p.parseWord()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.peek(); (c == '<' || c == '>') && isDigits(p.src[start:p.pos]) {
+			// In sh, "2>file" redirects file descriptor 2.
+			return nil, p.errorf("redirect %q is not supported", p.src[start:p.pos]+string(c))
+		}
+		if name != "" && len(words) == 0 {
+			assigns = append(assigns, splitAssignment(w, name))
+			continue
+		}
+		words = append(words, w)
+	}
+	return p.buildCommand(assigns, words, in, out)
+}
+
+func splitAssignment(w word, name string) assignment {
+	value := append(word{}, w...)
+	value[0].lit = value[0].lit[len(name)+1:]
+	if value[0].lit == "" && len(value) > 1 {
+		value = value[1:]
+	}
+	return assignment{name: name, value: value}
+}
+
+func (p *parser) buildCommand(assigns []assignment, words []word, in, out word) (*command, error) {
+	if in.hasSub() || out.hasSub() {
+		return nil, p.errorf("command substitution is not supported in a redirect")
+	}
+	cmd := &command{}
+	if in != nil {
+		cmd.in = dynamic("cat < "+in.String(), []word{in}, func(a []string) Job { return ReadFile(a[0]) })
+	}
+	if out != nil {
+		cmd.out = dynamic("cat > "+out.String(), []word{out}, func(a []string) Job { return WriteFile(a[0], 0666) })
+	}
+	switch {
+	case len(words) == 0 && (in != nil || out != nil):
+		return nil, p.errorf("missing command for redirect")
+	case len(words) == 0 && len(assigns) == 0:
+		if p.done() {
+			return nil, p.errorf("missing command at end of script")
+		}
+		return nil, p.errorf("unexpected %q", p.peek())
+	case len(words) == 0:
+		// Assignments alone modify the State environment.
+		for _, a := range assigns {
+			j, err := p.setEnv(a)
+			if err != nil {
+				return nil, err
+			}
+			cmd.jobs = append(cmd.jobs, j)
+		}
+		return cmd, nil
+	}
+	for _, a := range assigns {
+		if a.value.hasSub() {
+			return nil, p.errorf("command substitution is not supported before a command")
+		}
+	}
+	name := words[0].expand(nil)
+	isExport := words[0].static() && name == "export" && len(assigns) == 0 && in == nil && out == nil
+	for _, w := range words {
+		if !isExport && w.hasSub() {
+			return nil, p.errorf("command substitution is only supported as the value of an assignment")
+		}
+	}
+	switch {
+	case isExport:
+		for _, w := range words[1:] {
+			if len(w) == 0 || w[0].name != "" || w[0].sub != nil || !strings.Contains(w[0].lit, "=") {
+				return nil, p.errorf("export requires NAME=value arguments")
+			}
+			n := w[0].lit[:strings.Index(w[0].lit, "=")]
+			if !isName(n) {
+				return nil, p.errorf("invalid variable name %q", n)
+			}
+			j, err := p.setEnv(splitAssignment(w, n))
+			if err != nil {
+				return nil, err
+			}
+			cmd.jobs = append(cmd.jobs, j)
+		}
+		return cmd, nil
+	case words[0].static() && name == "cd" && len(assigns) == 0 && in == nil && out == nil:
+		if len(words) != 2 {
+			return nil, p.errorf("cd requires exactly one directory")
+		}
+		cmd.jobs = []Job{dynamic("cd "+words[1].String(), words[1:], func(a []string) Job { return Chdir(a[0]) })}
+		return cmd, nil
+	case words[0].static() && name == "cat" && len(words) == 1 && len(assigns) == 0 && (in != nil || out != nil):
+		// Without arguments, cat only copies between its redirects.
+		return cmd, nil
+	}
+	if len(assigns) == 0 {
+		cmd.jobs = []Job{dynamic(render(words), words, func(a []string) Job { return Exec(a[0], a[1:]...) })}
+		return cmd, nil
+	}
+	desc := ""
+	for _, a := range assigns {
+		desc += a.name + "=" + a.value.String() + " "
+	}
+	cmd.jobs = []Job{&FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			// As in sh, the words are expanded before the assignments take
+			// effect, so they only change the command's environment.
+			args := make([]string, len(words))
+			for i := range words {
+				args[i] = words[i].expand(s)
+			}
+			z := s.copy()
+			for _, a := range assigns {
+				z.SetEnv(a.name, a.value.expand(s))
+			}
+			return Exec(args[0], args[1:]...).Run(ctx, z)
+		},
+		Desc: func(d *Description) {
+			d.Append(desc + render(words))
+		},
+	}}
+	return cmd, nil
+}
+
+// setEnv creates the Job for a single assignment.
+func (p *parser) setEnv(a assignment) (Job, error) {
+	if len(a.value) == 1 && a.value[0].sub != nil {
+		return SetEnvFromJob(a.name, a.value[0].sub), nil
+	}
+	if a.value.hasSub() {
+		return nil, p.errorf("command substitution must be the entire value of %s", a.name)
+	}
+	if a.value.static() {
+		return SetEnv(a.name, a.value.expand(nil)), nil
+	}
+	return dynamic("export "+a.name+"="+a.value.String(), []word{a.value}, func(v []string) Job {
+		return SetEnv(a.name, v[0])
+	}), nil
+}
+
+// dynamic returns the Job created by build from the expanded words. If every
+// word is static, the Job is created immediately. Otherwise, the words are
+// expanded using the running State, and the Job is described by desc.
+func dynamic(desc string, words []word, build func(args []string) Job) Job {
+	static := true
+	for _, w := range words {
+		static = static && w.static()
+	}
+	expand := func(s *State) []string {
+		args := make([]string, len(words))
+		for i := range words {
+			args[i] = words[i].expand(s)
+		}
+		return args
+	}
+	if static {
+		return build(expand(nil))
+	}
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			return build(expand(s)).Run(ctx, s)
+		},
+		Desc: func(d *Description) {
+			d.Append(desc)
+		},
+	}
+}
+
+// parseWord parses a single word. If the word is an assignment, i.e. it starts
+// with an unquoted NAME=, the name is also returned. A nil word means there
+// was no word to parse.
+func (p *parser) parseWord() (word, string, error) {
+	var w word
+	prefix := ""
+	literal := true
+	for !p.done() {
+		c := p.peek()
+		switch {
+		case strings.IndexByte(" \t\n;&|()<>", c) >= 0:
+			return w, assignName(prefix), nil
+		case c == '\\':
+			p.pos++
+			if p.done() {
+				return nil, "", p.errorf("backslash at end of script")
+			}
+			if p.peek() != '\n' {
+				w.addLiteral(string(p.peek()))
+				literal = false
+			}
+			p.pos++
+		case c == '\'':
+			end := strings.IndexByte(p.src[p.pos+1:], '\'')
+			if end < 0 {
+				return nil, "", p.errorf("unterminated single quote")
+			}
+			w.addLiteral(p.src[p.pos+1 : p.pos+1+end])
+			p.pos += end + 2
+			literal = false
+		case c == '"':
+			if err := p.parseDoubleQuote(&w); err != nil {
+				return nil, "", err
+			}
+			literal = false
+		case c == '$':
+			if err := p.parseDollar(&w, false); err != nil {
+				return nil, "", err
+			}
+			literal = false
+		case c == '`':
+			return nil, "", p.errorf("backquote command substitution is not supported")
+		case c == '*' || c == '?' || c == '[':
+			return nil, "", p.errorf("glob %q is not supported, quote it to use it literally", c)
+		default:
+			w.addLiteral(string(c))
+			if literal {
+				prefix += string(c)
+			}
+			p.pos++
+		}
+	}
+	return w, assignName(prefix), nil
+}
+
+// assignName returns the variable name if prefix starts with NAME=.
+func assignName(prefix string) string {
+	i := strings.IndexByte(prefix, '=')
+	if i < 0 || !isName(prefix[:i]) {
+		return ""
+	}
+	return prefix[:i]
+}
+
+func (p *parser) parseDoubleQuote(w *word) error {
+	p.pos++
+	w.addLiteral("")
+	for {
+		if p.done() {
+			return p.errorf("unterminated double quote")
+		}
+		c := p.peek()
+		switch {
+		case c == '"':
+			p.pos++
+			return nil
+		case c == '\\' && p.pos+1 < len(p.src) && strings.IndexByte("$`\"\\\n", p.src[p.pos+1]) >= 0:
+			if p.src[p.pos+1] != '\n' {
+				w.addLiteral(string(p.src[p.pos+1]))
+			}
+			p.pos += 2
+		case c == '$':
+			if err := p.parseDollar(w, true); err != nil {
+				return err
+			}
+		case c == '`':
+			return p.errorf("backquote command substitution is not supported")
+		default:
+			w.addLiteral(string(c))
+			p.pos++
+		}
+	}
+}
+
+// parseDollar parses a variable reference or command substitution.
+func (p *parser) parseDollar(w *word, quoted bool) error {
+	p.pos++
+	switch c := p.peek(); {
+	case c == '{':
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			return p.errorf("missing \"}\"")
+		}
+		name := p.src[p.pos+1 : p.pos+end]
+		if !isName(name) {
+			return p.errorf("unsupported parameter expansion ${%s}", name)
+		}
+		*w = append(*w, wordPart{name: name})
+		p.pos += end + 1
+	case c == '(':
+		if quoted {
+			return p.errorf("command substitution is not supported in double quotes")
+		}
+		p.pos++
+		jobs, err := p.parseList(true)
+		if err != nil {
+			return err
+		}
+		*w = append(*w, wordPart{sub: oneOrScript(jobs)})
+	case c == '_' || isLetter(c):
+		start := p.pos
+		for !p.done() && (p.peek() == '_' || isLetter(p.peek()) || isDigit(p.peek())) {
+			p.pos++
+		}
+		*w = append(*w, wordPart{name: p.src[start:p.pos]})
+	default:
+		// A lone "$" is literal.
+		w.addLiteral("$")
+	}
+	return nil
+}
+
+func isName(s string) bool {
+	if s == "" || isDigit(s[0]) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '_' && !isLetter(s[i]) && !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isDigits returns true if s is a non-empty string of digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
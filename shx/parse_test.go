@@ -0,0 +1,253 @@
+package shx_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"testing"
+
+	. "github.com/m-lab/go/shx"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		wantDesc string
+		wantOut  string
+	}{
+		{
+			name:     "command",
+			script:   "echo ok",
+			wantDesc: " 1: echo ok\n",
+			wantOut:  "ok\n",
+		},
+		{
+			name:     "quotes-and-escapes",
+			script:   `echo 'single $X' "double \"q\" \$X" esc\ aped\` + "\n" + `   more # comment`,
+			wantDesc: " 1: echo single $X double \"q\" $X esc aped more\n",
+			wantOut:  "single $X double \"q\" $X esc aped more\n",
+		},
+		{
+			name:     "empty-words",
+			script:   `printf '[%s]' '' ""`,
+			wantDesc: " 1: printf [%s]  \n",
+			wantOut:  "[][]",
+		},
+		{
+			name:     "pipe",
+			script:   "printf 'b\\na\\n' |\n sort | head -1",
+			wantDesc: " 1: printf b\\na\\n | sort | head -1\n",
+			wantOut:  "a\n",
+		},
+		{
+			name:     "sequence",
+			script:   "echo a; echo b && echo c\necho d",
+			wantDesc: " 1: (\n 2:   echo a\n 3:   echo b\n 4:   echo c\n 5:   echo d\n 6: )\n",
+			wantOut:  "a\nb\nc\nd\n",
+		},
+		{
+			name:     "sequence-stops-on-error",
+			script:   "echo a; false; echo b",
+			wantDesc: " 1: (\n 2:   echo a\n 3:   false\n 4:   echo b\n 5: )\n",
+			wantOut:  "a\n",
+		},
+		{
+			name:     "variables",
+			script:   `X=1; export Y="$X-${X}x" Z=$(echo $Y | tr 1 2); echo "$Z" $Y$X $ 'end'`,
+			wantDesc: " 1: (\n 2:   export X=\"1\"\n 3:   export Y=\"${X}-${X}x\"\n 4:   export Z=$(echo \"${Y}\" | tr 1 2)\n 5:   echo \"${Z}\" \"${Y}${X}\" '$' end\n 6: )\n",
+			wantOut:  "2-2x 1-1x1 $ end\n",
+		},
+		{
+			name:     "variables-not-split",
+			script:   `X="a b"; printf '[%s]' $X`,
+			wantDesc: " 1: (\n 2:   export X=\"a b\"\n 3:   printf '[%s]' \"${X}\"\n 4: )\n",
+			wantOut:  "[a b]",
+		},
+		{
+			name:     "prefix-assignment",
+			script:   `X=1; X=2 Y=$X sh -c 'echo $X $Y'; echo $X`,
+			wantDesc: " 1: (\n 2:   export X=\"1\"\n 3:   X=2 Y=\"${X}\" sh -c 'echo $X $Y'\n 4:   echo \"${X}\"\n 5: )\n",
+			wantOut:  "2 1\n1\n",
+		},
+		{
+			name:     "prefix-assignment-expand",
+			script:   `X=1; X=2 echo $X; echo $X`,
+			wantDesc: " 1: (\n 2:   export X=\"1\"\n 3:   X=2 echo \"${X}\"\n 4:   echo \"${X}\"\n 5: )\n",
+			wantOut:  "1\n1\n",
+		},
+		{
+			name:     "subshell",
+			script:   "( X=sub; echo $X ); echo \"[$X]\"",
+			wantDesc: " 1: (\n 2:   (\n 3:     export X=\"sub\"\n 4:     echo \"${X}\"\n 5:   )\n 6:   echo \"[${X}]\"\n 7: )\n",
+			wantOut:  "sub\n[]\n",
+		},
+		{
+			name:     "redirects",
+			script:   "cd $DIR && echo data > in.txt && tr a-z A-Z < in.txt > out.txt && cat < out.txt",
+			wantDesc: " 1: (\n 2:   cd \"${DIR}\"\n 3:   echo data | cat > in.txt\n 4:   cat < in.txt | tr a-z A-Z | cat > out.txt\n 5:   cat < out.txt\n 6: )\n",
+			wantOut:  "DATA\n",
+		},
+		{
+			name:     "empty",
+			script:   "# nothing\n\n",
+			wantDesc: " 1: (\n 2: )\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := Parse(tt.script)
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			d := &Description{}
+			job.Describe(d)
+			desc := d.String()
+			if desc != tt.wantDesc {
+				t.Errorf("Parse() wrong description;\ngot  %q\nwant %q", desc, tt.wantDesc)
+			}
+			b := &bytes.Buffer{}
+			s := &State{Stdout: b, Env: []string{"DIR=" + t.TempDir()}}
+			job.Run(context.Background(), s)
+			if b.String() != tt.wantOut {
+				t.Errorf("Parse() wrong output; got %q, want %q", b.String(), tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestParseFiles(t *testing.T) {
+	dir := t.TempDir()
+	job, err := Parse("cat < in > out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "in"), []byte("copied"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(context.Background(), &State{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path.Join(dir, "out"))
+	if err != nil || string(b) != "copied" {
+		t.Errorf("Parse() copy failed; got %q, %v", b, err)
+	}
+	if _, err := os.Stat(path.Join(dir, "cat")); err == nil {
+		t.Errorf("Parse() treated redirect as argument")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{script: "a || b", want: `line 1: "||" is not supported`},
+		{script: "a &", want: `line 1: background jobs ("&") are not supported`},
+		{script: "a >> b", want: `line 1: redirect ">>" is not supported`},
+		{script: "a 2>&1", want: `line 1: redirect "2>" is not supported`},
+		{script: "echo hi 2>err.txt", want: `line 1: redirect "2>" is not supported`},
+		{script: "cat 0<in.txt", want: `line 1: redirect "0<" is not supported`},
+		{script: "a >&2", want: `line 1: redirect ">&" is not supported`},
+		{script: "ls *.go", want: `line 1: glob '*' is not supported, quote it to use it literally`},
+		{script: "ls file?.txt", want: `line 1: glob '?' is not supported, quote it to use it literally`},
+		{script: "ls [ab].txt", want: `line 1: glob '[' is not supported, quote it to use it literally`},
+		{script: "a <", want: `line 1: missing file name after '<'`},
+		{script: "a < b < c", want: `line 1: more than one '<' redirect`},
+		{script: "> file", want: `line 1: missing command for redirect`},
+		{script: "a | b < c", want: `line 1: only the first command in a pipeline may read from a file`},
+		{script: "a > c | b", want: `line 1: only the last command in a pipeline may write to a file`},
+		{script: "a |", want: `line 1: missing command at end of script`},
+		{script: "| a", want: `line 1: unexpected '|'`},
+		{script: "a\nb )", want: `line 2: unexpected ")"`},
+		{script: "echo (x)", want: `line 1: unexpected '('`},
+		{script: "(a", want: `line 1: missing ")"`},
+		{script: "echo 'a", want: `line 1: unterminated single quote`},
+		{script: "echo \"a", want: `line 1: unterminated double quote`},
+		{script: "echo a\\", want: `line 1: backslash at end of script`},
+		{script: "echo `a`", want: `line 1: backquote command substitution is not supported`},
+		{script: "echo \"`a`\"", want: `line 1: backquote command substitution is not supported`},
+		{script: "echo ${a", want: `line 1: missing "}"`},
+		{script: "echo ${a:-b}", want: `line 1: unsupported parameter expansion ${a:-b}`},
+		{script: "echo $(a)", want: `line 1: command substitution is only supported as the value of an assignment`},
+		{script: "echo \"$(a)\"", want: `line 1: command substitution is not supported in double quotes`},
+		{script: "X=a$(b)", want: `line 1: command substitution must be the entire value of X`},
+		{script: "a < $(b)", want: `line 1: command substitution is not supported in a redirect`},
+		{script: "X=$(b) a", want: `line 1: command substitution is not supported before a command`},
+		{script: "export X", want: `line 1: export requires NAME=value arguments`},
+		{script: "export 1X=a", want: `line 1: invalid variable name "1X"`},
+		{script: "cd a b", want: `line 1: cd requires exactly one directory`},
+		{script: "X=1 Y=2 | a", want: `line 1: assignments are not supported in a pipeline`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.script, func(t *testing.T) {
+			_, err := Parse(tt.script)
+			if !errors.Is(err, ErrParse) {
+				t.Fatalf("Parse() wrong error; got %v, want ErrParse", err)
+			}
+			if want := "parse error: " + tt.want; err.Error() != want {
+				t.Errorf("Parse() wrong error;\ngot  %q\nwant %q", err.Error(), want)
+			}
+		})
+	}
+}
+
+var lineNumbers = regexp.MustCompile(`(?m)^ *[0-9]+: `)
+
+func TestParseDescribeRoundTrip(t *testing.T) {
+	jobs := []Job{
+		Exec("ls", "-l"),
+		Pipe(Exec("echo", "ok"), Exec("cat")),
+		Script(Exec("echo", "ok"), Chdir("/tmp"), SetEnv("KEY", "value with spaces")),
+		Script(Script(Exec("a")), Pipe(ReadFile("in.txt"), Exec("sort"), WriteFile("out.txt", 0666))),
+		SetEnvFromJob("key", Pipe(Exec("echo", "ok"), Exec("cat"))),
+		ReadFile("input.file"),
+		WriteFile("output.file", 0666),
+	}
+	for _, job := range jobs {
+		d := &Description{}
+		job.Describe(d)
+		want := d.String()
+		t.Run(want, func(t *testing.T) {
+			parsed, err := Parse(lineNumbers.ReplaceAllString(want, ""))
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			d = &Description{}
+			parsed.Describe(d)
+			if got := d.String(); got != want {
+				t.Errorf("Parse() did not round trip;\ngot  %q\nwant %q", got, want)
+			}
+		})
+	}
+}
+
+func ExampleParse() {
+	job, err := Parse(`
+		export GREETING=hello
+		NAME=$(echo world | tr a-z A-Z)
+		echo "$GREETING, $NAME" | tr , !
+	`)
+	if err != nil {
+		panic(err)
+	}
+	d := &Description{}
+	job.Describe(d)
+	fmt.Print(d.String())
+	err = job.Run(context.Background(), New())
+	if err != nil {
+		panic(err)
+	}
+	// Output:
+	//  1: (
+	//  2:   export GREETING="hello"
+	//  3:   export NAME=$(echo world | tr a-z A-Z)
+	//  4:   echo "${GREETING}, ${NAME}" | tr , !
+	//  5: )
+	// hello! WORLD
+}
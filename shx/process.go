@@ -0,0 +1,128 @@
+package shx
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// DefaultGracePeriod is how long commands have to exit after Process.Signal
+// when Process.Grace is zero.
+const DefaultGracePeriod = 5 * time.Second
+
+// ErrNotSupported is returned when a Process option is not available on the
+// current platform.
+var ErrNotSupported = errors.New("not supported on this platform")
+
+// Rlimit is a resource limit applied to a command, e.g. syscall.RLIMIT_NOFILE.
+// See setrlimit(2) for resources and their units.
+type Rlimit struct {
+	Resource int
+	Cur      uint64
+	Max      uint64
+}
+
+// Process controls how ExecJob starts and stops commands. When State.Process is
+// nil, commands are killed with SIGKILL as soon as the context is canceled, and
+// their children are not.
+type Process struct {
+	// Group runs every command in a new process group, so that the termination
+	// signals reach the command and all of its children, e.g. the commands
+	// started by a System shell.
+	Group bool
+
+	// Signal is sent to the command, or its process group, when the context is
+	// canceled. If zero, SIGTERM is used.
+	Signal syscall.Signal
+
+	// Grace is how long to wait after Signal before sending SIGKILL. If zero,
+	// DefaultGracePeriod is used. With Group, the process group is also sent
+	// SIGKILL as soon as the command exits, so that no process in it outlives
+	// the canceled command.
+	Grace time.Duration
+
+	// Limits are applied to every command immediately after it starts. Limits
+	// are only supported on Linux.
+	//
+	// Go cannot run code in the child between fork and exec, so the limits
+	// are set with prlimit(2) after the command has started. Until then, the
+	// command, and any process it starts in that time, runs with the limits
+	// of this process. Commands that must never exceed a limit should apply
+	// it themselves, e.g. with System("ulimit -n 64; exec cmd").
+	Limits []Rlimit
+}
+
+func (p *Process) signal() syscall.Signal {
+	if p.Signal == 0 {
+		return syscall.SIGTERM
+	}
+	return p.Signal
+}
+
+func (p *Process) grace() time.Duration {
+	if p.Grace == 0 {
+		return DefaultGracePeriod
+	}
+	return p.Grace
+}
+
+// start starts the command and returns a function that waits for it to exit.
+// If the context is canceled first, the command is terminated gracefully.
+func (p *Process) start(ctx context.Context, cmd *exec.Cmd) (wait func() error, err error) {
+	if p.Group {
+		if err := setpgid(cmd); err != nil {
+			return nil, err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	pid := cmd.Process.Pid
+	// The command is already running, so it may briefly run without limits.
+	if len(p.Limits) > 0 {
+		if err := setLimits(pid, p.Limits); err != nil {
+			p.kill(pid, syscall.SIGKILL)
+			cmd.Wait()
+			return nil, err
+		}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+		p.kill(pid, p.signal())
+		t := time.NewTimer(p.grace())
+		defer t.Stop()
+		select {
+		case <-done:
+			if p.Group {
+				// The command exited, but other processes in its group may
+				// ignore the signal. Nothing waits for them, so kill them now.
+				p.kill(pid, syscall.SIGKILL)
+			}
+		case <-t.C:
+			p.kill(pid, syscall.SIGKILL)
+		}
+	}()
+	return func() error {
+		err := cmd.Wait()
+		close(done)
+		<-stopped
+		return err
+	}, nil
+}
+
+// kill sends the signal to the command, or its process group.
+func (p *Process) kill(pid int, sig syscall.Signal) {
+	if p.Group {
+		pid = -pid
+	}
+	kill(pid, sig)
+}
@@ -0,0 +1,19 @@
+package shx
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func setLimits(pid int, limits []Rlimit) error {
+	for _, l := range limits {
+		// The prlimit64 system call uses 64 bit limits on every architecture.
+		rlim := struct{ cur, max uint64 }{l.Cur, l.Max}
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(l.Resource),
+			uintptr(unsafe.Pointer(&rlim)), 0, 0, 0)
+		if errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package shx
+
+func setLimits(pid int, limits []Rlimit) error {
+	return ErrNotSupported
+}
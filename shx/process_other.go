@@ -0,0 +1,20 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package shx
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func setpgid(cmd *exec.Cmd) error {
+	return ErrNotSupported
+}
+
+func kill(pid int, sig syscall.Signal) {
+	if p, err := os.FindProcess(pid); err == nil {
+		p.Signal(sig)
+	}
+}
@@ -0,0 +1,148 @@
+package shx_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/m-lab/go/shx"
+)
+
+func TestProcessGroup(t *testing.T) {
+	tmpdir := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	s := &State{
+		Dir:     tmpdir,
+		Process: &Process{Group: true, Grace: 100 * time.Millisecond},
+	}
+	start := time.Now()
+	err := System("sleep 60 & echo $! > child.pid; wait").Run(ctx, s)
+	if err == nil {
+		t.Fatalf("Run() should fail when the context is canceled")
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("Run() did not terminate the command")
+	}
+	b, err := ioutil.ReadFile(path.Join(tmpdir, "child.pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The child may take a moment to exit after the signal is delivered. Once
+	// orphaned, it may also remain a zombie if init does not reap it.
+	for i := 0; i < 100; i++ {
+		stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil || strings.Contains(string(stat), ") Z ") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("child process %d is still running", pid)
+}
+
+func TestProcessGroupKillsIgnoringChild(t *testing.T) {
+	tmpdir := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	s := &State{
+		Dir:     tmpdir,
+		Process: &Process{Group: true, Grace: 10 * time.Second},
+	}
+	// The shell exits on SIGTERM, but its child ignores it.
+	start := time.Now()
+	err := System("(trap '' TERM; exec sleep 60) & echo $! > child.pid; wait").Run(ctx, s)
+	if err == nil {
+		t.Fatalf("Run() should fail when the context is canceled")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Run() waited %v for the grace period", d)
+	}
+	b, err := ioutil.ReadFile(path.Join(tmpdir, "child.pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil || strings.Contains(string(stat), ") Z ") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("child process %d ignored SIGTERM and is still running", pid)
+}
+
+func TestProcessSignal(t *testing.T) {
+	tests := []struct {
+		name     string
+		process  *Process
+		script   string
+		want     string
+		minDelay time.Duration
+	}{
+		{
+			name:    "custom-signal",
+			process: &Process{Signal: syscall.SIGINT, Grace: 10 * time.Second},
+			script:  `trap 'echo interrupted; exit 1' INT; echo ready; while true; do sleep 0.01; done`,
+			want:    "ready\ninterrupted\n",
+		},
+		{
+			name:     "kill-after-grace",
+			process:  &Process{Grace: 300 * time.Millisecond},
+			script:   `trap '' TERM; echo ready; while true; do sleep 0.01; done`,
+			want:     "ready\n",
+			minDelay: 300 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			b := &bytes.Buffer{}
+			s := &State{Stdout: b, Process: tt.process}
+			start := time.Now()
+			err := System(tt.script).Run(ctx, s)
+			if err == nil {
+				t.Errorf("Run() should fail when the context is canceled")
+			}
+			if d := time.Since(start); d < 200*time.Millisecond+tt.minDelay || d > 5*time.Second {
+				t.Errorf("Run() wrong duration; got %v", d)
+			}
+			if b.String() != tt.want {
+				t.Errorf("Run() wrong output; got %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessLimits(t *testing.T) {
+	b := &bytes.Buffer{}
+	s := &State{
+		Stdout: b,
+		Process: &Process{
+			Limits: []Rlimit{{Resource: syscall.RLIMIT_NOFILE, Cur: 64, Max: 64}},
+		},
+	}
+	// The shell reads its limit after it starts, so give setLimits time to apply.
+	err := System("sleep 0.2; ulimit -n").Run(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "64\n" {
+		t.Errorf("Run() wrong limit; got %q, want %q", b.String(), "64\n")
+	}
+}
@@ -0,0 +1,22 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package shx
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func setpgid(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	return nil
+}
+
+func kill(pid int, sig syscall.Signal) {
+	// The process may have already exited.
+	syscall.Kill(pid, sig)
+}
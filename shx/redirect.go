@@ -0,0 +1,222 @@
+package shx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Tee creates a Job that copies the Job input to the Job stdout and to every
+// given writer, like "tee". Use Tee in a Pipe to save output while still passing
+// it on to later Jobs.
+func Tee(w ...io.Writer) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			_, err := io.Copy(teeWriter(s.Stdout, w...), NewReaderContext(ctx, s.Stdin))
+			return err
+		},
+		Desc: func(d *Description) {
+			names := make([]string, len(w))
+			for i := range w {
+				names[i] = fmt.Sprintf("write(%v)", w[i])
+			}
+			d.Append(strings.Join(append([]string{"tee"}, names...), " "))
+		},
+	}
+}
+
+// TeeFile creates a Job that copies the Job input to the Job stdout and to the
+// named file, like "tee". The file is created if it does not exist and is
+// truncated if it does. If the State is a DryRun, input is only copied to
+// stdout.
+func TeeFile(path string, perm os.FileMode) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			if !traceChange(s, fmt.Sprintf("tee %s", s.Path(path))) {
+				_, err := io.Copy(teeWriter(s.Stdout), NewReaderContext(ctx, s.Stdin))
+				return err
+			}
+			file, err := os.OpenFile(s.Path(path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(teeWriter(s.Stdout, file), NewReaderContext(ctx, s.Stdin))
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		},
+		Desc: func(d *Description) {
+			d.Append(fmt.Sprintf("tee %s", path))
+		},
+	}
+}
+
+// RedirectStderr creates a Job that runs job with its stderr written to w, like
+// "2>". Changes job makes to the State Dir and Env are kept.
+func RedirectStderr(w io.Writer, job Job) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			return runWithOutput(ctx, s, s.Stdout, w, job)
+		},
+		Desc: func(d *Description) {
+			describeWrapped(d, "(", fmt.Sprintf(") 2> write(%v)", w), job)
+		},
+	}
+}
+
+// StderrFile creates a Job that runs job with its stderr written to the named
+// file, like "2> path". The file is created if it does not exist and is
+// truncated if it does. If the State is a DryRun, stderr is discarded and the
+// file is not created.
+func StderrFile(path string, perm os.FileMode, job Job) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			if s.DryRun {
+				return runWithOutput(ctx, s, s.Stdout, ioutil.Discard, job)
+			}
+			file, err := os.OpenFile(s.Path(path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+			if err != nil {
+				return err
+			}
+			err = runWithOutput(ctx, s, s.Stdout, file, job)
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		},
+		Desc: func(d *Description) {
+			describeWrapped(d, "(", fmt.Sprintf(") 2> %s", path), job)
+		},
+	}
+}
+
+// MergeStderr creates a Job that runs job with its stderr written to its
+// stdout, like "2>&1". In a Pipe, the merged output is read by the next Job.
+func MergeStderr(job Job) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			out := s.Stdout
+			if _, ok := out.(*os.File); !ok {
+				// Concurrent Jobs, e.g. in a Pipe, may write both streams at once.
+				out = &syncWriter{w: out}
+			}
+			return runWithOutput(ctx, s, out, out, job)
+		},
+		Desc: func(d *Description) {
+			describeWrapped(d, "(", ") 2>&1", job)
+		},
+	}
+}
+
+// Capture creates a Job that runs job and copies its stdout and stderr to the
+// given writers, while still writing them to the State stdout and stderr. A nil
+// writer leaves that stream unchanged. Capture is helpful for saving command
+// output for later steps without hiding it from the console.
+func Capture(stdout, stderr io.Writer, job Job) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			return runCapture(ctx, s, stdout, stderr, job)
+		},
+		Desc: func(d *Description) {
+			describeCapture(d, job, fmt.Sprintf("write(%v)", stdout), fmt.Sprintf("write(%v)", stderr),
+				stdout != nil, stderr != nil)
+		},
+	}
+}
+
+// CaptureEnv creates a Job that runs job and assigns its stdout and stderr,
+// without leading or trailing whitespace, to the named variables in the running
+// State Env. An empty name leaves that stream uncaptured. Unlike SetEnvFromJob,
+// output is still written to the State stdout and stderr, and the variables are
+// assigned even if job fails.
+func CaptureEnv(stdoutName, stderrName string, job Job) Job {
+	return &FuncJob{
+		Job: func(ctx context.Context, s *State) error {
+			var stdout, stderr *bytes.Buffer
+			var outw, errw io.Writer
+			if stdoutName != "" {
+				stdout = &bytes.Buffer{}
+				outw = stdout
+			}
+			if stderrName != "" {
+				stderr = &bytes.Buffer{}
+				errw = stderr
+			}
+			err := runCapture(ctx, s, outw, errw, job)
+			if stdout != nil {
+				s.SetEnv(stdoutName, strings.TrimSpace(stdout.String()))
+				s.Tracef("export %s=%q", stdoutName, s.GetEnv(stdoutName))
+			}
+			if stderr != nil {
+				s.SetEnv(stderrName, strings.TrimSpace(stderr.String()))
+				s.Tracef("export %s=%q", stderrName, s.GetEnv(stderrName))
+			}
+			return err
+		},
+		Desc: func(d *Description) {
+			describeCapture(d, job, "$"+stdoutName, "$"+stderrName, stdoutName != "", stderrName != "")
+		},
+	}
+}
+
+// runCapture runs job with stdout and stderr, if not nil, receiving a copy of
+// its output.
+func runCapture(ctx context.Context, s *State, stdout, stderr io.Writer, job Job) error {
+	outw, errw := s.Stdout, s.Stderr
+	// Concurrent Jobs, e.g. in a Pipe, may write to the same writers at once.
+	// Writers that may be the same, including ones that can not be compared,
+	// share one lock.
+	var out, err *syncWriter
+	if stdout != nil {
+		out = &syncWriter{w: stdout}
+		outw = teeWriter(s.Stdout, out)
+	}
+	if stderr != nil {
+		if stdout != nil && sameWriter(stderr, stdout) {
+			err = out
+		} else {
+			err = &syncWriter{w: stderr}
+		}
+		errw = teeWriter(s.Stderr, err)
+	}
+	return runWithOutput(ctx, s, outw, errw, job)
+}
+
+// describeCapture describes job with its captured streams using bash process
+// substitution, e.g. "(make) > >(tee $OUT) 2> >(tee $ERR >&2)".
+func describeCapture(d *Description, job Job, stdout, stderr string, useStdout, useStderr bool) {
+	end := ")"
+	if useStdout {
+		end += fmt.Sprintf(" > >(tee %s)", stdout)
+	}
+	if useStderr {
+		end += fmt.Sprintf(" 2> >(tee %s >&2)", stderr)
+	}
+	describeWrapped(d, "(", end, job)
+}
+
+// runWithOutput runs job using the State s with the given stdout and stderr.
+// The original writers are restored afterwards, but other changes job makes to
+// s, like to Dir or Env, are kept.
+func runWithOutput(ctx context.Context, s *State, stdout, stderr io.Writer, job Job) error {
+	origOut, origErr := s.Stdout, s.Stderr
+	defer func() {
+		s.Stdout, s.Stderr = origOut, origErr
+	}()
+	s.Stdout, s.Stderr = stdout, stderr
+	return job.Run(ctx, s)
+}
+
+// teeWriter returns a writer that copies writes to w and all extra writers. A
+// nil w, like a State without Stdout, is skipped.
+func teeWriter(w io.Writer, extra ...io.Writer) io.Writer {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	return io.MultiWriter(append([]io.Writer{w}, extra...)...)
+}
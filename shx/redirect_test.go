@@ -0,0 +1,240 @@
+package shx_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	. "github.com/m-lab/go/shx"
+)
+
+func TestRedirect(t *testing.T) {
+	tee := &bytes.Buffer{}
+	captureOut := &bytes.Buffer{}
+	captureErr := &bytes.Buffer{}
+	tests := []struct {
+		name       string
+		job        Job
+		wantStdout string
+		wantStderr string
+		wantFiles  map[string]string
+		wantEnv    map[string]string
+		wantErr    bool
+		check      func(t *testing.T)
+	}{
+		{
+			name:       "tee",
+			job:        Pipe(Println("data"), Tee(tee), Exec("tr", "a-z", "A-Z")),
+			wantStdout: "DATA\n",
+			check: func(t *testing.T) {
+				if tee.String() != "data\n" {
+					t.Errorf("Tee() wrong output; got %q, want %q", tee.String(), "data\n")
+				}
+			},
+		},
+		{
+			name:       "tee-file",
+			job:        Pipe(Println("data"), TeeFile("tee.txt", 0644), Exec("wc", "-l")),
+			wantStdout: "1\n",
+			wantFiles:  map[string]string{"tee.txt": "data\n"},
+		},
+		{
+			name:       "redirect-stderr",
+			job:        RedirectStderr(captureErr, System("echo out; echo err >&2")),
+			wantStdout: "out\n",
+			check: func(t *testing.T) {
+				if captureErr.String() != "err\n" {
+					t.Errorf("RedirectStderr() wrong output; got %q, want %q", captureErr.String(), "err\n")
+				}
+			},
+		},
+		{
+			name:       "stderr-file",
+			job:        StderrFile("err.txt", 0644, System("echo out; echo err >&2")),
+			wantStdout: "out\n",
+			wantFiles:  map[string]string{"err.txt": "err\n"},
+		},
+		{
+			name:    "stderr-file-bad-path",
+			job:     StderrFile("missing/err.txt", 0644, Exec("true")),
+			wantErr: true,
+		},
+		{
+			name:       "merge-stderr",
+			job:        Pipe(MergeStderr(System("echo err >&2")), Exec("tr", "a-z", "A-Z")),
+			wantStdout: "ERR\n",
+		},
+		{
+			name:       "merge-stderr-pipe",
+			job:        MergeStderr(Pipe(System("echo one >&2"), System("cat; sleep 0.1; echo two >&2"))),
+			wantStdout: "one\ntwo\n",
+		},
+		{
+			name:       "capture",
+			job:        Capture(captureOut, nil, System("echo out; echo err >&2")),
+			wantStdout: "out\n",
+			wantStderr: "err\n",
+			check: func(t *testing.T) {
+				if captureOut.String() != "out\n" {
+					t.Errorf("Capture() wrong output; got %q, want %q", captureOut.String(), "out\n")
+				}
+			},
+		},
+		{
+			name:       "capture-env",
+			job:        CaptureEnv("OUT", "ERR", System("echo out; echo err >&2; exit 1")),
+			wantStdout: "out\n",
+			wantStderr: "err\n",
+			wantEnv:    map[string]string{"OUT": "out", "ERR": "err"},
+			wantErr:    true,
+		},
+		{
+			name:       "capture-env-inner-vars",
+			job:        CaptureEnv("", "ERR", SetEnv("INNER", "value")),
+			wantEnv:    map[string]string{"INNER": "value", "ERR": ""},
+			wantStdout: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			stdout := &bytes.Buffer{}
+			stderr := &bytes.Buffer{}
+			s := &State{Stdout: stdout, Stderr: stderr, Dir: dir}
+			err := tt.job.Run(context.Background(), s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Run() wrong error; got %v, wantErr %t", err, tt.wantErr)
+			}
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("Run() wrong stdout; got %q, want %q", stdout.String(), tt.wantStdout)
+			}
+			if stderr.String() != tt.wantStderr {
+				t.Errorf("Run() wrong stderr; got %q, want %q", stderr.String(), tt.wantStderr)
+			}
+			if s.Stdout != stdout || s.Stderr != stderr {
+				t.Errorf("Run() did not restore the State stdout and stderr")
+			}
+			for name, want := range tt.wantFiles {
+				b, err := ioutil.ReadFile(path.Join(dir, name))
+				if err != nil || string(b) != want {
+					t.Errorf("Run() wrong file %s; got %q, %v, want %q", name, string(b), err, want)
+				}
+			}
+			for name, want := range tt.wantEnv {
+				if got := s.GetEnv(name); got != want {
+					t.Errorf("Run() wrong env %s; got %q, want %q", name, got, want)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t)
+			}
+		})
+	}
+}
+
+func TestRedirectDryRun(t *testing.T) {
+	dir := t.TempDir()
+	stdout := &bytes.Buffer{}
+	trace := &bytes.Buffer{}
+	s := &State{Stdout: stdout, Dir: dir, DryRun: true, Trace: trace}
+	job := Script(
+		Pipe(Println("data"), TeeFile("tee.txt", 0644)),
+		StderrFile("err.txt", 0644, Println("ok")),
+	)
+	if err := job.Run(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "data\nok\n" {
+		t.Errorf("Run() wrong output; got %q", stdout.String())
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("DryRun should not create files; got %d files", len(files))
+	}
+	want := "+ tee " + path.Join(dir, "tee.txt") + " # dry run\n"
+	if trace.String() != want {
+		t.Errorf("Run() wrong trace; got %q, want %q", trace.String(), want)
+	}
+}
+
+func TestCaptureUncomparableWriter(t *testing.T) {
+	b := &bytes.Buffer{}
+	for _, w := range []io.Writer{
+		unhashableWriter{b: b},
+		wrappedWriter{unhashableWriter{b: b}},
+	} {
+		b.Reset()
+		s := &State{Stdout: ioutil.Discard, Stderr: ioutil.Discard}
+		if err := Capture(w, w, System("echo out; echo err >&2")).Run(context.Background(), s); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		sort.Strings(lines)
+		if strings.Join(lines, ",") != "err,out" {
+			t.Errorf("Capture.Run() wrong output with %T; got %q", w, b.String())
+		}
+	}
+}
+
+func TestRedirectDescribe(t *testing.T) {
+	tests := []struct {
+		name string
+		job  Job
+		want string
+	}{
+		{
+			name: "tee-file",
+			job:  Pipe(Exec("make"), TeeFile("build.log", 0644)),
+			want: " 1: make | tee build.log\n",
+		},
+		{
+			name: "stderr-file",
+			job:  StderrFile("err.log", 0644, Exec("make")),
+			want: " 1: (make) 2> err.log\n",
+		},
+		{
+			name: "merge-stderr",
+			job:  Pipe(MergeStderr(Exec("make")), Exec("grep", "error")),
+			want: " 1: (make) 2>&1 | grep error\n",
+		},
+		{
+			name: "capture-env",
+			job:  CaptureEnv("OUT", "ERR", Exec("make")),
+			want: " 1: (make) > >(tee $OUT) 2> >(tee $ERR >&2)\n",
+		},
+		{
+			name: "capture-env-stderr",
+			job:  CaptureEnv("", "ERR", Exec("make")),
+			want: " 1: (make) 2> >(tee $ERR >&2)\n",
+		},
+		{
+			name: "merge-stderr-script",
+			job:  Pipe(MergeStderr(Script(Exec("a"), Exec("b"))), Exec("grep", "error")),
+			want: " 1: ( ( a ; b ) ) 2>&1 | grep error\n",
+		},
+		{
+			name: "stderr-file-script",
+			job:  StderrFile("err.log", 0644, Script(Exec("a"), Exec("b"))),
+			want: " 1: (\n 2:   (\n 3:     a\n 4:     b\n 5:   )\n 6: ) 2> err.log\n",
+		},
+		{
+			name: "capture-env-if",
+			job:  CaptureEnv("OUT", "", If(Exec("true"), Exec("make"), nil)),
+			want: " 1: (\n 2:   if true ; then\n 3:     make\n 4:   fi\n 5: ) > >(tee $OUT)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Description{}
+			tt.job.Describe(d)
+			if got := d.String(); got != tt.want {
+				t.Errorf("Describe() wrong result;\ngot  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package shx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultStderrTail is the number of trailing stderr bytes kept in every Result
+// when Report.StderrTail is zero.
+const DefaultStderrTail = 4096
+
+// StderrWaitDelay is how long an ExecJob that captures a stderr tail waits,
+// after its command exits, for other processes holding the stderr of the
+// command to close it. When it expires, their remaining output is discarded.
+var StderrWaitDelay = 100 * time.Millisecond
+
+// Result records how a single command started by an ExecJob, or a Script or
+// Pipe of Jobs, ended.
+type Result struct {
+	// Command is the description of the command, e.g. "ls -l". For a Script
+	// or Pipe, it is its description on one line, e.g. "( cd /tmp ; ls -l )".
+	Command string
+
+	// Composite is true for the Result of a Script or Pipe. It covers all of
+	// the Jobs within, whose own Results are recorded before it.
+	Composite bool
+
+	// ExitCode is the exit code of the command, or -1 if the command did not
+	// start or was terminated by a signal. For a Script or Pipe, it is the
+	// exit code of the command that made it fail, or -1 if the error was not
+	// from a command.
+	ExitCode int
+
+	// Signal is the signal that terminated the command, if any. For a Script
+	// or Pipe, it is the signal that terminated the command that made it fail.
+	Signal syscall.Signal
+
+	// Start is the time the command started.
+	Start time.Time
+
+	// Wall is the elapsed time from start to exit.
+	Wall time.Duration
+
+	// User and System are the CPU time used by the command. They are not
+	// recorded for a Script or Pipe.
+	User   time.Duration
+	System time.Duration
+
+	// StderrTail contains the last bytes written to stderr by the command. It
+	// is not captured for a Script or Pipe. Stderr is still written to the
+	// State Stderr as it arrives. Background processes started by the command
+	// may keep writing to it, but once the command exits, they are only
+	// waited for StderrWaitDelay.
+	StderrTail []byte
+
+	// Err is the error returned by the Job, or nil on success.
+	Err error
+}
+
+// String summarizes the result on one line, e.g. "ls -l: exit 2 after 3ms".
+func (r *Result) String() string {
+	status := fmt.Sprintf("exit %d", r.ExitCode)
+	switch {
+	case r.Signal != 0:
+		status = "signal " + r.Signal.String()
+	case r.ExitCode < 0 && r.Err != nil:
+		status = r.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s after %v", r.Command, status, r.Wall)
+}
+
+// Report collects a Result for every command run by an ExecJob, and for every
+// Script and Pipe. To collect a Report, assign it to State.Report before
+// calling Run. Jobs that do not run commands, like a Func, and Jobs of a State
+// that is a DryRun, are not recorded. Every State derived from the original,
+// e.g. by a Script or Pipe, shares the same Report. A Report is safe for
+// concurrent use.
+type Report struct {
+	// StderrTail is the maximum number of trailing stderr bytes kept in every
+	// Result. If zero, DefaultStderrTail is used. If negative, stderr is not
+	// captured.
+	StderrTail int
+
+	mu      sync.Mutex
+	results []*Result
+}
+
+// Results returns all collected results in the order the Jobs completed.
+func (r *Report) Results() []*Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Result(nil), r.results...)
+}
+
+// Failed returns the collected results for commands that did not succeed. The
+// Results of Scripts and Pipes are not included.
+func (r *Report) Failed() []*Result {
+	var failed []*Result
+	for _, res := range r.Results() {
+		if res.Err != nil && !res.Composite {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// String summarizes every result on its own line. For failed commands, the
+// stderr tail follows the summary, indented.
+func (r *Report) String() string {
+	b := &strings.Builder{}
+	for _, res := range r.Results() {
+		b.WriteString(res.String() + "\n")
+		if res.Err == nil || len(res.StderrTail) == 0 {
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimRight(string(res.StderrTail), "\n"), "\n") {
+			b.WriteString("    " + line + "\n")
+		}
+	}
+	return b.String()
+}
+
+func (r *Report) add(res *Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, res)
+}
+
+func (r *Report) tailSize() int {
+	if r.StderrTail == 0 {
+		return DefaultStderrTail
+	}
+	return r.StderrTail
+}
+
+// newResult creates a Result from a completed command that ran for wall.
+func newResult(command string, start time.Time, wall time.Duration, ps *os.ProcessState, err error) *Result {
+	res := &Result{
+		Command:  command,
+		ExitCode: -1,
+		Start:    start,
+		Wall:     wall,
+		Err:      err,
+	}
+	if ps == nil {
+		// The command did not start.
+		return res
+	}
+	res.ExitCode = ps.ExitCode()
+	res.User = ps.UserTime()
+	res.System = ps.SystemTime()
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		res.Signal = ws.Signal()
+	}
+	return res
+}
+
+// recordJob calls run to run a Job made of other Jobs, like a Script or Pipe,
+// and adds a Result for it to the Report of the State, if any.
+func recordJob(s *State, job Job, run func() error) error {
+	if s.Report == nil || s.DryRun {
+		return run()
+	}
+	start := time.Now()
+	err := run()
+	res := &Result{
+		Command:   describeLine(job),
+		Composite: true,
+		Start:     start,
+		Wall:      time.Since(start),
+		Err:       err,
+	}
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exit):
+		res.ExitCode = exit.ExitCode()
+		if ws, ok := exit.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			res.Signal = ws.Signal()
+		}
+	default:
+		res.ExitCode = -1
+	}
+	s.Report.add(res)
+	return err
+}
+
+// describeLine returns the description of job on a single line, e.g.
+// "( cd /tmp ; ls -l )".
+func describeLine(job Job) string {
+	d := &Description{}
+	job.Describe(d)
+	b := &strings.Builder{}
+	prev := ""
+	for _, line := range strings.Split(strings.TrimRight(d.String(), "\n"), "\n") {
+		// Remove the line number and indentation.
+		if i := strings.Index(line, ": "); i >= 0 {
+			line = line[i+2:]
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case prev == "":
+		case opensBlock(prev) || strings.HasPrefix(line, ")") || strings.HasPrefix(line, "}"):
+			b.WriteString(" ")
+		default:
+			b.WriteString(" ; ")
+		}
+		b.WriteString(line)
+		prev = line
+	}
+	return b.String()
+}
+
+// opensBlock returns true if the line of a description starts a block, after
+// which no command separator is needed.
+func opensBlock(line string) bool {
+	for _, s := range []string{"(", "{", "then", "else", "do"} {
+		if line == s || strings.HasSuffix(line, " "+s) {
+			return true
+		}
+	}
+	return false
+}
+
+// captureStderr makes cmd write its stderr through a pipe to tail. It must be
+// called before the command starts. The returned function must be called once
+// the command has exited, or failed to start, and returns when all of stderr
+// is copied, or StderrWaitDelay later if other processes still hold the pipe.
+// This bounds the wait like the exec.Cmd WaitDelay of newer versions of Go.
+func captureStderr(cmd *exec.Cmd, tail *tailWriter) (finish func(), err error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = w
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		io.Copy(tail, r)
+	}()
+	return func() {
+		// The command has its own copy of w, so only processes that
+		// inherited it keep the pipe open now.
+		w.Close()
+		t := time.NewTimer(StderrWaitDelay)
+		defer t.Stop()
+		select {
+		case <-copied:
+		case <-t.C:
+		}
+		r.Close()
+		<-copied
+	}, nil
+}
+
+// tailWriter writes to w and keeps the last max bytes written.
+type tailWriter struct {
+	w    io.Writer
+	max  int
+	mu   sync.Mutex
+	tail []byte
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	t.tail = append(t.tail, p...)
+	if len(t.tail) > t.max {
+		t.tail = append([]byte(nil), t.tail[len(t.tail)-t.max:]...)
+	}
+	t.mu.Unlock()
+	if t.w == nil {
+		return len(p), nil
+	}
+	return t.w.Write(p)
+}
+
+func (t *tailWriter) bytes() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]byte(nil), t.tail...)
+}
@@ -0,0 +1,202 @@
+package shx_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/m-lab/go/shx"
+)
+
+func TestReport(t *testing.T) {
+	tests := []struct {
+		name string
+		job  Job
+		tail int
+		want []Result
+	}{
+		{
+			name: "success",
+			job:  Exec("true"),
+			want: []Result{{Command: "true", ExitCode: 0}},
+		},
+		{
+			name: "exit-code",
+			job:  System("echo oops >&2; exit 3"),
+			want: []Result{{Command: "/bin/sh -c echo oops >&2; exit 3", ExitCode: 3, StderrTail: []byte("oops\n")}},
+		},
+		{
+			name: "signal",
+			job:  System("kill -TERM $$"),
+			want: []Result{{Command: "/bin/sh -c kill -TERM $$", ExitCode: -1, Signal: syscall.SIGTERM}},
+		},
+		{
+			name: "not-started",
+			job:  Exec("/this/command/does/not/exist"),
+			want: []Result{{Command: "/this/command/does/not/exist", ExitCode: -1}},
+		},
+		{
+			name: "bounded-tail",
+			job:  System("echo 0123456789 >&2"),
+			tail: 4,
+			want: []Result{{Command: "/bin/sh -c echo 0123456789 >&2", StderrTail: []byte("789\n")}},
+		},
+		{
+			name: "no-tail",
+			job:  System("echo 0123456789 >&2"),
+			tail: -1,
+			want: []Result{{Command: "/bin/sh -c echo 0123456789 >&2"}},
+		},
+		{
+			name: "script-and-pipe",
+			job: Script(
+				Exec("true"),
+				Pipe(Exec("echo", "ok"), Exec("cat")),
+				SetEnvFromJob("KEY", Exec("echo", "value")),
+				Exec("false"),
+				Exec("true"),
+			),
+			want: []Result{
+				{Command: "true"},
+				// Pipe commands finish in either order.
+				{Command: "*"},
+				{Command: "*"},
+				{Command: "echo ok | cat", Composite: true},
+				{Command: "echo value"},
+				{Command: "false", ExitCode: 1},
+				{Command: "( true ; echo ok | cat ; export KEY=$(echo value) ; false ; true )", ExitCode: 1, Composite: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Report{StderrTail: tt.tail}
+			stderr := &bytes.Buffer{}
+			s := &State{
+				Stdout: &bytes.Buffer{},
+				Stderr: stderr,
+				Report: r,
+			}
+			tt.job.Run(context.Background(), s)
+			got := r.Results()
+			if len(got) != len(tt.want) {
+				t.Fatalf("Report.Results() wrong count; got %d, want %d:\n%s", len(got), len(tt.want), r)
+			}
+			for i := range tt.want {
+				w, g := tt.want[i], got[i]
+				if w.Command != "*" && g.Command != w.Command {
+					t.Errorf("Result.Command got %q, want %q", g.Command, w.Command)
+				}
+				if g.Composite != w.Composite {
+					t.Errorf("Result.Composite got %t, want %t", g.Composite, w.Composite)
+				}
+				if g.ExitCode != w.ExitCode || g.Signal != w.Signal {
+					t.Errorf("Result got exit %d signal %v, want exit %d signal %v", g.ExitCode, g.Signal, w.ExitCode, w.Signal)
+				}
+				if (g.Err != nil) != (w.ExitCode != 0 || w.Signal != 0) {
+					t.Errorf("Result.Err got %v", g.Err)
+				}
+				if !bytes.Equal(g.StderrTail, w.StderrTail) {
+					t.Errorf("Result.StderrTail got %q, want %q", g.StderrTail, w.StderrTail)
+				}
+				if g.Start.IsZero() || g.Wall < 0 {
+					t.Errorf("Result has bad timing: %v %v", g.Start, g.Wall)
+				}
+			}
+			if tt.tail >= 0 && len(tt.want) == 1 && !bytes.HasSuffix(stderr.Bytes(), tt.want[0].StderrTail) {
+				t.Errorf("Stderr was not passed through; got %q", stderr.String())
+			}
+		})
+	}
+}
+
+func TestReportString(t *testing.T) {
+	r := &Report{}
+	s := &State{Report: r, Stderr: &bytes.Buffer{}}
+	Script(
+		Exec("true"),
+		System("echo first >&2; echo second >&2; exit 2"),
+	).Run(context.Background(), s)
+	lines := strings.Split(r.String(), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("Report.String() wrong line count; got %q", r.String())
+	}
+	if !strings.HasPrefix(lines[0], "true: exit 0 after ") {
+		t.Errorf("Report.String() wrong summary; got %q", lines[0])
+	}
+	if !strings.Contains(lines[1], ": exit 2 after ") || lines[2] != "    first" || lines[3] != "    second" {
+		t.Errorf("Report.String() wrong failure summary; got %q", lines[1:])
+	}
+	if !strings.HasPrefix(lines[4], "( true ; /bin/sh -c echo first") || !strings.Contains(lines[4], " ): exit 2 after ") {
+		t.Errorf("Report.String() wrong script summary; got %q", lines[4])
+	}
+	if f := r.Failed(); len(f) != 1 || f[0].ExitCode != 2 {
+		t.Errorf("Report.Failed() got %v", f)
+	}
+	res := &Result{Command: "sleep 1", ExitCode: -1, Signal: syscall.SIGKILL, Wall: time.Second}
+	if res.String() != "sleep 1: signal killed after 1s" {
+		t.Errorf("Result.String() got %q", res.String())
+	}
+}
+
+func TestReportStderrFile(t *testing.T) {
+	f, err := ioutil.TempFile(t.TempDir(), "TestReportStderrFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := &Report{}
+	s := &State{Report: r, Stderr: f}
+	// A background grandchild keeps stderr open after the command exits. Run
+	// waits for it only briefly, and not as part of the Wall time.
+	defer func(d time.Duration) { StderrWaitDelay = d }(StderrWaitDelay)
+	StderrWaitDelay = time.Second
+	start := time.Now()
+	if err := System("sleep 3 & echo ok >&2").Run(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Run() waited %v for a background process", d)
+	}
+	res := r.Results()[0]
+	if string(res.StderrTail) != "ok\n" {
+		t.Errorf("Result.StderrTail got %q, want %q", res.StderrTail, "ok\n")
+	}
+	if res.Wall >= StderrWaitDelay/2 {
+		t.Errorf("Result.Wall got %v, want less than %v", res.Wall, StderrWaitDelay/2)
+	}
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil || string(b) != "ok\n" {
+		t.Errorf("Stderr file got %q, %v; want %q", b, err, "ok\n")
+	}
+}
+
+func TestReportNew(t *testing.T) {
+	// The State from New writes stderr to os.Stderr.
+	s := New()
+	r := &Report{}
+	s.Report = r
+	if err := System("echo expected test output >&2; exit 2").Run(context.Background(), s); err == nil {
+		t.Fatal("Run() should fail")
+	}
+	if res := r.Results()[0]; string(res.StderrTail) != "expected test output\n" {
+		t.Errorf("Result.StderrTail got %q, want %q", res.StderrTail, "expected test output\n")
+	}
+}
+
+func TestReportCPUTime(t *testing.T) {
+	r := &Report{}
+	s := &State{Report: r}
+	err := System("i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done").Run(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := r.Results()[0]
+	if res.User+res.System <= 0 || res.Wall <= 0 {
+		t.Errorf("Result should record CPU time; got user %v system %v wall %v", res.User, res.System, res.Wall)
+	}
+}
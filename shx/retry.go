@@ -0,0 +1,219 @@
+package shx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/m-lab/go/memoryless"
+)
+
+// ErrTimeout is returned by a TimeoutJob when its Job does not complete in time.
+var ErrTimeout = errors.New("job timed out")
+
+// Backoff waits before the given retry attempt, starting with attempt 1 for the
+// wait after the first failure. Backoff should return ctx.Err() if the context
+// is canceled before the wait is over.
+type Backoff func(ctx context.Context, attempt int) error
+
+// ExponentialBackoff creates a Backoff that waits initial before the first
+// retry and doubles the wait for each subsequent retry, up to max.
+func ExponentialBackoff(initial, max time.Duration) Backoff {
+	return ExponentialClockBackoff(memoryless.SystemClock, initial, max)
+}
+
+// ExponentialClockBackoff is like ExponentialBackoff, but waits on the given
+// Clock, so that tests can control the backoff.
+func ExponentialClockBackoff(clock memoryless.Clock, initial, max time.Duration) Backoff {
+	return func(ctx context.Context, attempt int) error {
+		d := initial
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return sleep(ctx, clock.NewTimer(d))
+	}
+}
+
+// MemorylessBackoff creates a Backoff that waits a random, exponentially
+// distributed time before every retry, so that many clients retrying at once
+// do not synchronize. The wait uses the Clock of the config. An error is
+// returned if the config is invalid.
+func MemorylessBackoff(c memoryless.Config) (Backoff, error) {
+	if err := c.Check(); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, attempt int) error {
+		// The config is valid, so there is no error.
+		t, _ := memoryless.NewClockTimer(c)
+		return sleep(ctx, t)
+	}, nil
+}
+
+// sleep waits for the timer to fire or the context to be canceled.
+func sleep(ctx context.Context, t memoryless.Timer) error {
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RetryOnExitCode creates a RetryJob predicate that only retries commands that
+// exit with one of the given exit codes.
+func RetryOnExitCode(codes ...int) func(err error) bool {
+	return func(err error) bool {
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) {
+			return false
+		}
+		for _, code := range codes {
+			if exit.ExitCode() == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Retry creates a RetryJob that runs job until it succeeds, up to the given
+// number of attempts, without waiting between attempts. Set the Backoff and
+// RetryIf fields to change the policy.
+func Retry(attempts int, job Job) *RetryJob {
+	return &RetryJob{
+		Job:      job,
+		Attempts: attempts,
+	}
+}
+
+// RetryJob implements the Job interface for retrying a failed Job.
+type RetryJob struct {
+	Job      Job
+	Attempts int
+
+	// Backoff waits between attempts. If nil, attempts are not delayed.
+	Backoff Backoff
+
+	// RetryIf reports whether another attempt should follow the given error.
+	// If nil, every error is retried.
+	RetryIf func(err error) bool
+}
+
+// Run executes the Job until it succeeds, the attempts are exhausted, RetryIf
+// returns false, or the context is canceled. Every attempt runs on a copy of
+// the State; only the State and Stdout of the successful attempt are kept, so
+// that the output of failed attempts does not reach downstream Jobs. Stdin is
+// replayed for every attempt, unless it is an *os.File, which is passed to
+// every attempt as is. Stderr is not buffered. The error from the last
+// attempt is returned, annotated with the reason there were no more attempts.
+func (r *RetryJob) Run(ctx context.Context, s *State) error {
+	var seen bytes.Buffer
+	for attempt := 1; ; attempt++ {
+		z := s.copy()
+		if _, isFile := s.Stdin.(*os.File); s.Stdin != nil && !isFile {
+			// Replay input read by earlier attempts, then continue reading.
+			z.Stdin = io.MultiReader(bytes.NewReader(seen.Bytes()), io.TeeReader(s.Stdin, &seen))
+		}
+		out := &bytes.Buffer{}
+		z.Stdout = out
+		err := r.Job.Run(ctx, z)
+		if err == nil {
+			s.Dir = z.Dir
+			s.Env = z.Env
+			if s.Stdout == nil {
+				return nil
+			}
+			_, err = io.Copy(s.Stdout, out)
+			return err
+		}
+		if attempt >= r.Attempts {
+			return fmt.Errorf("%w (attempt limit %d)", err, r.Attempts)
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w (canceled after attempt %d: %v)", err, attempt, ctx.Err())
+		}
+		if r.RetryIf != nil && !r.RetryIf(err) {
+			return fmt.Errorf("%w (not retried after attempt %d)", err, attempt)
+		}
+		if r.Backoff != nil {
+			if berr := r.Backoff(ctx, attempt); berr != nil {
+				return fmt.Errorf("%w (backoff failed after attempt %d: %v)", err, attempt, berr)
+			}
+		}
+	}
+}
+
+// Describe generates a description of the retry policy and Job, e.g.
+// "retry 3 (curl ...)".
+func (r *RetryJob) Describe(d *Description) {
+	describeWrapped(d, fmt.Sprintf("retry %d (", r.Attempts), ")", r.Job)
+}
+
+// Timeout creates a TimeoutJob that cancels the context of job if it does not
+// complete within d. Commands started by ExecJob are killed when the context is
+// canceled.
+func Timeout(d time.Duration, job Job) *TimeoutJob {
+	return &TimeoutJob{
+		Job:     job,
+		Timeout: d,
+	}
+}
+
+// TimeoutJob implements the Job interface for limiting the run time of a Job.
+type TimeoutJob struct {
+	Job     Job
+	Timeout time.Duration
+}
+
+// Run executes the Job with a context that expires after Timeout. If the
+// context expires before the Job completes, the error wraps ErrTimeout.
+func (t *TimeoutJob) Run(ctx context.Context, s *State) error {
+	ctx2, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+	err := t.Job.Run(ctx2, s)
+	if err != nil && ctx.Err() == nil && ctx2.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w after %v: %v", ErrTimeout, t.Timeout, err)
+	}
+	return err
+}
+
+// Describe generates a description of the timeout and Job, e.g.
+// "timeout 10s (curl ...)".
+func (t *TimeoutJob) Describe(d *Description) {
+	describeWrapped(d, fmt.Sprintf("timeout %v (", t.Timeout), ")", t.Job)
+}
+
+// describeWrapped describes job between start and end. A job described on a
+// single line is written inline, e.g. "retry 3 (curl ...)". Otherwise, like a
+// Script, start and end are written on their own lines around the indented
+// description of job. Within a sequence, e.g. a Pipe, lines cannot be
+// started, so the description of job is joined onto one line instead.
+func describeWrapped(d *Description, start, end string, job Job) {
+	probe := &Description{}
+	job.Describe(probe)
+	if strings.Count(probe.String(), "\n") <= 1 {
+		endlist := d.StartSequence(start, "")
+		job.Describe(d)
+		endlist(end)
+		return
+	}
+	if len(d.idxs) > 0 {
+		d.Append(start + " " + describeLine(job) + " " + end)
+		return
+	}
+	d.Append(start)
+	d.Depth++
+	job.Describe(d)
+	d.Depth--
+	d.Append(end)
+}
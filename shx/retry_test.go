@@ -0,0 +1,330 @@
+package shx_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/memoryless"
+	"github.com/m-lab/go/memoryless/memorylesstest"
+	. "github.com/m-lab/go/shx"
+)
+
+// flaky creates a Job that reads all input, writes the attempt number and its
+// input to stdout, and fails until the given attempt.
+func flaky(succeedOn int, err error) (Job, *int) {
+	count := new(int)
+	return Func("flaky", func(ctx context.Context, s *State) error {
+		*count++
+		b, rerr := ioutil.ReadAll(s.Stdin)
+		if rerr != nil {
+			return rerr
+		}
+		fmt.Fprintf(s.Stdout, "attempt %d: %s", *count, b)
+		if *count < succeedOn {
+			return err
+		}
+		return nil
+	}), count
+}
+
+func TestRetry(t *testing.T) {
+	errFail := errors.New("fail")
+	tests := []struct {
+		name      string
+		attempts  int
+		succeedOn int
+		retryIf   func(error) bool
+		backoff   Backoff
+		want      string
+		wantCount int
+		wantErr   string
+	}{
+		{
+			name:      "success-first-attempt",
+			attempts:  3,
+			succeedOn: 1,
+			want:      "attempt 1: input",
+			wantCount: 1,
+		},
+		{
+			name:      "success-after-retries",
+			attempts:  3,
+			succeedOn: 3,
+			backoff:   ExponentialBackoff(time.Millisecond, 2*time.Millisecond),
+			want:      "attempt 3: input",
+			wantCount: 3,
+		},
+		{
+			name:      "error-attempts-exhausted",
+			attempts:  2,
+			succeedOn: 3,
+			wantCount: 2,
+			wantErr:   "fail (attempt limit 2)",
+		},
+		{
+			name:      "error-retry-if-false",
+			attempts:  3,
+			succeedOn: 3,
+			retryIf:   func(error) bool { return false },
+			wantCount: 1,
+			wantErr:   "fail (not retried after attempt 1)",
+		},
+		{
+			name:      "error-backoff-failed",
+			attempts:  3,
+			succeedOn: 3,
+			backoff:   func(ctx context.Context, attempt int) error { return errors.New("no backoff") },
+			wantCount: 1,
+			wantErr:   "fail (backoff failed after attempt 1: no backoff)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, count := flaky(tt.succeedOn, errFail)
+			r := Retry(tt.attempts, job)
+			r.RetryIf = tt.retryIf
+			r.Backoff = tt.backoff
+			b := &bytes.Buffer{}
+			s := &State{
+				Stdin:  strings.NewReader("input"),
+				Stdout: b,
+			}
+			err := r.Run(context.Background(), s)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Fatalf("Retry.Run() wrong error; got %v, want %q", err, tt.wantErr)
+			}
+			if tt.wantErr != "" && !errors.Is(err, errFail) {
+				t.Errorf("Retry.Run() should wrap the last error; got %v", err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("Retry.Run() wrong output; got %q, want %q", b.String(), tt.want)
+			}
+			if *count != tt.wantCount {
+				t.Errorf("Retry.Run() wrong number of attempts; got %d, want %d", *count, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestRetryState(t *testing.T) {
+	s := &State{Env: []string{"KEY=ORIGINAL"}}
+	count := 0
+	r := Retry(2, Func("setenv", func(ctx context.Context, s *State) error {
+		count++
+		s.SetEnv("KEY", fmt.Sprint(count))
+		if count < 2 {
+			return errors.New("fail")
+		}
+		return nil
+	}))
+	if err := r.Run(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetEnv("KEY") != "2" {
+		t.Errorf("Retry.Run() should keep the State of the successful attempt; got KEY=%s", s.GetEnv("KEY"))
+	}
+}
+
+func TestRetryPipe(t *testing.T) {
+	// Output from failed attempts must not reach the next command.
+	b := &bytes.Buffer{}
+	s := &State{Stdout: b}
+	s.SetDir(t.TempDir())
+	p := Pipe(
+		Retry(3, System("if [ -f x ]; then echo ok; else touch x; echo bad; exit 1; fi")),
+		Exec("cat"),
+	)
+	if err := p.Run(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "ok\n" {
+		t.Errorf("Retry.Run() wrong pipe output; got %q, want %q", b.String(), "ok\n")
+	}
+}
+
+func TestRetryOnExitCode(t *testing.T) {
+	tests := []struct {
+		name      string
+		cmd       string
+		wantCount int
+	}{
+		{name: "retry-matching-code", cmd: "echo x >&2; exit 75", wantCount: 3},
+		{name: "stop-other-code", cmd: "echo x >&2; exit 1", wantCount: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &bytes.Buffer{}
+			r := Retry(3, System(tt.cmd))
+			r.RetryIf = RetryOnExitCode(75, 76)
+			s := &State{Stderr: b}
+			err := r.Run(context.Background(), s)
+			var exit *exec.ExitError
+			if !errors.As(err, &exit) {
+				t.Fatalf("Retry.Run() wrong error; got %v", err)
+			}
+			if n := strings.Count(b.String(), "x"); n != tt.wantCount {
+				t.Errorf("Retry.Run() wrong number of attempts; got %d, want %d", n, tt.wantCount)
+			}
+		})
+	}
+	if RetryOnExitCode(1)(errors.New("not an exit error")) {
+		t.Errorf("RetryOnExitCode() should not retry other errors")
+	}
+}
+
+func TestMemorylessBackoff(t *testing.T) {
+	_, err := MemorylessBackoff(memoryless.Config{Expected: time.Second, Max: time.Millisecond})
+	if err == nil {
+		t.Errorf("MemorylessBackoff() should reject a bad config")
+	}
+	clock := memorylesstest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	b, err := MemorylessBackoff(memoryless.Config{Expected: time.Second, Clock: clock, Rand: memorylesstest.NewRand(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForBackoff(t, clock, b, 1, 2*time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b, _ = MemorylessBackoff(memoryless.Config{Expected: time.Hour})
+	if err := b(ctx, 1); err != context.Canceled {
+		t.Errorf("MemorylessBackoff() wrong error; got %v, want %v", err, context.Canceled)
+	}
+	if err := ExponentialBackoff(time.Hour, time.Hour)(ctx, 1); err != context.Canceled {
+		t.Errorf("ExponentialBackoff() wrong error; got %v, want %v", err, context.Canceled)
+	}
+}
+
+// waitForBackoff runs the given attempt of b and checks that it waits exactly
+// want on the clock.
+func waitForBackoff(t *testing.T, clock *memorylesstest.FakeClock, b Backoff, attempt int, want time.Duration) {
+	t.Helper()
+	done := make(chan error)
+	go func() {
+		done <- b(context.Background(), attempt)
+	}()
+	clock.BlockUntil(1)
+	if d := clock.Deadlines(); len(d) != 1 || d[0].Sub(clock.Now()) != want {
+		t.Errorf("Backoff attempt %d waits until %v, want %v from %v", attempt, d, want, clock.Now())
+	}
+	clock.Advance(want)
+	if err := <-done; err != nil {
+		t.Errorf("Backoff attempt %d wrong error; got %v", attempt, err)
+	}
+}
+
+func TestExponentialClockBackoff(t *testing.T) {
+	clock := memorylesstest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	b := ExponentialClockBackoff(clock, time.Second, 5*time.Second)
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		waitForBackoff(t, clock, b, attempt+1, want)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		job     Job
+		wantErr error
+	}{
+		{
+			name:    "success",
+			timeout: time.Minute,
+			job:     System("true"),
+		},
+		{
+			name:    "error-timeout",
+			timeout: 10 * time.Millisecond,
+			job:     Exec("sleep", "60"),
+			wantErr: ErrTimeout,
+		},
+		{
+			name:    "error-not-timeout",
+			timeout: time.Minute,
+			job:     System("exit 1"),
+			wantErr: &exec.ExitError{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			err := Timeout(tt.timeout, tt.job).Run(context.Background(), New())
+			if time.Since(start) > 10*time.Second {
+				t.Errorf("Timeout.Run() did not stop the job")
+			}
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("Timeout.Run() wrong error; got %v", err)
+				}
+			case *exec.ExitError:
+				if !errors.As(err, &want) || errors.Is(err, ErrTimeout) {
+					t.Errorf("Timeout.Run() wrong error; got %v", err)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Errorf("Timeout.Run() wrong error; got %v, want %v", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryDescribe(t *testing.T) {
+	tests := []struct {
+		name string
+		job  Job
+		want string
+	}{
+		{
+			name: "retry",
+			job:  Retry(3, Exec("curl", "-f", "http://example.com")),
+			want: " 1: retry 3 (curl -f http://example.com)\n",
+		},
+		{
+			name: "timeout",
+			job:  Timeout(10*time.Second, Exec("curl", "-f", "http://example.com")),
+			want: " 1: timeout 10s (curl -f http://example.com)\n",
+		},
+		{
+			name: "retry-timeout-pipe",
+			job:  Retry(2, Timeout(time.Minute, Pipe(Exec("curl"), Exec("gzip")))),
+			want: " 1: retry 2 (timeout 1m0s (curl | gzip))\n",
+		},
+		{
+			name: "retry-script",
+			job:  Retry(3, Script(Exec("a"), Exec("b"))),
+			want: " 1: retry 3 (\n 2:   (\n 3:     a\n 4:     b\n 5:   )\n 6: )\n",
+		},
+		{
+			name: "retry-timeout-if",
+			job:  Retry(2, Timeout(time.Minute, If(Exec("true"), Exec("a"), nil))),
+			want: " 1: retry 2 (\n 2:   timeout 1m0s (\n 3:     if true ; then\n 4:       a\n 5:     fi\n 6:   )\n 7: )\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Description{}
+			tt.job.Describe(d)
+			if got := d.String(); got != tt.want {
+				t.Errorf("Describe() wrong result; got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func ExampleRetryJob_Describe() {
+	r := Retry(3, Timeout(30*time.Second, Exec("gsutil", "cp", "gs://bucket/file", ".")))
+	r.Backoff = ExponentialBackoff(time.Second, time.Minute)
+	d := &Description{}
+	r.Describe(d)
+	fmt.Println(d.String())
+	// Output:  1: retry 3 (timeout 30s (gsutil cp gs://bucket/file .))
+}
@@ -0,0 +1,134 @@
+package shx_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	. "github.com/m-lab/go/shx"
+)
+
+func TestTrace(t *testing.T) {
+	tmpdir := t.TempDir()
+	tests := []struct {
+		name    string
+		job     Job
+		dryRun  bool
+		want    string
+		wantOut string
+	}{
+		{
+			name: "script",
+			job: Script(
+				Chdir(tmpdir),
+				SetEnv("KEY", "value"),
+				SetEnvFromJob("OTHER", System("echo $KEY-computed")),
+				System("echo $OTHER"),
+				Exec("echo", "$OTHER"),
+			),
+			want: "+ cd " + tmpdir + "\n" +
+				"+ export KEY=\"value\"\n" +
+				"+ [" + tmpdir + "] /bin/sh -c echo value-computed\n" +
+				"+ export OTHER=\"value-computed\"\n" +
+				"+ [" + tmpdir + "] /bin/sh -c echo value-computed\n" +
+				"+ [" + tmpdir + "] echo $OTHER\n",
+			wantOut: "value-computed\n$OTHER\n",
+		},
+		{
+			name: "files",
+			job: Script(
+				Chdir(tmpdir),
+				Pipe(Println("data"), WriteFile("file.txt", 0644)),
+				ReadFile("file.txt"),
+			),
+			want: "+ cd " + tmpdir + "\n" +
+				"+ cat > " + path.Join(tmpdir, "file.txt") + "\n" +
+				"+ cat < " + path.Join(tmpdir, "file.txt") + "\n",
+			wantOut: "data\n",
+		},
+		{
+			name: "dry-run",
+			job: Script(
+				Chdir(tmpdir),
+				Pipe(Println("data"), Exec("tee", "dry.txt"), WriteFile("dry2.txt", 0644)),
+				System("rm -rf /"),
+			),
+			dryRun: true,
+			want: "+ cd " + tmpdir + "\n" +
+				"+ [" + tmpdir + "] tee dry.txt # dry run\n" +
+				"+ cat > " + path.Join(tmpdir, "dry2.txt") + " # dry run\n" +
+				"+ [" + tmpdir + "] /bin/sh -c rm -rf / # dry run\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace := &bytes.Buffer{}
+			out := &bytes.Buffer{}
+			s := &State{
+				Stdout: out,
+				Trace:  trace,
+				DryRun: tt.dryRun,
+			}
+			if err := tt.job.Run(context.Background(), s); err != nil {
+				t.Fatal(err)
+			}
+			got := trace.String()
+			if tt.name == "dry-run" {
+				// Pipe jobs run concurrently, so sort their trace lines.
+				got = sortLines(got, 1, 3)
+				tt.want = sortLines(tt.want, 1, 3)
+			}
+			if got != tt.want {
+				t.Errorf("Trace wrong output;\ngot  %q\nwant %q", got, tt.want)
+			}
+			if out.String() != tt.wantOut {
+				t.Errorf("Run wrong output; got %q, want %q", out.String(), tt.wantOut)
+			}
+		})
+	}
+	for _, name := range []string{"dry.txt", "dry2.txt"} {
+		if _, err := os.Stat(path.Join(tmpdir, name)); !os.IsNotExist(err) {
+			t.Errorf("DryRun wrote a file: %s", name)
+		}
+	}
+}
+
+// sortLines sorts lines [i, j) of s.
+func sortLines(s string, i, j int) string {
+	lines := strings.Split(s, "\n")
+	if len(lines) >= j {
+		sub := lines[i:j]
+		if sub[0] > sub[1] {
+			sub[0], sub[1] = sub[1], sub[0]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestTraceParallel(t *testing.T) {
+	trace := &bytes.Buffer{}
+	s := &State{Trace: trace, DryRun: true}
+	p := Parallel(Exec("a"), Exec("b"), Exec("c"))
+	if err := p.Run(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(trace.String(), "# dry run\n"); n != 3 {
+		t.Errorf("Parallel trace wrong line count; got %d in %q", n, trace.String())
+	}
+}
+
+func TestTracef(t *testing.T) {
+	// Without a Trace writer, Tracef does nothing.
+	s := &State{}
+	s.Tracef("ignored %d", 1)
+
+	b := &bytes.Buffer{}
+	s.Trace = b
+	s.Tracef("custom %s", "job")
+	if b.String() != "+ custom job\n" {
+		t.Errorf("Tracef() got %q, want %q", b.String(), "+ custom job\n")
+	}
+}
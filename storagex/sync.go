@@ -0,0 +1,363 @@
+package storagex
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+)
+
+// partialSuffix is added to the names of files that are still downloading.
+const partialSuffix = ".partial"
+
+// SyncConfig describes how SyncToDir and SyncFromDir copy files.
+type SyncConfig struct {
+	// Workers is the number of files copied at the same time. If it is less
+	// than one, files are copied one at a time.
+	Workers int
+	// Progress, if not nil, is called after every file with the progress so
+	// far. Calls are never concurrent.
+	Progress func(p SyncProgress)
+}
+
+// SyncProgress counts the files handled by SyncToDir or SyncFromDir.
+type SyncProgress struct {
+	Name    string // The object name of the last file handled.
+	Copied  int    // Files that were copied.
+	Skipped int    // Files that were already up to date.
+	Failed  int    // Files that could not be copied.
+	Bytes   int64  // Bytes copied.
+}
+
+// syncItem is one file to copy.
+type syncItem struct {
+	name    string               // The object name.
+	path    string               // The local path.
+	attrs   *storage.ObjectAttrs // The object attributes, when downloading.
+	partial string               // The partial download, when downloading.
+}
+
+// partialPath returns the path of the partial download of a generation of the
+// object with the local path p, e.g. "a.tgz.1600000000000000.partial". A
+// partial download of another generation is never resumed.
+func partialPath(p string, generation int64) string {
+	return fmt.Sprintf("%s.%d%s", p, generation, partialSuffix)
+}
+
+// isPartial reports whether p is named like a partial download by partialPath.
+func isPartial(p string) bool {
+	name := filepath.Base(p)
+	if !strings.HasSuffix(name, partialSuffix) {
+		return false
+	}
+	name = strings.TrimSuffix(name, partialSuffix)
+	i := strings.LastIndex(name, ".")
+	if i <= 0 || i == len(name)-1 {
+		return false
+	}
+	for _, c := range name[i+1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// removePartials removes the partial downloads under dir that are not in keep,
+// e.g. those of older generations of an object. Only files named by
+// partialPath are removed, so other files ending in ".partial" are kept.
+func removePartials(dir string, keep map[string]bool) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() && isPartial(p) && !keep[p] {
+			return os.Remove(p)
+		}
+		return nil
+	})
+}
+
+// SyncToDir mirrors every object under prefix in the bucket to dir, using
+// Walk to find the objects and c.Workers goroutines to download them. Files
+// are named like Object.LocalName. A file is skipped if it has the size, and
+// the MD5 and CRC32C if known, of its object. Downloads are written to a
+// ".partial" file named by the object generation first, e.g.
+// "a.tgz.1600000000000000.partial", so an interrupted sync resumes with a
+// range read where it stopped, unless the object has changed. A download whose
+// checksum does not match is discarded, and so are partial downloads of
+// objects that have changed, once every object was listed. Objects named like
+// partial downloads fail to sync, because their files could not be told apart.
+//
+// A *storage.BucketHandle can be used with stiface.AdaptBucket. SyncToDir
+// copies every file it can, and returns the first error.
+func SyncToDir(ctx context.Context, bucket stiface.BucketHandle, prefix, dir string, c SyncConfig) (SyncProgress, error) {
+	keep := map[string]bool{}
+	listed := false
+	list := func(items chan<- syncItem) error {
+		ls := func(q *storage.Query) func() (*storage.ObjectAttrs, error) {
+			return bucket.Objects(ctx, q).Next
+		}
+		err := walkAttrs(ls, prefix, func(attr *storage.ObjectAttrs) error {
+			p := filepath.Join(dir, filepath.FromSlash(localName(attr.Name, prefix)))
+			partial := partialPath(p, attr.Generation)
+			keep[partial] = true
+			return send(ctx, items, syncItem{name: attr.Name, path: p, attrs: attr, partial: partial})
+		})
+		listed = err == nil
+		return err
+	}
+	copyFile := func(ctx context.Context, item syncItem) (int64, bool, error) {
+		rel, err := filepath.Rel(dir, item.path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return 0, false, fmt.Errorf("Object name leads outside of %q", dir)
+		}
+		if isPartial(item.path) {
+			return 0, false, fmt.Errorf("Object names like partial downloads are not supported")
+		}
+		return download(ctx, bucket, item)
+	}
+	progress, err := c.run(ctx, list, copyFile)
+	if listed {
+		// Every current partial download is known, so the others are stale.
+		if rerr := removePartials(dir, keep); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return progress, err
+}
+
+// SyncFromDir is the reverse of SyncToDir. It uploads every file under dir to
+// the bucket, naming each object by joining prefix and the path of the file
+// within dir. The partial downloads of SyncToDir are not uploaded. A file is
+// skipped if its object already has the same size, and MD5 and CRC32C if
+// known. Each upload is checked against the checksums of the new object.
+func SyncFromDir(ctx context.Context, bucket stiface.BucketHandle, dir, prefix string, c SyncConfig) (SyncProgress, error) {
+	list := func(items chan<- syncItem) error {
+		return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() || isPartial(p) {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			return send(ctx, items, syncItem{name: path.Join(prefix, filepath.ToSlash(rel)), path: p})
+		})
+	}
+	copyFile := func(ctx context.Context, item syncItem) (int64, bool, error) {
+		return upload(ctx, bucket, item)
+	}
+	return c.run(ctx, list, copyFile)
+}
+
+func send(ctx context.Context, items chan<- syncItem, item syncItem) error {
+	select {
+	case items <- item:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run calls copyFile for every item produced by list, in c.Workers goroutines.
+func (c SyncConfig) run(
+	ctx context.Context,
+	list func(items chan<- syncItem) error,
+	copyFile func(ctx context.Context, item syncItem) (n int64, skipped bool, err error),
+) (SyncProgress, error) {
+	workers := c.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	items := make(chan syncItem)
+	var (
+		mu       sync.Mutex
+		progress SyncProgress
+		firstErr error
+	)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				n, skipped, err := copyFile(ctx, item)
+				mu.Lock()
+				progress.Name = item.name
+				progress.Bytes += n
+				switch {
+				case err != nil:
+					log.Printf("Could not sync %q: %v", item.name, err)
+					progress.Failed++
+					if firstErr == nil {
+						firstErr = fmt.Errorf("%s: %w", item.name, err)
+					}
+				case skipped:
+					progress.Skipped++
+				default:
+					progress.Copied++
+				}
+				if c.Progress != nil {
+					c.Progress(progress)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	err := list(items)
+	close(items)
+	wg.Wait()
+	if err != nil {
+		return progress, err
+	}
+	return progress, firstErr
+}
+
+// download copies one object to its local path, resuming a partial download
+// of the same generation.
+func download(ctx context.Context, bucket stiface.BucketHandle, item syncItem) (int64, bool, error) {
+	// Only read the local file when its size matches.
+	if info, err := os.Stat(item.path); err == nil && info.Size() == item.attrs.Size {
+		if sum, err := sumFile(item.path); err == nil && sum.matches(item.attrs) {
+			return 0, true, nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(item.path), 0755); err != nil {
+		return 0, false, err
+	}
+	partial := item.partial
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, false, err
+	}
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return 0, false, err
+	}
+	if offset > item.attrs.Size {
+		// The partial file is not a prefix of this object.
+		if err = f.Truncate(0); err != nil {
+			f.Close()
+			return 0, false, err
+		}
+		offset, _ = f.Seek(0, io.SeekStart)
+	}
+	var n int64
+	if offset < item.attrs.Size {
+		r, err := bucket.Object(item.name).NewRangeReader(ctx, offset, -1)
+		if err != nil {
+			f.Close()
+			return 0, false, err
+		}
+		n, err = io.Copy(f, r)
+		r.Close()
+		if err != nil {
+			// Keep the partial file, so the next sync resumes from here.
+			f.Close()
+			return n, false, err
+		}
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return n, false, err
+	}
+	if err = f.Close(); err != nil {
+		return n, false, err
+	}
+	sum, err := sumFile(partial)
+	if err != nil {
+		return n, false, err
+	}
+	if !sum.matches(item.attrs) {
+		os.Remove(partial)
+		return n, false, fmt.Errorf("Checksum of the download does not match")
+	}
+	return n, false, os.Rename(partial, item.path)
+}
+
+// upload copies one local file to its object.
+func upload(ctx context.Context, bucket stiface.BucketHandle, item syncItem) (int64, bool, error) {
+	sum, err := sumFile(item.path)
+	if err != nil {
+		return 0, false, err
+	}
+	obj := bucket.Object(item.name)
+	if attrs, err := obj.Attrs(ctx); err == nil && sum.matches(attrs) {
+		return 0, true, nil
+	}
+	f, err := os.Open(item.path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+	// Canceling the context of the Writer discards a partial upload.
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := obj.NewWriter(wctx)
+	n, err := io.Copy(w, f)
+	if err != nil {
+		return n, false, err
+	}
+	if err = w.Close(); err != nil {
+		return n, false, err
+	}
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return n, false, err
+	}
+	if !sum.matches(attrs) {
+		return n, false, fmt.Errorf("Checksum of the upload does not match")
+	}
+	return n, false, nil
+}
+
+// fileSum holds the size and checksums of a local file.
+type fileSum struct {
+	size   int64
+	md5    []byte
+	crc32c uint32
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func sumFile(p string) (fileSum, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return fileSum{}, err
+	}
+	defer f.Close()
+	m := md5.New()
+	c := crc32.New(castagnoli)
+	n, err := io.Copy(io.MultiWriter(m, c), f)
+	if err != nil {
+		return fileSum{}, err
+	}
+	return fileSum{size: n, md5: m.Sum(nil), crc32c: c.Sum32()}, nil
+}
+
+// matches reports whether the file has the size of the object, and the MD5
+// and CRC32C of the object if they are known.
+func (f fileSum) matches(attrs *storage.ObjectAttrs) bool {
+	return f.size == attrs.Size &&
+		(len(attrs.MD5) == 0 || bytes.Equal(f.md5, attrs.MD5)) &&
+		(attrs.CRC32C == 0 || f.crc32c == attrs.CRC32C)
+}
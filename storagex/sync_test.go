@@ -0,0 +1,239 @@
+package storagex
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/m-lab/go/cloudtest/gcsfake"
+	"github.com/m-lab/go/rtx"
+)
+
+// newBucket returns a fake bucket whose Writers replace objects, like GCS.
+func newBucket() *gcsfake.BucketHandle {
+	bh := newBucket()
+	bh.WritesReplace = true
+	return bh
+}
+
+// addObject writes an object to the fake bucket and lists it with its
+// checksums and generation.
+func addObject(bh *gcsfake.BucketHandle, name, data string) *storage.ObjectAttrs {
+	obj := bh.Object(name)
+	w := obj.NewWriter(context.Background())
+	_, err := w.Write([]byte(data))
+	rtx.Must(err, "Could not write %s", name)
+	attrs, err := obj.Attrs(context.Background())
+	rtx.Must(err, "Could not read the attributes of %s", name)
+	bh.ObjAttrs = append(bh.ObjAttrs, attrs)
+	return attrs
+}
+
+func readFile(t *testing.T, p string) string {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Errorf("Could not read %s: %v", p, err)
+	}
+	return string(b)
+}
+
+func TestSyncToDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSyncToDir")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+
+	bh := newBucket()
+	addObject(bh, "archive/2020/a.tgz", "aaaa")
+	b := addObject(bh, "archive/2020/b.tgz", "bbbbbbbb")
+	addObject(bh, "archive/2020/sub/c.tgz", "cccccc")
+	addObject(bh, "other/d.tgz", "dddd")
+
+	// A download of b was interrupted after four bytes.
+	bPartial := partialPath(filepath.Join(dir, "2020/b.tgz"), b.Generation)
+	rtx.Must(os.MkdirAll(filepath.Join(dir, "2020"), 0755), "Could not create dir")
+	rtx.Must(ioutil.WriteFile(bPartial, []byte("bbbb"), 0644), "Could not write")
+
+	calls := 0
+	c := SyncConfig{
+		Workers:  3,
+		Progress: func(p SyncProgress) { calls++ },
+	}
+	p, err := SyncToDir(context.Background(), bh, "archive/", dir, c)
+	rtx.Must(err, "Could not sync")
+	if p.Copied != 3 || p.Skipped != 0 || p.Bytes != 4+4+6 || calls != 3 {
+		t.Errorf("SyncToDir() = %+v with %d Progress calls, want 3 files and 14 bytes", p, calls)
+	}
+	for name, want := range map[string]string{
+		"2020/a.tgz":     "aaaa",
+		"2020/b.tgz":     "bbbbbbbb",
+		"2020/sub/c.tgz": "cccccc",
+	} {
+		if got := readFile(t, filepath.Join(dir, name)); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(bPartial); !os.IsNotExist(err) {
+		t.Errorf("The partial file was not renamed: %v", err)
+	}
+
+	// Nothing changed, except a corrupt and a truncated local file.
+	rtx.Must(ioutil.WriteFile(filepath.Join(dir, "2020/a.tgz"), []byte("aaab"), 0644), "Could not write")
+	rtx.Must(ioutil.WriteFile(filepath.Join(dir, "2020/sub/c.tgz"), []byte("cc"), 0644), "Could not write")
+	p, err = SyncToDir(context.Background(), bh, "archive/", dir, c)
+	rtx.Must(err, "Could not sync")
+	if p.Copied != 2 || p.Skipped != 1 ||
+		readFile(t, filepath.Join(dir, "2020/a.tgz")) != "aaaa" ||
+		readFile(t, filepath.Join(dir, "2020/sub/c.tgz")) != "cccccc" {
+		t.Errorf("SyncToDir() = %+v, want a.tgz and c.tgz copied again and 1 skipped", p)
+	}
+
+	// The object does not match its listed checksum.
+	bh.ObjAttrs[0].MD5 = make([]byte, 16)
+	os.Remove(filepath.Join(dir, "2020/a.tgz"))
+	p, err = SyncToDir(context.Background(), bh, "archive/", dir, c)
+	if err == nil || p.Failed != 1 {
+		t.Errorf("SyncToDir() = %+v, %v, want a checksum error", p, err)
+	}
+	aPartial := partialPath(filepath.Join(dir, "2020/a.tgz"), bh.ObjAttrs[0].Generation)
+	if _, err := os.Stat(aPartial); !os.IsNotExist(err) {
+		t.Errorf("The bad download was kept: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := SyncToDir(ctx, bh, "archive/", dir, c); !errors.Is(err, context.Canceled) {
+		t.Errorf("SyncToDir() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestSyncToDirStalePartial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSyncToDirStalePartial")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+
+	bh := newBucket()
+	addObject(bh, "archive/a.tgz", "old-")
+	bh.ObjAttrs = nil
+	a := addObject(bh, "archive/a.tgz", "new-data")
+	addObject(bh, "archive/b.tgz", "bbbb")
+
+	// Partial downloads of older generations, and files of users that only
+	// end in ".partial".
+	stale := []string{
+		partialPath(filepath.Join(dir, "a.tgz"), a.Generation-1),
+		partialPath(filepath.Join(dir, "gone.tgz"), 1),
+	}
+	rtx.Must(ioutil.WriteFile(stale[0], []byte("old-"), 0644), "Could not write")
+	rtx.Must(ioutil.WriteFile(stale[1], []byte("xx"), 0644), "Could not write")
+	others := []string{
+		filepath.Join(dir, "b.tgz"+partialSuffix),
+		filepath.Join(dir, "notes.x"+partialSuffix),
+		filepath.Join(dir, partialSuffix),
+	}
+	for _, name := range others {
+		rtx.Must(ioutil.WriteFile(name, []byte("keep"), 0644), "Could not write")
+	}
+
+	p, err := SyncToDir(context.Background(), bh, "archive/", dir, SyncConfig{})
+	rtx.Must(err, "Could not sync")
+	if p.Copied != 2 || p.Bytes != 8+4 {
+		t.Errorf("SyncToDir() = %+v, want 2 files and 12 bytes", p)
+	}
+	if got := readFile(t, filepath.Join(dir, "a.tgz")); got != "new-data" {
+		t.Errorf("a.tgz = %q, want new-data", got)
+	}
+	for _, name := range stale {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("The stale partial file %s was kept: %v", name, err)
+		}
+	}
+	for _, name := range others {
+		if got := readFile(t, name); got != "keep" {
+			t.Errorf("%s = %q, want keep", name, got)
+		}
+	}
+}
+
+func TestSyncToDirPartialName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSyncToDirPartialName")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+
+	bh := newBucket()
+	addObject(bh, "archive/a.tgz", "aaaa")
+	addObject(bh, "archive/a.tgz.1"+partialSuffix, "not a partial download")
+	addObject(bh, "archive/b"+partialSuffix, "bb")
+	p, err := SyncToDir(context.Background(), bh, "archive/", dir, SyncConfig{})
+	if err == nil || p.Copied != 2 || p.Failed != 1 {
+		t.Errorf("SyncToDir() = %+v, %v, want the object named like a partial download to fail", p, err)
+	}
+	if got := readFile(t, filepath.Join(dir, "a.tgz")); got != "aaaa" {
+		t.Errorf("a.tgz = %q, want aaaa", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "b"+partialSuffix)); got != "bb" {
+		t.Errorf("b.partial = %q, want bb", got)
+	}
+}
+
+func TestSyncToDirOutside(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSyncToDirOutside")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+	bh := newBucket()
+	addObject(bh, "x/../../escape", "data")
+	if _, err := SyncToDir(context.Background(), bh, "x/", filepath.Join(dir, "sub"), SyncConfig{}); err == nil {
+		t.Error("SyncToDir() should refuse to write outside of its dir")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape")); !os.IsNotExist(err) {
+		t.Errorf("SyncToDir() wrote outside of its dir: %v", err)
+	}
+}
+
+func TestSyncFromDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSyncFromDir")
+	rtx.Must(err, "Could not create tempdir")
+	defer os.RemoveAll(dir)
+	rtx.Must(os.MkdirAll(filepath.Join(dir, "sub"), 0755), "Could not create dir")
+	for name, data := range map[string]string{
+		"a.json":                       "aa",
+		"sub/b.json":                   "bbb",
+		"sub/c.json.1" + partialSuffix: "c",
+	} {
+		rtx.Must(ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644), "Could not write")
+	}
+
+	bh := newBucket()
+	p, err := SyncFromDir(context.Background(), bh, dir, "upload", SyncConfig{Workers: 2})
+	rtx.Must(err, "Could not sync")
+	if p.Copied != 2 || p.Bytes != 5 {
+		t.Errorf("SyncFromDir() = %+v, want 2 files and 5 bytes", p)
+	}
+	for name, want := range map[string]string{
+		"upload/a.json":     "aa",
+		"upload/sub/b.json": "bbb",
+	} {
+		if o, ok := bh.Objs[name]; !ok || o.Data.String() != want {
+			t.Errorf("Object %s is missing or not %q", name, want)
+		}
+	}
+	if len(bh.Objs) != 2 {
+		t.Errorf("SyncFromDir() uploaded %d objects, want 2", len(bh.Objs))
+	}
+
+	rtx.Must(ioutil.WriteFile(filepath.Join(dir, "a.json"), []byte("new"), 0644), "Could not write")
+	p, err = SyncFromDir(context.Background(), bh, dir, "upload", SyncConfig{})
+	rtx.Must(err, "Could not sync")
+	if p.Copied != 1 || p.Skipped != 1 || bh.Objs["upload/a.json"].Data.String() != "new" {
+		t.Errorf("SyncFromDir() = %+v, want a.json uploaded again", p)
+	}
+
+	failing := newBucket()
+	failing.WritesMustFail = true
+	if p, err := SyncFromDir(context.Background(), failing, dir, "upload", SyncConfig{}); err == nil || p.Failed != 2 {
+		t.Errorf("SyncFromDir() = %+v, %v, want 2 failures", p, err)
+	}
+}
@@ -26,12 +26,16 @@ type Object struct {
 // GCS Object name (such as when pathPrefix is a single object), then the Object
 // base name is returned.
 func (o *Object) LocalName() string {
-	if o.ObjectName() == o.prefix {
+	return localName(o.ObjectName(), o.prefix)
+}
+
+func localName(name, prefix string) string {
+	if name == prefix {
 		// For single-file downloads, remove everything but the basename.
-		return path.Base(o.ObjectName())
+		return path.Base(name)
 	}
 	// Remove the initial prefix from object name.
-	return strings.TrimPrefix(o.ObjectName(), o.prefix)
+	return strings.TrimPrefix(name, prefix)
 }
 
 // Copy writes the Object data to the given writer.
@@ -76,9 +80,29 @@ func (b *Bucket) Walk(ctx context.Context, pathPrefix string, visit func(o *Obje
 // walk recursively iterates over every GCS Object in the given bucket whose
 // names begin with prefix. Each Object is passed to `visit`.
 func walk(ctx context.Context, bucket *Bucket, prefix, rootPrefix string, visit func(o *Object) error) error {
-	it := bucket.Objects(ctx, &storage.Query{Prefix: prefix, Delimiter: "/"})
+	list := func(q *storage.Query) func() (*storage.ObjectAttrs, error) {
+		it := bucket.Objects(ctx, q)
+		return func() (*storage.ObjectAttrs, error) {
+			return bucket.itNext(it)
+		}
+	}
+	return walkAttrs(list, prefix, func(attr *storage.ObjectAttrs) error {
+		visit(&Object{
+			ObjectHandle: bucket.Object(attr.Name),
+			ObjectAttrs:  attr,
+			prefix:       rootPrefix,
+		})
+		return nil
+	})
+}
+
+// walkAttrs recursively lists every object whose name begins with prefix, and
+// passes its attributes to visit. The list function returns the iterator of
+// one level of the hierarchy for a query.
+func walkAttrs(list func(q *storage.Query) func() (*storage.ObjectAttrs, error), prefix string, visit func(attr *storage.ObjectAttrs) error) error {
+	next := list(&storage.Query{Prefix: prefix, Delimiter: "/"})
 	for {
-		attr, err := bucket.itNext(it)
+		attr, err := next()
 		if err == iterator.Done {
 			return nil
 		}
@@ -88,17 +112,15 @@ func walk(ctx context.Context, bucket *Bucket, prefix, rootPrefix string, visit
 		}
 		if attr.Name == "" {
 			// Pseudo-directory entries have no name.
-			err = walk(ctx, bucket, attr.Prefix, rootPrefix, visit)
+			err = walkAttrs(list, attr.Prefix, visit)
 			if err != nil {
 				return err
 			}
 		} else if !strings.HasSuffix(attr.Name, "/") {
 			// We found an object.
-			visit(&Object{
-				ObjectHandle: bucket.Object(attr.Name),
-				ObjectAttrs:  attr,
-				prefix:       rootPrefix,
-			})
+			if err = visit(attr); err != nil {
+				return err
+			}
 		}
 	}
 }